@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 
@@ -13,9 +14,14 @@ import (
 )
 
 type APIResponse struct {
-	Success bool        `json:"sucesso"`
-	Message string      `json:"mensagem,omitempty"`
-	Data    interface{} `json:"dados,omitempty"`
+	Success    bool        `json:"sucesso"`
+	Message    string      `json:"mensagem,omitempty"`
+	Data       interface{} `json:"dados,omitempty"`
+	Pagination *struct {
+		Total int    `json:"total"`
+		Next  string `json:"proximo"`
+	} `json:"paginacao,omitempty"`
+	Suggestions []string `json:"sugestoes,omitempty"`
 }
 
 func StartClient(config *Config) error {
@@ -24,7 +30,7 @@ func StartClient(config *Config) error {
 	for {
 		menu := promptui.Select{
 			Label: "Selecione um comando",
-			Items: []string{"LISTAR", "BUSCAR", "INSERIR", "ATUALIZAR"},
+			Items: []string{"LISTAR", "BUSCAR", "PESQUISAR", "INSERIR", "ATUALIZAR", "REMOVER"},
 		}
 
 		_, command, err := menu.Run()
@@ -35,12 +41,34 @@ func StartClient(config *Config) error {
 		switch command {
 
 		case "LISTAR":
-			resp, err := http.Get(baseURL + "/termos")
-			printResponse(resp, err)
+			params := url.Values{}
+			if prefix := readInput("Prefixo (vazio para todos)"); prefix != "" {
+				params.Set("prefix", prefix)
+			}
+			if limit := readInput("Termos por página (vazio para todos)"); limit != "" {
+				params.Set("limit", limit)
+			}
+			if readInput("Ordem alfabética? (s/N)") == "s" {
+				params.Set("sort", "alpha")
+			}
+
+			for {
+				resp, err := http.Get(baseURL + "/termos?" + params.Encode())
+				response := printResponse(resp, err)
+				if response.Pagination == nil || response.Pagination.Next == "" ||
+					readInput("Mostrar a próxima página? (s/N)") != "s" {
+					break
+				}
+				// O cursor já guarda o prefixo e a ordem
+				params = url.Values{"cursor": {response.Pagination.Next}, "limit": {params.Get("limit")}}
+			}
 
 		case "BUSCAR":
-			term := readInput("Digite o termo")
-			resp, err := http.Get(baseURL + "/termos/buscar?termo=" + term)
+			lookupTerm(baseURL, readInput("Digite o termo"))
+
+		case "PESQUISAR":
+			query := readInput("Palavras a buscar (termine com * para buscar pelo começo)")
+			resp, err := http.Get(baseURL + "/termos/busca?q=" + url.QueryEscape(query))
 			printResponse(resp, err)
 
 		case "INSERIR":
@@ -53,7 +81,7 @@ func StartClient(config *Config) error {
 			})
 
 			resp, err := http.Post(
-				baseURL+"/termos/inserir",
+				baseURL+"/termos",
 				"application/json",
 				bytes.NewBuffer(body),
 			)
@@ -62,33 +90,88 @@ func StartClient(config *Config) error {
 		case "ATUALIZAR":
 			term := readInput("Digite o termo")
 			definition := readInput("Digite a nova definição")
+			// A versão mostrada pelo BUSCAR; se outro cliente alterou o termo
+			// depois dela, o servidor recusa com 412
+			version := readInput("Versão esperada (vazio para não conferir)")
 
 			body, _ := json.Marshal(map[string]string{
-				"termo":     term,
 				"definicao": definition,
 			})
 
+			// PATCH só altera termos que existem; PUT criaria o termo
 			req, _ := http.NewRequest(
-				http.MethodPut,
-				baseURL+"/termos/atualizar",
+				http.MethodPatch,
+				baseURL+"/termos/"+url.PathEscape(term),
 				bytes.NewBuffer(body),
 			)
 			req.Header.Set("Content-Type", "application/json")
+			if version != "" {
+				req.Header.Set("If-Match", `"`+version+`"`)
+			}
 
 			resp, err := http.DefaultClient.Do(req)
-			printResponse(resp, err)
+			response := printResponse(resp, err)
+			if suggestion, ok := chooseSuggestion(response.Suggestions); ok {
+				lookupTerm(baseURL, suggestion)
+			}
+
+		case "REMOVER":
+			term := readInput("Digite o termo")
+
+			req, _ := http.NewRequest(
+				http.MethodDelete,
+				baseURL+"/termos/"+url.PathEscape(term),
+				nil,
+			)
+
+			resp, err := http.DefaultClient.Do(req)
+			response := printResponse(resp, err)
+			if suggestion, ok := chooseSuggestion(response.Suggestions); ok {
+				lookupTerm(baseURL, suggestion)
+			}
 		}
 	}
 }
 
-func printResponse(resp *http.Response, err error) {
+// lookupTerm mostra o termo e, se ele não existe, oferece buscar um dos
+// termos parecidos sugeridos pelo servidor.
+func lookupTerm(baseURL, term string) {
+	for {
+		resp, err := http.Get(baseURL + "/termos/" + url.PathEscape(term))
+		response := printResponse(resp, err)
+		suggestion, ok := chooseSuggestion(response.Suggestions)
+		if !ok {
+			return
+		}
+		term = suggestion
+	}
+}
+
+// chooseSuggestion pergunta qual das sugestões buscar; retorna false se não
+// há sugestões ou se o usuário não quer nenhuma.
+func chooseSuggestion(suggestions []string) (string, bool) {
+	if len(suggestions) == 0 {
+		return "", false
+	}
+	menu := promptui.Select{
+		Label: "Buscar um dos termos sugeridos?",
+		Items: append(suggestions, "Não"),
+	}
+	i, _, err := menu.Run()
+	if err != nil || i == len(suggestions) {
+		return "", false
+	}
+	return suggestions[i], true
+}
+
+func printResponse(resp *http.Response, err error) APIResponse {
+	var response APIResponse
 	if err != nil {
 		fmt.Println("Erro de conexão:", err)
-		return
+		return response
 	}
 	defer resp.Body.Close()
 
-	var response APIResponse
 	json.NewDecoder(resp.Body).Decode(&response)
 
 	fmt.Println("\nStatus:", resp.Status)
@@ -102,11 +185,16 @@ func printResponse(resp *http.Response, err error) {
 
 		case []interface{}:
 			if len(data) == 0 {
-				fmt.Println("Sem termos cadastrados")
+				fmt.Println("Nenhum termo encontrado")
 				break
 			}
 			fmt.Println("Dados:")
 			for _, v := range data {
+				// Resultados da busca: o termo e o trecho da definição
+				if result, ok := v.(map[string]interface{}); ok {
+					fmt.Printf(" - %v: %v\n", result["termo"], result["trecho"])
+					continue
+				}
 				fmt.Println(" -", v)
 			}
 
@@ -118,7 +206,16 @@ func printResponse(resp *http.Response, err error) {
 		}
 	}
 
+	if response.Pagination != nil {
+		fmt.Println("Total:", response.Pagination.Total)
+	}
+
+	if len(response.Suggestions) > 0 {
+		fmt.Printf("Você quis dizer: %s?\n", strings.Join(response.Suggestions, ", "))
+	}
+
 	fmt.Println()
+	return response
 }
 
 func readInput(label string) string {
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// importResponse é a resposta de POST /termos/import, com o relatório.
+type importResponse struct {
+	Success bool   `json:"sucesso"`
+	Message string `json:"mensagem"`
+	Data    struct {
+		Errors []struct {
+			Row   int    `json:"linha"`
+			Term  string `json:"termo"`
+			Error string `json:"erro"`
+		} `json:"erros"`
+	} `json:"dados"`
+}
+
+// ImportFile envia o arquivo para POST /termos/import, sem carregá-lo
+// inteiro na memória, e mostra o relatório. Sem format, o formato vem da
+// extensão do arquivo; sem conflict, o servidor mantém os termos que já
+// existem.
+func ImportFile(config *Config, file, format, conflict string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	params := url.Values{"format": {formatOf(file, format)}}
+	if conflict != "" {
+		params.Set("conflict", conflict)
+	}
+	resp, err := http.Post("http://"+config.AddressString()+"/termos/import?"+params.Encode(), "application/octet-stream", f)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response importResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("invalid response (%s): %w", resp.Status, err)
+	}
+	fmt.Println("Status:", resp.Status)
+	fmt.Println(response.Message)
+	for _, e := range response.Data.Errors {
+		if e.Term != "" {
+			fmt.Printf(" - linha %d (%s): %s\n", e.Row, e.Term, e.Error)
+		} else {
+			fmt.Printf(" - linha %d: %s\n", e.Row, e.Error)
+		}
+	}
+	if !response.Success {
+		return fmt.Errorf("import of %s failed with status %d", file, resp.StatusCode)
+	}
+	return nil
+}
+
+// ExportFile grava no arquivo o dicionário de GET /termos/export, à medida
+// que ele chega, ou o escreve na saída padrão se file é vazio. Sem format, o
+// formato vem da extensão do arquivo ou é JSON.
+func ExportFile(config *Config, file, format string) error {
+	params := url.Values{}
+	if format = formatOf(file, format); format != "" {
+		params.Set("format", format)
+	}
+	resp, err := http.Get("http://" + config.AddressString() + "/termos/export?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var response APIResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		return fmt.Errorf("export failed with status %d: %s", resp.StatusCode, response.Message)
+	}
+
+	if file == "" {
+		_, err = io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Dicionário exportado para %s\n", file)
+	return nil
+}
+
+// formatOf retorna o formato pedido ou, se vazio, a extensão do arquivo.
+func formatOf(file, format string) string {
+	if format != "" {
+		return format
+	}
+	return strings.TrimPrefix(filepath.Ext(file), ".")
+}
@@ -34,16 +34,23 @@ func main() {
 	}
 
 	// Define flags
-	mode := flag.String("mode", "", "Mode to run: 'server' or 'client'")
+	mode := flag.String("mode", "", "Mode to run: 'server', 'client', 'import' or 'export'")
 	address := flag.String("address", addrDefault, "Address to bind/connect to")
 	port := flag.Int("port", portDefault, "Port to bind/connect to")
+	store := flag.String("store", "", "Dictionary store (server): 'memory', 'file' (write-ahead log and snapshots) or 'kv' (key-value file); defaults to 'file' with -data-dir and 'memory' without")
+	dataDir := flag.String("data-dir", "", "Directory for the dictionary files (server); empty keeps it in memory only")
+	requestTimeout := flag.Duration("request-timeout", server.DefaultRequestTimeout, "Time limit for acquiring a term lock (server); clients may ask for less in the Timeout header")
+	snapshotEvery := flag.Int("snapshot-every", server.DefaultSnapshotEvery, "Logged operations between dictionary snapshots (server)")
+	file := flag.String("file", "", "File to import from or export to (import/export); export writes to stdout without it")
+	format := flag.String("format", "", "File format (import/export): 'json', 'csv' or 'jsonl'; defaults to the file extension")
+	conflict := flag.String("conflict", "", "What import does with terms that already exist: 'skip' (default), 'overwrite' or 'fail'")
 
 	flag.Parse()
 
 	// Validate mode
 	if *mode == "" {
 		fmt.Println("Error: mode flag is required")
-		fmt.Println("Usage: go run main.go -mode=<server|client> [-address=<address>] [-port=<port>]")
+		fmt.Println("Usage: go run main.go -mode=<server|client|import|export> [-address=<address>] [-port=<port>] [-store=<memory|file|kv>] [-data-dir=<dir>] [-request-timeout=<duration>] [-file=<file>] [-format=<json|csv|jsonl>] [-conflict=<skip|overwrite|fail>]")
 		os.Exit(1)
 	}
 
@@ -52,6 +59,10 @@ func main() {
 		config := server.NewConfig()
 		config.SetAddress(*address)
 		config.SetPort(*port)
+		config.SetStore(*store)
+		config.SetDataDir(*dataDir)
+		config.SetSnapshotEvery(*snapshotEvery)
+		config.SetRequestTimeout(*requestTimeout)
 
 		logger.Info("Starting TCP server", zap.String("address", config.AddressString()))
 		if err := server.StartServer(config); err != nil {
@@ -68,9 +79,28 @@ func main() {
 			logger.Fatal("Failed to start client", zap.Error(err))
 		}
 
+	case "import", "export":
+		config := client.NewConfig()
+		config.SetAddress(*address)
+		config.SetPort(*port)
+
+		var err error
+		if *mode == "import" {
+			if *file == "" {
+				fmt.Println("Error: import requires -file")
+				os.Exit(1)
+			}
+			err = client.ImportFile(config, *file, *format, *conflict)
+		} else {
+			err = client.ExportFile(config, *file, *format)
+		}
+		if err != nil {
+			logger.Fatal("Failed to "+*mode+" dictionary", zap.Error(err))
+		}
+
 	default:
 		fmt.Printf("Error: invalid mode '%s'\n", *mode)
-		fmt.Println("Mode must be either 'server' or 'client'")
+		fmt.Println("Mode must be one of 'server', 'client', 'import' or 'export'")
 		os.Exit(1)
 	}
 }
@@ -1,10 +1,18 @@
 package server
 
-import "strconv"
+import (
+	"strconv"
+	"time"
+)
 
 type Config struct {
-	Address string
-	Port    int
+	Address       string
+	Port          int
+	Store         string // Tipo de armazenamento: memory, file ou kv (vazio escolhe pelo DataDir)
+	DataDir       string // Diretório dos arquivos do dicionário (vazio mantém o dicionário só na memória)
+	SnapshotEvery int    // Operações no log entre dois snapshots
+	// Prazo de cada requisição para obter o lock do termo
+	RequestTimeout time.Duration
 }
 
 func NewConfig() *Config {
@@ -13,8 +21,10 @@ func NewConfig() *Config {
 
 func DefaultConfig() *Config {
 	return &Config{
-		Address: "localhost",
-		Port:    8000,
+		Address:        "localhost",
+		Port:           8000,
+		SnapshotEvery:  DefaultSnapshotEvery,
+		RequestTimeout: DefaultRequestTimeout,
 	}
 }
 
@@ -26,6 +36,22 @@ func (c *Config) SetPort(port int) {
 	c.Port = port
 }
 
+func (c *Config) SetRequestTimeout(timeout time.Duration) {
+	c.RequestTimeout = timeout
+}
+
+func (c *Config) SetStore(store string) {
+	c.Store = store
+}
+
+func (c *Config) SetDataDir(dir string) {
+	c.DataDir = dir
+}
+
+func (c *Config) SetSnapshotEvery(n int) {
+	c.SnapshotEvery = n
+}
+
 func (c *Config) AddressString() string {
 	return c.Address + ":" + strconv.Itoa(c.Port)
 }
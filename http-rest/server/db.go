@@ -1,39 +1,220 @@
 package server
 
+import (
+	"slices"
+	"sync"
+)
+
+// Dictionary guarda o dicionário na memória e é o Store dos tipos
+// StoreMemory e StoreFile; no segundo, storage registra as alterações em disco.
 type Dictionary struct {
-	terms map[string]string
-	keys  []string
+	mu       sync.RWMutex // Protege terms, keys, search e inserted
+	writeMu  sync.Mutex   // Serializa a escrita das alterações e o acesso a storage e revision
+	terms    map[string]dictEntry
+	keys     []string
+	search   *searchIndex
+	inserted uint64   // Última sequência de inserção (ver ListEntry)
+	revision uint64   // Maior versão já atribuída, inclusive a remoções
+	storage  *Storage // nil quando o dicionário fica só na memória
+}
+
+type dictEntry struct {
+	definition string
+	version    uint64
+	seq        uint64
 }
 
 func NewDictionary() *Dictionary {
 	return &Dictionary{
-		terms: make(map[string]string),
-		keys:  []string{},
+		terms:  make(map[string]dictEntry),
+		keys:   []string{},
+		search: newSearchIndex(),
 	}
 }
 
+// List retorna uma cópia de keys, que continua sendo alterada depois que o
+// lock é liberado.
 func (d *Dictionary) List() []string {
-	return d.keys
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.Clone(d.keys)
+}
+
+func (d *Dictionary) ListEntries() []ListEntry {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	entries := make([]ListEntry, len(d.keys))
+	for i, term := range d.keys {
+		entries[i] = ListEntry{Term: term, Seq: d.terms[term].seq}
+	}
+	return entries
+}
+
+func (d *Dictionary) LookUp(term string) (string, uint64, bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	entry, exists := d.terms[term]
+	return entry.definition, entry.version, exists, nil
+}
+
+// Search busca os termos que têm todas as palavras de query (ver search.go) e
+// retorna até limit resultados, do mais relevante ao menos, e o total
+// encontrado. Com limit 0, retorna todos.
+func (d *Dictionary) Search(query string, limit int) ([]SearchResult, int, error) {
+	words := parseSearchQuery(query)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	results := d.search.search(words)
+	total := len(results)
+	if limit > 0 && limit < total {
+		results = results[:limit]
+	}
+	for i := range results {
+		results[i].Snippet = snippet(d.terms[results[i].Term].definition, words)
+	}
+	return results, total, nil
+}
+
+// Range percorre uma cópia do dicionário, então fn pode usar o próprio
+// dicionário sem causar deadlock.
+func (d *Dictionary) Range(fn func(term, definition string) bool) error {
+	d.mu.RLock()
+	entries := make([]snapshotEntry, len(d.keys))
+	for i, term := range d.keys {
+		entries[i] = snapshotEntry{Term: term, Definition: d.terms[term].definition}
+	}
+	d.mu.RUnlock()
+
+	for _, e := range entries {
+		if !fn(e.Term, e.Definition) {
+			break
+		}
+	}
+	return nil
+}
+
+func (d *Dictionary) Close() error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	if d.storage == nil {
+		return nil
+	}
+	d.storage.group.idle()
+	return d.storage.wal.Close()
+}
+
+func (d *Dictionary) exists(term string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, exists := d.terms[term]
+	return exists
+}
+
+func (d *Dictionary) Insert(term, definition string) (uint64, bool, error) {
+	if d.exists(term) {
+		return 0, false, nil
+	}
+	version, err := d.persist(walRecord{Op: walInsert, Term: term, Definition: definition})
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
 }
 
-func (d *Dictionary) LookUp(term string) (string, bool) {
-	definition, exists := d.terms[term]
-	return definition, exists
+func (d *Dictionary) Update(term, newDefinition string) (uint64, bool, error) {
+	if !d.exists(term) {
+		return 0, false, nil
+	}
+	version, err := d.persist(walRecord{Op: walUpdate, Term: term, Definition: newDefinition})
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
 }
 
-func (d *Dictionary) Insert(term, definition string) bool {
-	if _, exists := d.terms[term]; exists {
-		return false
+func (d *Dictionary) Delete(term string) (bool, error) {
+	if !d.exists(term) {
+		return false, nil
+	}
+	if _, err := d.persist(walRecord{Op: walDelete, Term: term}); err != nil {
+		return false, err
 	}
-	d.terms[term] = definition
-	d.keys = append(d.keys, term)
-	return true
+	return true, nil
 }
 
-func (d *Dictionary) Update(term, newDefinition string) bool {
-	if _, exists := d.terms[term]; !exists {
-		return false
+// Apply grava as alterações como um único registro no log, então uma queda no
+// meio da gravação descarta o lote inteiro, e as aplica na memória com um só
+// lock, então List também não vê um lote pela metade.
+func (d *Dictionary) Apply(changes []Change) (uint64, error) {
+	batch := walRecord{Op: walBatch, Batch: make([]walRecord, len(changes))}
+	for i, change := range changes {
+		op := walUpdate
+		if change.Delete {
+			op = walDelete
+		} else if !d.exists(change.Term) {
+			op = walInsert
+		}
+		batch.Batch[i] = walRecord{Op: op, Term: change.Term, Definition: change.Definition}
+	}
+	return d.persist(batch)
+}
+
+// persist atribui a próxima versão à operação, registra-a no log, se houver,
+// e só então a aplica na memória; se o registro falhar, o dicionário não muda.
+// O fsync acontece sem segurar d.mu nem writeMu, então as consultas não
+// esperam pelo disco e as alterações de outros termos são escritas enquanto
+// ele acontece, para serem confirmadas pelo próximo.
+func (d *Dictionary) persist(record walRecord) (uint64, error) {
+	d.writeMu.Lock()
+	record.Version = d.revision + 1
+	if d.storage == nil {
+		d.mu.Lock()
+		d.apply(record)
+		d.mu.Unlock()
+		d.writeMu.Unlock()
+		return record.Version, nil
+	}
+	if err := d.storage.append(record); err != nil {
+		d.writeMu.Unlock()
+		return 0, err
+	}
+	d.revision = record.Version
+	if err := d.storage.group.wait(d.storage); err != nil {
+		return 0, err
+	}
+	return record.Version, nil
+}
+
+// apply altera a memória sem verificar conflitos, para que reaplicar o log
+// sobre um snapshot que já contém as operações não mude o resultado. Como a
+// versão vem do próprio registro, ela também não muda. Registros gravados
+// antes das versões recebem a próxima.
+func (d *Dictionary) apply(record walRecord) {
+	if record.Version == 0 {
+		record.Version = d.revision + 1
+	}
+	d.revision = max(d.revision, record.Version)
+	switch record.Op {
+	case walBatch:
+		for _, change := range record.Batch {
+			change.Version = record.Version
+			d.apply(change)
+		}
+	case walInsert, walUpdate:
+		entry, exists := d.terms[record.Term]
+		if !exists {
+			d.keys = append(d.keys, record.Term)
+			d.inserted++
+			entry.seq = d.inserted
+		}
+		d.terms[record.Term] = dictEntry{definition: record.Definition, version: record.Version, seq: entry.seq}
+		d.search.add(record.Term, record.Definition)
+	case walDelete:
+		if _, exists := d.terms[record.Term]; !exists {
+			return
+		}
+		delete(d.terms, record.Term)
+		d.search.remove(record.Term)
+		d.keys = slices.DeleteFunc(d.keys, func(key string) bool { return key == record.Term })
 	}
-	d.terms[term] = newDefinition
-	return true
 }
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Ordens aceitas na listagem dos termos
+const (
+	SortInsertion = "insertion" // Ordem em que os termos foram inseridos (padrão)
+	SortAlpha     = "alpha"     // Ordem alfabética, byte a byte
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// listQuery seleciona uma página da listagem. Offset e Cursor são as duas
+// formas de dizer onde a página começa: o offset conta termos, então a página
+// seguinte pula ou repete termos se outros forem inseridos ou removidos antes
+// dela; o cursor, devolvido pela página anterior, continua do último termo
+// entregue.
+type listQuery struct {
+	Prefix string
+	Sort   string // SortInsertion ou SortAlpha; vazio usa a do cursor ou SortInsertion
+	Offset int
+	Limit  int // 0 lista até o fim
+	Cursor string
+}
+
+// listPage é uma página da listagem.
+type listPage struct {
+	Terms []string
+	Total int    // Termos com o prefixo, somando todas as páginas
+	Next  string // Cursor da próxima página; vazio na última
+}
+
+// listCursor é o conteúdo do cursor: a ordem e o prefixo da listagem e o
+// último termo entregue, com a sequência da sua inserção (ver ListEntry).
+type listCursor struct {
+	Sort   string `json:"s"`
+	Prefix string `json:"p"`
+	Term   string `json:"t"`
+	Seq    uint64 `json:"q"`
+}
+
+func encodeListCursor(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(value string) (listCursor, error) {
+	var cursor listCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, &cursor) != nil {
+		return listCursor{}, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+// pageTerms seleciona uma página de entries, que vêm na ordem de inserção (ver
+// Store.ListEntries). Com cursor, na ordem alfabética a página começa no
+// primeiro termo depois do último entregue; na de inserção, no primeiro termo
+// inserido depois dele. Assim a página não pula nem repete termos se o último
+// entregue for removido, ou removido e inserido de novo no fim, nem se outros
+// forem inseridos antes dele.
+func pageTerms(entries []ListEntry, query listQuery) (listPage, error) {
+	if query.Offset < 0 || query.Limit < 0 {
+		return listPage{}, errors.New("offset and limit must not be negative")
+	}
+
+	var cursor listCursor
+	if query.Cursor != "" {
+		if query.Offset > 0 {
+			return listPage{}, errors.New("use either an offset or a cursor")
+		}
+		var err error
+		if cursor, err = decodeListCursor(query.Cursor); err != nil {
+			return listPage{}, err
+		}
+		if query.Sort == "" {
+			query.Sort = cursor.Sort
+		}
+		if query.Prefix == "" {
+			query.Prefix = cursor.Prefix
+		}
+		if query.Sort != cursor.Sort || query.Prefix != cursor.Prefix {
+			return listPage{}, errors.New("cursor belongs to a listing with another sort or prefix")
+		}
+	}
+	switch query.Sort {
+	case "":
+		query.Sort = SortInsertion
+	case SortInsertion, SortAlpha:
+	default:
+		return listPage{}, fmt.Errorf("unknown sort %q (use %s or %s)", query.Sort, SortInsertion, SortAlpha)
+	}
+
+	matched := make([]ListEntry, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Term, query.Prefix) {
+			matched = append(matched, entry)
+		}
+	}
+	if query.Sort == SortAlpha {
+		slices.SortFunc(matched, func(a, b ListEntry) int { return strings.Compare(a.Term, b.Term) })
+	}
+
+	start := min(query.Offset, len(matched))
+	if query.Cursor != "" {
+		if query.Sort == SortAlpha {
+			var found bool
+			start, found = slices.BinarySearchFunc(matched, cursor.Term, func(e ListEntry, term string) int {
+				return strings.Compare(e.Term, term)
+			})
+			if found {
+				start++
+			}
+		} else {
+			start, _ = slices.BinarySearchFunc(matched, cursor.Seq+1, func(e ListEntry, seq uint64) int {
+				return cmp.Compare(e.Seq, seq)
+			})
+		}
+	}
+	end := len(matched)
+	if query.Limit > 0 {
+		end = min(start+query.Limit, len(matched))
+	}
+
+	page := listPage{Terms: make([]string, 0, end-start), Total: len(matched)}
+	for _, entry := range matched[start:end] {
+		page.Terms = append(page.Terms, entry.Term)
+	}
+	if end < len(matched) {
+		page.Next = encodeListCursor(listCursor{
+			Sort:   query.Sort,
+			Prefix: query.Prefix,
+			Term:   matched[end-1].Term,
+			Seq:    matched[end-1].Seq,
+		})
+	}
+	return page, nil
+}
@@ -1,39 +1,60 @@
 package server
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"go.uber.org/zap"
 	"tcp/utils"
 )
 
 var (
-	dictionary = NewDictionary()
-	mutex      sync.Mutex
+	dictionary Store = NewDictionary()
+	locks            = NewTermLocks(lockStripes)
+	// Prazo de cada requisição para obter o lock do termo
+	requestTimeout = DefaultRequestTimeout
 )
 
 type APIResponse struct {
-	Success  bool        `json:"sucesso"`
-	Message  string      `json:"mensagem,omitempty"`
-	Data     interface{} `json:"dados,omitempty"`
+	Success     bool        `json:"sucesso"`
+	Message     string      `json:"mensagem,omitempty"`
+	Data        interface{} `json:"dados,omitempty"`
+	Pagination  *Pagination `json:"paginacao,omitempty"`
+	Suggestions []string    `json:"sugestoes,omitempty"` // Termos parecidos, nas respostas 404
+}
+
+// Pagination acompanha a listagem dos termos.
+type Pagination struct {
+	Total int    `json:"total"`             // Termos com o prefixo, somando todas as páginas
+	Next  string `json:"proximo,omitempty"` // Cursor da próxima página; vazio na última
 }
 
 func StartServer(config *Config) error {
 	logger := utils.GetLogger()
 
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/termos", listTerms)
-	mux.HandleFunc("/termos/buscar", lookupTerm)
-	mux.HandleFunc("/termos/inserir", insertTerm)
-	mux.HandleFunc("/termos/atualizar", updateTerm)
+	store, err := OpenStore(config.Store, config.DataDir, config.SnapshotEvery)
+	if err != nil {
+		logger.Warn("Error loading dictionary", zap.Error(err))
+		return err
+	}
+	defer store.Close()
+	dictionary = store
+	if config.RequestTimeout > 0 {
+		requestTimeout = config.RequestTimeout
+	}
 
 	server := &http.Server{
 		Addr:    config.AddressString(),
-		Handler: mux,
+		Handler: newHandler(),
 	}
 
 	logger.Info("Servidor HTTP REST iniciado",
@@ -42,41 +63,405 @@ func StartServer(config *Config) error {
 	return server.ListenAndServe()
 }
 
+// newHandler monta as rotas do servidor sobre o dicionário e os locks
+// globais.
+func newHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Rotas orientadas a recursos: o termo é o recurso e o método diz o que
+	// fazer com ele. GET também atende HEAD
+	mux.HandleFunc("GET /termos", listTerms)
+	mux.HandleFunc("POST /termos", createTerm)
+	// Como as rotas antigas abaixo, a busca e a exportação escondem os termos
+	// "busca" e "export" no GET
+	mux.HandleFunc("GET /termos/busca", searchTerms)
+	mux.HandleFunc("GET /termos/export", exportTerms)
+	mux.HandleFunc("POST /termos/import", importTerms)
+	mux.HandleFunc("GET /termos/{termo}", getTerm)
+	mux.HandleFunc("PUT /termos/{termo}", putTerm)
+	mux.HandleFunc("PATCH /termos/{termo}", patchTerm)
+	mux.HandleFunc("DELETE /termos/{termo}", deleteTerm)
+	// Sem método, as rotas só recebem o que as de cima não atendem
+	mux.HandleFunc("/termos", methodNotAllowed(allowTerms))
+	mux.HandleFunc("/termos/{termo}", methodNotAllowed(allowTerm))
+
+	// Rotas antigas, com o verbo no caminho, mantidas para scripts existentes.
+	// Elas escondem os termos "buscar", "inserir" e "atualizar" só nos
+	// métodos que atendem
+	mux.HandleFunc("GET /termos/buscar", deprecated(lookupTerm))
+	mux.HandleFunc("POST /termos/inserir", deprecated(createTerm))
+	mux.HandleFunc("PUT /termos/atualizar", deprecated(updateTerm))
+	return mux
+}
+
+// Métodos aceitos em /termos e em /termos/{termo}, para o cabeçalho Allow
+const (
+	allowTerms = "GET, HEAD, POST"
+	allowTerm  = "GET, HEAD, PUT, PATCH, DELETE"
+)
+
+// Data em que as rotas antigas foram marcadas como obsoletas (16/10/2026),
+// no formato do cabeçalho Deprecation (RFC 9745)
+const deprecatedSince = "@1792108800"
+
 func writeJSON(w http.ResponseWriter, status int, resp APIResponse) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(resp)
 }
 
-func listTerms(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// lockTerm espera pelo lock do termo até o cliente desconectar ou acabar o
+// prazo do servidor ou o pedido no cabeçalho Timeout. Se desistir, responde
+// 408 (prazo do cliente) ou 503 (prazo do servidor) e retorna false.
+func lockTerm(w http.ResponseWriter, r *http.Request, term string, exclusive bool) (func(), bool) {
+	start := time.Now()
+	ctx, cancel, ok := requestContext(w, r)
+	if !ok {
+		return nil, false
+	}
+	defer cancel()
+
+	lock := locks.For(term)
+	unlock := lock.RUnlock
+	var err error
+	if exclusive {
+		err = lock.Lock(ctx)
+		unlock = lock.Unlock
+	} else {
+		err = lock.RLock(ctx)
+	}
+	if err != nil {
+		writeLockFailure(w, ctx, time.Since(start), "o termo")
+		return nil, false
+	}
+	return unlock, true
+}
+
+// requestContext é o contexto da requisição limitado ao prazo do servidor e
+// ao pedido no cabeçalho Timeout. Se o cabeçalho for inválido, responde 400 e
+// retorna false.
+func requestContext(w http.ResponseWriter, r *http.Request) (context.Context, context.CancelFunc, bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	ctx, cancelClient, err := withClientTimeout(ctx, r.Header.Get(utils.HeaderTimeout))
+	if err != nil {
+		cancel()
+		writeJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Cabeçalho Timeout inválido",
+		})
+		return nil, nil, false
+	}
+	return ctx, func() { cancelClient(); cancel() }, true
+}
+
+// writeLockFailure responde a uma requisição que desistiu de esperar pelos
+// locks depois de waited; what diz pelo que ela esperava.
+func writeLockFailure(w http.ResponseWriter, ctx context.Context, waited time.Duration, what string) {
+	waited = waited.Round(time.Millisecond)
+	status := lockWaitStatus(ctx)
+	message := fmt.Sprintf("Tempo esgotado após %s aguardando %s", waited, what)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+		message = fmt.Sprintf("Dicionário ocupado: desistiu após %s aguardando %s", waited, what)
+	}
+	writeJSON(w, status, APIResponse{
+		Success: false,
+		Message: message,
+	})
+}
+
+// methodNotAllowed responde 405 com os métodos que a rota aceita no cabeçalho
+// Allow.
+func methodNotAllowed(allow string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
 		writeJSON(w, http.StatusMethodNotAllowed, APIResponse{
 			Success: false,
 			Message: "Método não permitido",
 		})
+	}
+}
+
+// deprecated marca as respostas de uma rota antiga com o cabeçalho
+// Deprecation e um Link para /termos, e registra quem ainda a usa.
+func deprecated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("Deprecated route used",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("remote_addr", r.RemoteAddr))
+		w.Header().Set("Deprecation", deprecatedSince)
+		w.Header().Set("Link", `</termos>; rel="successor-version"`)
+		handler(w, r)
+	}
+}
+
+// termPath é o caminho do recurso do termo, usado no cabeçalho Location.
+func termPath(term string) string {
+	return "/termos/" + url.PathEscape(term)
+}
+
+// termPayload é o corpo JSON de POST, PUT e PATCH. Quando o termo está no
+// caminho, o campo termo pode ser omitido.
+type termPayload struct {
+	Termo     string `json:"termo"`
+	Definicao string `json:"definicao"`
+}
+
+// readPayload lê o corpo JSON; se não conseguir, responde 400 e retorna
+// false.
+func readPayload(w http.ResponseWriter, r *http.Request) (term, definition string, ok bool) {
+	var payload termPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "JSON inválido",
+		})
+		return "", "", false
+	}
+	return strings.TrimSpace(payload.Termo), strings.TrimSpace(payload.Definicao), true
+}
+
+// readPathPayload lê o termo do caminho e a definição do corpo. Se o corpo
+// também trouxer o termo, ele deve ser o mesmo do caminho.
+func readPathPayload(w http.ResponseWriter, r *http.Request) (term, definition string, ok bool) {
+	term = strings.TrimSpace(r.PathValue("termo"))
+	bodyTerm, definition, ok := readPayload(w, r)
+	if !ok {
+		return "", "", false
+	}
+	if bodyTerm != "" && bodyTerm != term {
+		writeJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "O termo do corpo é diferente do termo do caminho",
+		})
+		return "", "", false
+	}
+	return term, definition, true
+}
+
+// listTerms atende GET /termos?prefix=&sort=&offset=&limit=&cursor=. Sem
+// limit, lista todos os termos a partir de offset. Quando há mais termos, o
+// cursor da próxima página vem em paginacao e no cabeçalho Link.
+func listTerms(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := listQuery{
+		Prefix: params.Get("prefix"),
+		Sort:   params.Get("sort"),
+		Cursor: params.Get("cursor"),
+	}
+	for name, target := range map[string]*int{"offset": &query.Offset, "limit": &query.Limit} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Parâmetro %s inválido", name),
+			})
+			return
+		}
+		*target = n
+	}
+
+	page, err := pageTerms(dictionary.ListEntries(), query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Listagem inválida: " + err.Error(),
+		})
+		return
+	}
+
+	if page.Next != "" {
+		next := url.Values{"cursor": {page.Next}, "limit": {strconv.Itoa(query.Limit)}}
+		w.Header().Set("Link", fmt.Sprintf(`</termos?%s>; rel="next"`, next.Encode()))
+	}
+	writeJSON(w, http.StatusOK, APIResponse{
+		Success:    true,
+		Data:       page.Terms,
+		Pagination: &Pagination{Total: page.Total, Next: page.Next},
+	})
+}
+
+// Máximo de resultados de uma busca
+const maxSearchLimit = 100
+
+// searchTerms atende GET /termos/busca?q=&limit=: os termos com todas as
+// palavras de q, no termo ou na definição, do mais relevante ao menos, com um
+// trecho da definição (ver search.go).
+func searchTerms(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "A busca não pode ser vazia",
+		})
 		return
 	}
+	limit := DefaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			writeJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Parâmetro limit inválido: use de 1 a %d", maxSearchLimit),
+			})
+			return
+		}
+		limit = n
+	}
 
-	mutex.Lock()
-	terms := dictionary.List()
-	mutex.Unlock()
+	results, total, err := dictionary.Search(query, limit)
+	if err != nil {
+		logger.Error("Error reading dictionary", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Erro ao ler o dicionário",
+		})
+		return
+	}
 
+	data := make([]map[string]interface{}, len(results))
+	for i, result := range results {
+		data[i] = map[string]interface{}{
+			"termo":      result.Term,
+			"trecho":     result.Snippet,
+			"relevancia": result.Score,
+		}
+	}
 	writeJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    terms,
+		Success:    true,
+		Data:       data,
+		Pagination: &Pagination{Total: total},
 	})
 }
 
-func lookupTerm(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, APIResponse{
+// Tamanho máximo do arquivo recebido por POST /termos/import
+const maxImportSize = 32 << 20
+
+// exportTerms atende GET /termos/export?format=: o dicionário inteiro, em
+// JSON (padrão), CSV ou JSONL, escrito à medida que os termos são lidos.
+func exportTerms(w http.ResponseWriter, r *http.Request) {
+	format, err := ParseFormat(cmp.Or(r.URL.Query().Get("format"), FormatJSON))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Message: "Método não permitido",
+			Message: "Formato inválido: use json, csv ou jsonl",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="termos.%s"`, format))
+	count, err := ExportTerms(w, dictionary, format)
+	if err != nil {
+		// O status já foi enviado, então o cliente recebe o arquivo incompleto
+		logger.Error("Error exporting dictionary", zap.Error(err))
+		return
+	}
+	logger.Info("Dictionary exported", zap.String("format", format), zap.Int("terms", count))
+}
+
+// importTerms atende POST /termos/import?format=&conflict=, com o arquivo no
+// corpo. Sem format, o formato vem do Content-Type. Responde com o relatório
+// da importação em dados: 200 se ela foi concluída, mesmo com linhas
+// inválidas, e 409 se conflict=fail e nada foi importado.
+func importTerms(w http.ResponseWriter, r *http.Request) {
+	format, err := ParseFormat(cmp.Or(r.URL.Query().Get("format"), formatFromContentType(r.Header.Get("Content-Type"))))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Formato inválido: use json, csv ou jsonl",
 		})
 		return
 	}
+	conflict, err := ParseConflict(r.URL.Query().Get("conflict"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Política de conflito inválida: use skip, overwrite ou fail",
+		})
+		return
+	}
+
+	start := time.Now()
+	ctx, cancel, ok := requestContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+	report, err := ImportTerms(ctx, http.MaxBytesReader(w, r.Body, maxImportSize), dictionary, locks, format, conflict)
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Message: fmt.Sprintf("Importação concluída: %d inseridos, %d substituídos, %d ignorados, %d erros",
+				report.Inserted, report.Overwritten, report.Skipped, len(report.Errors)),
+			Data: report,
+		})
+
+	case errors.As(err, &tooLarge):
+		writeJSON(w, http.StatusRequestEntityTooLarge, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Arquivo maior que %d bytes", tooLarge.Limit),
+		})
+
+	case errors.Is(err, errImportConflict):
+		writeJSON(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Message: "Importação cancelada: nenhum termo foi importado",
+			Data:    report,
+		})
+
+	case errors.Is(err, errMalformedImport):
+		writeJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Arquivo inválido: " + err.Error(),
+		})
+
+	case ctx.Err() != nil:
+		writeLockFailure(w, ctx, time.Since(start), "os termos importados")
+
+	default:
+		logger.Error("Error importing dictionary", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: "Erro ao salvar o dicionário",
+		})
+	}
+}
+
+// formatFromContentType deduz o formato da importação do Content-Type.
+func formatFromContentType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return FormatCSV
+	case "application/x-ndjson", "application/jsonl":
+		return FormatJSONL
+	case "application/json":
+		return FormatJSON
+	default:
+		return ""
+	}
+}
+
+// getTerm atende GET /termos/{termo}.
+func getTerm(w http.ResponseWriter, r *http.Request) {
+	writeTerm(w, r, strings.TrimSpace(r.PathValue("termo")))
+}
+
+// lookupTerm atende a rota antiga GET /termos/buscar?termo=.
+func lookupTerm(w http.ResponseWriter, r *http.Request) {
+	writeTerm(w, r, strings.TrimSpace(r.URL.Query().Get("termo")))
+}
 
-	term := strings.TrimSpace(r.URL.Query().Get("termo"))
+func writeTerm(w http.ResponseWriter, r *http.Request, term string) {
 	if term == "" {
 		writeJSON(w, http.StatusBadRequest, APIResponse{
 			Success: false,
@@ -85,65 +470,71 @@ func lookupTerm(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mutex.Lock()
-	definition, ok := dictionary.LookUp(term)
-	mutex.Unlock()
+	unlock, acquired := lockTerm(w, r, term, false)
+	if !acquired {
+		return
+	}
+	definition, version, ok, err := dictionary.LookUp(term)
+	unlock()
 
-	if !ok {
-		writeJSON(w, http.StatusNotFound, APIResponse{
+	if err != nil {
+		logger.Error("Error reading dictionary", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Message: "Termo não encontrado",
+			Message: "Erro ao ler o dicionário",
 		})
 		return
 	}
 
+	if !ok {
+		writeNotFound(w, term)
+		return
+	}
+
+	w.Header().Set("ETag", utils.FormatETag(version))
 	writeJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Data: map[string]string{
+		Data: map[string]interface{}{
 			"termo":     term,
 			"definicao": definition,
+			"versao":    version,
 		},
 	})
 }
 
-func insertTerm(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, APIResponse{
-			Success: false,
-			Message: "Método não permitido",
-		})
+// createTerm atende POST /termos e a rota antiga POST /termos/inserir.
+func createTerm(w http.ResponseWriter, r *http.Request) {
+	term, definition, ok := readPayload(w, r)
+	if !ok {
 		return
 	}
 
-	var payload struct {
-		Termo     string `json:"termo"`
-		Definicao string `json:"definicao"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+	if term == "" || definition == "" {
 		writeJSON(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Message: "JSON inválido",
+			Message: "Termo e definição não podem ser vazios",
 		})
 		return
 	}
 
-	term := strings.TrimSpace(payload.Termo)
-	definition := strings.TrimSpace(payload.Definicao)
+	unlock, acquired := lockTerm(w, r, term, true)
+	if !acquired {
+		return
+	}
+	version, ok, err := dictionary.Insert(term, definition)
+	unlock()
 
-	if term == "" || definition == "" {
-		writeJSON(w, http.StatusBadRequest, APIResponse{
+	if err != nil {
+		logger.Error("Error saving dictionary", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Message: "Termo e definição não podem ser vazios",
+			Message: "Erro ao salvar o dicionário",
 		})
 		return
 	}
 
-	mutex.Lock()
-	ok := dictionary.Insert(term, definition)
-	mutex.Unlock()
-
 	if !ok {
+		w.Header().Set("Location", termPath(term))
 		writeJSON(w, http.StatusConflict, APIResponse{
 			Success: false,
 			Message: "O termo já existe",
@@ -151,51 +542,173 @@ func insertTerm(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Location", termPath(term))
+	w.Header().Set("ETag", utils.FormatETag(version))
 	writeJSON(w, http.StatusCreated, APIResponse{
 		Success: true,
 		Message: "Termo inserido com sucesso",
+		Data:    map[string]uint64{"versao": version},
 	})
 }
 
+// putTerm atende PUT /termos/{termo}: substitui a definição do termo e, se
+// ele não existe e não há If-Match, o cria.
+func putTerm(w http.ResponseWriter, r *http.Request) {
+	term, definition, ok := readPathPayload(w, r)
+	if !ok {
+		return
+	}
+	saveTerm(w, r, term, definition, true)
+}
+
+// patchTerm atende PATCH /termos/{termo}: altera a definição de um termo que
+// já existe.
+func patchTerm(w http.ResponseWriter, r *http.Request) {
+	term, definition, ok := readPathPayload(w, r)
+	if !ok {
+		return
+	}
+	saveTerm(w, r, term, definition, false)
+}
+
+// updateTerm atende a rota antiga PUT /termos/atualizar, com o termo no
+// corpo. Como o PATCH, não cria o termo.
 func updateTerm(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		writeJSON(w, http.StatusMethodNotAllowed, APIResponse{
+	term, definition, ok := readPayload(w, r)
+	if !ok {
+		return
+	}
+	saveTerm(w, r, term, definition, false)
+}
+
+// saveTerm grava a definição do termo e responde 200, ou 201 com Location se
+// create e o termo foi inserido.
+func saveTerm(w http.ResponseWriter, r *http.Request, term, definition string, create bool) {
+	if term == "" || definition == "" {
+		writeJSON(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Message: "Método não permitido",
+			Message: "Termo e definição não podem ser vazios",
 		})
 		return
 	}
 
-	var payload struct {
-		Termo     string `json:"termo"`
-		Definicao string `json:"definicao"`
+	// Com If-Match, a definição só muda se o termo ainda estiver na versão
+	// que o cliente leu
+	var expected uint64
+	if ifMatch := r.Header.Get(utils.HeaderIfMatch); ifMatch != "" {
+		var err error
+		if expected, err = utils.ParseVersion(ifMatch); err != nil {
+			writeJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "Cabeçalho If-Match inválido",
+			})
+			return
+		}
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		writeJSON(w, http.StatusBadRequest, APIResponse{
+	unlock, acquired := lockTerm(w, r, term, true)
+	if !acquired {
+		return
+	}
+	version, current, status, err := saveIfVersion(term, definition, expected, create)
+	unlock()
+
+	if err != nil {
+		logger.Error("Error saving dictionary", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Message: "JSON inválido",
+			Message: "Erro ao salvar o dicionário",
 		})
 		return
 	}
 
-	term := strings.TrimSpace(payload.Termo)
-	definition := strings.TrimSpace(payload.Definicao)
+	switch status {
+	case http.StatusPreconditionFailed:
+		w.Header().Set("ETag", utils.FormatETag(current))
+		writeJSON(w, status, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("O termo foi alterado por outro cliente: a versão atual é %d", current),
+		})
+
+	case http.StatusNotFound:
+		writeNotFound(w, term)
+
+	case http.StatusCreated:
+		w.Header().Set("Location", termPath(term))
+		w.Header().Set("ETag", utils.FormatETag(version))
+		writeJSON(w, status, APIResponse{
+			Success: true,
+			Message: "Termo inserido com sucesso",
+			Data:    map[string]uint64{"versao": version},
+		})
+
+	default:
+		w.Header().Set("ETag", utils.FormatETag(version))
+		writeJSON(w, status, APIResponse{
+			Success: true,
+			Message: "Definição atualizada com sucesso",
+			Data:    map[string]uint64{"versao": version},
+		})
+	}
+}
+
+// saveIfVersion grava a definição e retorna o status da resposta: 200 se
+// atualizou o termo, 201 se o inseriu (só com create e sem expected), 404 se
+// ele não existe e 412 se expected não é 0 nem a versão atual, que então é
+// retornada em current. Quem chama segura o lock do termo, então a versão não
+// muda entre a conferência e a gravação.
+func saveIfVersion(term, definition string, expected uint64, create bool) (version, current uint64, status int, err error) {
+	_, current, exists, err := dictionary.LookUp(term)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	switch {
+	case !exists && create && expected == 0:
+		version, _, err = dictionary.Insert(term, definition)
+		return version, 0, http.StatusCreated, err
+	case !exists:
+		return 0, 0, http.StatusNotFound, nil
+	case expected != 0 && current != expected:
+		return 0, current, http.StatusPreconditionFailed, nil
+	}
+	version, _, err = dictionary.Update(term, definition)
+	return version, 0, http.StatusOK, err
+}
+
+// deleteTerm atende DELETE /termos/{termo}.
+func deleteTerm(w http.ResponseWriter, r *http.Request) {
+	term := strings.TrimSpace(r.PathValue("termo"))
+	if term == "" {
+		writeJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "O termo não pode ser vazio",
+		})
+		return
+	}
 
-	mutex.Lock()
-	ok := dictionary.Update(term, definition)
-	mutex.Unlock()
+	unlock, acquired := lockTerm(w, r, term, true)
+	if !acquired {
+		return
+	}
+	ok, err := dictionary.Delete(term)
+	unlock()
 
-	if !ok {
-		writeJSON(w, http.StatusNotFound, APIResponse{
+	if err != nil {
+		logger.Error("Error saving dictionary", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Message: "Termo não encontrado",
+			Message: "Erro ao salvar o dicionário",
 		})
 		return
 	}
 
+	if !ok {
+		writeNotFound(w, term)
+		return
+	}
+
 	writeJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: "Definição atualizada com sucesso",
+		Message: "Termo removido com sucesso",
 	})
 }
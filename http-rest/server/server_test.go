@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"tcp/utils"
+)
+
+func silenceLogger(t *testing.T) {
+	previous := logger
+	t.Cleanup(func() { logger = previous })
+	logger = zap.NewNop()
+}
+
+// useDictionary troca o dicionário global por um só com go=linguagem, na
+// versão 1, até o fim do teste.
+func useDictionary(t *testing.T) {
+	previous := dictionary
+	t.Cleanup(func() { dictionary = previous })
+	dictionary = NewDictionary()
+	dictionary.Insert("go", "linguagem")
+}
+
+// As rotas por recurso e as antigas respondem com os status e cabeçalhos
+// documentados. As antigas levam Deprecation e Link, e escondem os termos
+// "buscar", "inserir" e "atualizar" só nos métodos que atendem.
+func TestRoutes(t *testing.T) {
+	silenceLogger(t)
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		header  map[string]string
+		status  int
+		want    map[string]string // Cabeçalhos esperados; "" é ausente
+		message string            // Trecho esperado da mensagem
+	}{
+		{"get", "GET", "/termos/go", "", nil, http.StatusOK, map[string]string{"ETag": `"1"`, "Deprecation": ""}, ""},
+		{"get missing", "GET", "/termos/rust", "", nil, http.StatusNotFound, nil, "não encontrado"},
+		{"head", "HEAD", "/termos/go", "", nil, http.StatusOK, map[string]string{"ETag": `"1"`}, ""},
+		{"post", "POST", "/termos", `{"termo": "rust", "definicao": "sistemas"}`, nil, http.StatusCreated, map[string]string{"Location": "/termos/rust", "ETag": `"2"`}, "inserido"},
+		{"post existing", "POST", "/termos", `{"termo": "go", "definicao": "outra"}`, nil, http.StatusConflict, map[string]string{"Location": "/termos/go"}, "já existe"},
+		{"post invalid JSON", "POST", "/termos", `{"termo":`, nil, http.StatusBadRequest, nil, "JSON inválido"},
+		{"put creates", "PUT", "/termos/rust", `{"definicao": "sistemas"}`, nil, http.StatusCreated, map[string]string{"Location": "/termos/rust", "ETag": `"2"`}, ""},
+		{"put updates", "PUT", "/termos/go", `{"definicao": "alterada"}`, nil, http.StatusOK, map[string]string{"ETag": `"2"`, "Location": ""}, "atualizada"},
+		{"put with other body term", "PUT", "/termos/go", `{"termo": "rust", "definicao": "alterada"}`, nil, http.StatusBadRequest, nil, "diferente"},
+		{"put if-match", "PUT", "/termos/go", `{"definicao": "alterada"}`, map[string]string{"If-Match": `"1"`}, http.StatusOK, map[string]string{"ETag": `"2"`}, ""},
+		{"put stale if-match", "PUT", "/termos/go", `{"definicao": "alterada"}`, map[string]string{"If-Match": `"7"`}, http.StatusPreconditionFailed, map[string]string{"ETag": `"1"`}, "versão atual é 1"},
+		{"put if-match missing term", "PUT", "/termos/rust", `{"definicao": "sistemas"}`, map[string]string{"If-Match": `"1"`}, http.StatusNotFound, nil, ""},
+		{"put invalid if-match", "PUT", "/termos/go", `{"definicao": "alterada"}`, map[string]string{"If-Match": "x"}, http.StatusBadRequest, nil, "If-Match"},
+		{"patch", "PATCH", "/termos/go", `{"definicao": "alterada"}`, nil, http.StatusOK, map[string]string{"ETag": `"2"`}, ""},
+		{"patch missing", "PATCH", "/termos/rust", `{"definicao": "sistemas"}`, nil, http.StatusNotFound, nil, ""},
+		{"delete", "DELETE", "/termos/go", "", nil, http.StatusOK, nil, "removido"},
+		{"delete missing", "DELETE", "/termos/rust", "", nil, http.StatusNotFound, nil, ""},
+		{"list", "GET", "/termos", "", nil, http.StatusOK, nil, ""},
+		{"terms not allowed", "DELETE", "/termos", "", nil, http.StatusMethodNotAllowed, map[string]string{"Allow": allowTerms}, "não permitido"},
+		{"term not allowed", "POST", "/termos/go", "", nil, http.StatusMethodNotAllowed, map[string]string{"Allow": allowTerm}, "não permitido"},
+
+		{"old lookup", "GET", "/termos/buscar?termo=go", "", nil, http.StatusOK, map[string]string{"ETag": `"1"`, "Deprecation": deprecatedSince, "Link": `</termos>; rel="successor-version"`}, ""},
+		{"old lookup missing", "GET", "/termos/buscar?termo=rust", "", nil, http.StatusNotFound, map[string]string{"Deprecation": deprecatedSince}, ""},
+		{"old insert", "POST", "/termos/inserir", `{"termo": "rust", "definicao": "sistemas"}`, nil, http.StatusCreated, map[string]string{"Location": "/termos/rust", "Deprecation": deprecatedSince}, ""},
+		{"old update", "PUT", "/termos/atualizar", `{"termo": "go", "definicao": "alterada"}`, nil, http.StatusOK, map[string]string{"ETag": `"2"`, "Deprecation": deprecatedSince}, ""},
+		{"old update stale if-match", "PUT", "/termos/atualizar", `{"termo": "go", "definicao": "alterada"}`, map[string]string{"If-Match": `"7"`}, http.StatusPreconditionFailed, map[string]string{"ETag": `"1"`, "Deprecation": deprecatedSince}, ""},
+		{"old update does not create", "PUT", "/termos/atualizar", `{"termo": "rust", "definicao": "sistemas"}`, nil, http.StatusNotFound, map[string]string{"Deprecation": deprecatedSince}, ""},
+		// Nos outros métodos, os caminhos antigos são termos comuns
+		{"term named buscar", "PUT", "/termos/buscar", `{"definicao": "procurar"}`, nil, http.StatusCreated, map[string]string{"Location": "/termos/buscar", "Deprecation": ""}, ""},
+		{"term named inserir", "GET", "/termos/inserir", "", nil, http.StatusNotFound, map[string]string{"Deprecation": ""}, ""},
+		{"term named atualizar", "DELETE", "/termos/atualizar", "", nil, http.StatusNotFound, map[string]string{"Deprecation": ""}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useDictionary(t)
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			for key, value := range tt.header {
+				request.Header.Set(key, value)
+			}
+			recorder := httptest.NewRecorder()
+			newHandler().ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d %s, want %d", recorder.Code, recorder.Body, tt.status)
+			}
+			for key, want := range tt.want {
+				if got := recorder.Header().Get(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+			if tt.method == "HEAD" {
+				return
+			}
+			var response APIResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("body %q: %v", recorder.Body, err)
+			}
+			if response.Success != (tt.status < 300) {
+				t.Errorf("sucesso = %v with status %d", response.Success, tt.status)
+			}
+			if !strings.Contains(response.Message, tt.message) {
+				t.Errorf("mensagem = %q, want it to contain %q", response.Message, tt.message)
+			}
+		})
+	}
+}
+
+// saveIfVersion só grava se a versão esperada for a atual, e nunca cria um
+// termo quando há versão esperada.
+func TestSaveIfVersion(t *testing.T) {
+	silenceLogger(t)
+	tests := []struct {
+		name       string
+		term       string
+		expected   uint64
+		create     bool
+		status     int
+		version    uint64
+		current    uint64
+		definition string // Definição de term depois da chamada; "" se não existe
+	}{
+		{"update", "go", 0, false, http.StatusOK, 2, 0, "nova"},
+		{"update at version", "go", 1, false, http.StatusOK, 2, 0, "nova"},
+		{"stale version", "go", 5, true, http.StatusPreconditionFailed, 0, 1, "linguagem"},
+		{"create", "rust", 0, true, http.StatusCreated, 2, 0, "nova"},
+		{"missing", "rust", 0, false, http.StatusNotFound, 0, 0, ""},
+		{"missing with version", "rust", 1, true, http.StatusNotFound, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useDictionary(t)
+			version, current, status, err := saveIfVersion(tt.term, "nova", tt.expected, tt.create)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.status || version != tt.version || current != tt.current {
+				t.Errorf("saveIfVersion = version %d, current %d, status %d; want %d, %d, %d",
+					version, current, status, tt.version, tt.current, tt.status)
+			}
+			definition, _, _, err := dictionary.LookUp(tt.term)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if definition != tt.definition {
+				t.Errorf("definition = %q, want %q", definition, tt.definition)
+			}
+		})
+	}
+}
+
+// Com dois clientes que leram a mesma versão, só o primeiro PUT com If-Match
+// grava; o segundo recebe 412 com a versão atual no ETag.
+func TestPutIfMatchConflict(t *testing.T) {
+	silenceLogger(t)
+	useDictionary(t)
+	handler := newHandler()
+	put := func(definition string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("PUT", "/termos/go", strings.NewReader(`{"definicao": "`+definition+`"}`))
+		request.Header.Set(utils.HeaderIfMatch, utils.FormatETag(1))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+	if first := put("primeiro"); first.Code != http.StatusOK {
+		t.Fatalf("first PUT = %d %s", first.Code, first.Body)
+	}
+	second := put("segundo")
+	if second.Code != http.StatusPreconditionFailed || second.Header().Get("ETag") != `"2"` {
+		t.Errorf("second PUT = %d with ETag %q, want 412 with \"2\"", second.Code, second.Header().Get("ETag"))
+	}
+	if definition, _, _, _ := dictionary.LookUp("go"); definition != "primeiro" {
+		t.Errorf("definition = %q, want primeiro", definition)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Store é o armazenamento do dicionário usado por ProcessDictCommand. As
+// implementações podem ser usadas por várias goroutines, mas Insert, Update e
+// Delete verificam a existência do termo antes de alterá-lo, então quem as usa
+// serializa as operações sobre um mesmo termo (ver TermLocks).
+//
+// Cada alteração recebe uma versão maior que todas as anteriores do
+// armazenamento, e a versão de um termo é a da última alteração que o inseriu
+// ou atualizou. Assim a versão de um termo só cresce, mesmo que ele seja
+// removido e inserido de novo, e serve para detectar atualizações perdidas.
+type Store interface {
+	// List retorna uma cópia dos termos, na ordem em que foram inseridos
+	List() []string
+	// ListEntries é como List, mas com a sequência de inserção de cada termo
+	ListEntries() []ListEntry
+	// LookUp retorna a definição e a versão do termo
+	LookUp(term string) (string, uint64, bool, error)
+	// Insert retorna a versão do termo, ou false se ele já existe
+	Insert(term, definition string) (uint64, bool, error)
+	// Update retorna a nova versão; Update e Delete retornam false se o termo
+	// não existe
+	Update(term, newDefinition string) (uint64, bool, error)
+	Delete(term string) (bool, error)
+	// Apply aplica as alterações de uma só vez, inclusive no disco: depois de
+	// uma queda, ou todas aparecem ou nenhuma. Não confere a existência dos
+	// termos, e todas recebem a mesma versão, que é retornada
+	Apply(changes []Change) (uint64, error)
+	// Search busca as palavras de query nos termos e definições e retorna até
+	// limit resultados (0 para todos), do mais relevante ao menos, e o total
+	// encontrado
+	Search(query string, limit int) ([]SearchResult, int, error)
+	// Range chama fn para cada termo, na ordem de List, até fn retornar false
+	Range(fn func(term, definition string) bool) error
+	Close() error
+}
+
+// ListEntry é um termo de Store.ListEntries. Seq cresce na ordem de List: cada
+// inserção recebe um número maior que todos os anteriores, e um termo removido
+// e inserido de novo vai para o fim com um novo número. Como a listagem com
+// cursor (ver list.go), a sequência só vale enquanto o armazenamento está
+// aberto; na abertura os termos são numerados de novo, na mesma ordem.
+type ListEntry struct {
+	Term string
+	Seq  uint64
+}
+
+// Change é uma alteração de um lote aplicado por Store.Apply. Com Delete, o
+// termo é removido e Definition é ignorada; senão o termo passa a ter
+// Definition, existindo antes ou não.
+type Change struct {
+	Term       string
+	Definition string
+	Delete     bool
+}
+
+// Tipos de armazenamento aceitos por OpenStore
+const (
+	StoreMemory = "memory" // Só na memória
+	StoreFile   = "file"   // Memória com write-ahead log e snapshots (ver storage.go)
+	StoreKV     = "kv"     // Arquivo chave-valor com índice na memória (ver kvstore.go)
+)
+
+const kvFileName = "dictionary.db"
+
+// OpenStore abre o armazenamento do tipo kind em dir. Sem kind, usa
+// StoreFile se houver dir e StoreMemory caso contrário.
+func OpenStore(kind, dir string, snapshotEvery int) (Store, error) {
+	if kind == "" {
+		kind = StoreMemory
+		if dir != "" {
+			kind = StoreFile
+		}
+	}
+	if kind != StoreMemory && dir == "" {
+		return nil, fmt.Errorf("store %q requires a data dir", kind)
+	}
+	switch kind {
+	case StoreMemory:
+		return NewDictionary(), nil
+	case StoreFile:
+		return OpenDictionary(dir, snapshotEvery)
+	case StoreKV:
+		return OpenKVStore(filepath.Join(dir, kvFileName))
+	default:
+		return nil, fmt.Errorf("unknown store %q (use %s, %s or %s)", kind, StoreMemory, StoreFile, StoreKV)
+	}
+}
@@ -1,10 +1,11 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
-	"sync"
 	"tcp/utils"
 	"time"
 
@@ -33,7 +34,10 @@ func ToUppercase(data string) string {
 	termo simultaneamente, assegurando a integridade transacional dos dados.
 */
 
-func ProcessDictCommand(request *utils.HTTPRequest, dict *Dictionary, mux *sync.Mutex) utils.HTTPResponse {
+// ProcessDictCommand executa o comando sobre o dicionário. A espera pelo lock
+// do termo termina com o prazo ou o cancelamento de ctx e, se o cliente
+// informou o cabeçalho Timeout, também com esse prazo.
+func ProcessDictCommand(ctx context.Context, request *utils.HTTPRequest, dict Store, locks *TermLocks) utils.HTTPResponse {
 	startTime := time.Now()
 	var response utils.HTTPResponse
 
@@ -53,17 +57,9 @@ func ProcessDictCommand(request *utils.HTTPRequest, dict *Dictionary, mux *sync.
 
 	switch command {
 	case "LIST":
-		for !mux.TryLock() {
-			if time.Since(startTime) > 30*time.Second {
-				response = utils.HTTPResponse{
-					StatusCode: http.StatusRequestTimeout,
-					Message:    "Timeout while trying to access dictionary",
-				}
-				return response
-			}
-		}
+		// Não precisa do lock de nenhum termo: o Store devolve um estado
+		// consistente mesmo com alterações em andamento
 		terms := dict.List()
-		mux.Unlock()
 		keys := "[" + strings.Join(terms, ", ") + "]"
 
 		response = utils.HTTPResponse{
@@ -73,17 +69,21 @@ func ProcessDictCommand(request *utils.HTTPRequest, dict *Dictionary, mux *sync.
 		return response
 
 	case "LOOKUP":
-		for !mux.TryLock() {
-			if time.Since(startTime) > 30*time.Second {
-				response = utils.HTTPResponse{
-					StatusCode: http.StatusRequestTimeout,
-					Message:    "Timeout while trying to access dictionary",
-				}
-				return response
+		lock := locks.For(term)
+		if err := lock.RLock(ctx); err != nil {
+			response = lockFailure(ctx, term, time.Since(startTime))
+			return response
+		}
+		definition, _, exists, err := dict.LookUp(term)
+		lock.RUnlock()
+
+		if err != nil {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "Error reading dictionary: " + err.Error(),
 			}
+			return response
 		}
-		definition, exists := dict.LookUp(term)
-		mux.Unlock()
 
 		if !exists {
 			response = utils.HTTPResponse{
@@ -108,18 +108,21 @@ func ProcessDictCommand(request *utils.HTTPRequest, dict *Dictionary, mux *sync.
 			return response
 		}
 
-		for !mux.TryLock() {
-			if time.Since(startTime) > 30*time.Second {
-				response = utils.HTTPResponse{
-					StatusCode: http.StatusRequestTimeout,
-					Message:    "Timeout while trying to access dictionary",
-				}
-				return response
-			}
+		lock := locks.For(term)
+		if err := lock.Lock(ctx); err != nil {
+			response = lockFailure(ctx, term, time.Since(startTime))
+			return response
 		}
-		defer mux.Unlock()
+		defer lock.Unlock()
 
-		success := dict.Insert(term, request.Body)
+		_, success, err := dict.Insert(term, request.Body)
+		if err != nil {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "Error saving dictionary: " + err.Error(),
+			}
+			return response
+		}
 
 		if !success {
 			response = utils.HTTPResponse{
@@ -144,18 +147,21 @@ func ProcessDictCommand(request *utils.HTTPRequest, dict *Dictionary, mux *sync.
 			return response
 		}
 
-		for !mux.TryLock() {
-			if time.Since(startTime) > 30*time.Second {
-				response = utils.HTTPResponse{
-					StatusCode: http.StatusRequestTimeout,
-					Message:    "Timeout while trying to access dictionary",
-				}
-				return response
-			}
+		lock := locks.For(term)
+		if err := lock.Lock(ctx); err != nil {
+			response = lockFailure(ctx, term, time.Since(startTime))
+			return response
 		}
-		defer mux.Unlock()
+		defer lock.Unlock()
 
-		success := dict.Update(term, request.Body)
+		_, success, err := dict.Update(term, request.Body)
+		if err != nil {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "Error saving dictionary: " + err.Error(),
+			}
+			return response
+		}
 
 		if !success {
 			response = utils.HTTPResponse{
@@ -171,11 +177,73 @@ func ProcessDictCommand(request *utils.HTTPRequest, dict *Dictionary, mux *sync.
 		}
 		return response
 
+	case "DELETE":
+		lock := locks.For(term)
+		if err := lock.Lock(ctx); err != nil {
+			response = lockFailure(ctx, term, time.Since(startTime))
+			return response
+		}
+		defer lock.Unlock()
+
+		success, err := dict.Delete(term)
+		if err != nil {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "Error saving dictionary: " + err.Error(),
+			}
+			return response
+		}
+
+		if !success {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusNotFound,
+				Message:    fmt.Sprintf("Term '%s' does not exist", term),
+			}
+			return response
+		}
+
+		response = utils.HTTPResponse{
+			StatusCode: http.StatusOK,
+			Message:    fmt.Sprintf("Term '%s' deleted successfully", term),
+		}
+		return response
+
 	default:
 		response = utils.HTTPResponse{
 			StatusCode: http.StatusNotImplemented,
-			Message:    fmt.Sprintf("Unknown command '%s'. Try one of: LIST, LOOKUP, INSERT, UPDATE", command),
+			Message:    fmt.Sprintf("Unknown command '%s'. Try one of: LIST, LOOKUP, INSERT, UPDATE, DELETE", command),
 		}
 		return response
 	}
 }
+
+// withClientTimeout limita ctx ao prazo pedido pelo cliente, se houver. Um
+// prazo do servidor menor continua valendo.
+func withClientTimeout(ctx context.Context, value string) (context.Context, context.CancelFunc, error) {
+	if value == "" {
+		return ctx, func() {}, nil
+	}
+	timeout, err := utils.ParseTimeout(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, errClientTimeout)
+	return ctx, cancel, nil
+}
+
+// lockFailure é a resposta a uma requisição que desistiu de esperar pelo lock
+// do termo, com o tempo total desde o início da requisição.
+func lockFailure(ctx context.Context, term string, elapsed time.Duration) utils.HTTPResponse {
+	waited := elapsed.Round(time.Millisecond)
+	status := lockWaitStatus(ctx)
+	response := utils.HTTPResponse{StatusCode: status}
+	switch {
+	case status == http.StatusRequestTimeout:
+		response.Message = fmt.Sprintf("Client timeout expired after %s waiting for term '%s'", waited, term)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		response.Message = fmt.Sprintf("Dictionary busy: gave up after %s waiting for term '%s'", waited, term)
+	default:
+		response.Message = fmt.Sprintf("Request canceled after %s waiting for term '%s'", waited, term)
+	}
+	return response
+}
@@ -4,9 +4,17 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Cabeçalho com o prazo que o cliente dá ao servidor para atender a requisição
+const HeaderTimeout = "Timeout"
+
+// Cabeçalho com a versão que o cliente espera encontrar ao atualizar um termo
+const HeaderIfMatch = "If-Match"
+
 type HTTPRequest struct {
 	Method string // LIST, LOOKUP, INSERT, UPDATE, etc.
 	Path   string // O termo ou recurso
@@ -70,6 +78,41 @@ func ParseHTTPRequest(data []byte) (*HTTPRequest, error) {
 	return request, nil
 }
 
+// ParseTimeout lê o cabeçalho Timeout: uma duração como "500ms" ou "2s", ou
+// um número inteiro de milissegundos.
+func ParseTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if ms, atoiErr := strconv.Atoi(value); atoiErr == nil {
+		timeout, err = time.Duration(ms)*time.Millisecond, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", HeaderTimeout, value)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %q", HeaderTimeout, value)
+	}
+	return timeout, nil
+}
+
+// FormatETag escreve a versão de um termo como uma ETag forte, "3".
+func FormatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// ParseVersion lê a versão esperada no cabeçalho If-Match: uma ETag como a de
+// FormatETag ou só o número.
+func ParseVersion(value string) (uint64, error) {
+	number := value
+	if len(number) >= 2 && number[0] == '"' && number[len(number)-1] == '"' {
+		number = number[1 : len(number)-1]
+	}
+	version, err := strconv.ParseUint(number, 10, 64)
+	if err != nil || version == 0 {
+		return 0, fmt.Errorf("invalid %s %q", HeaderIfMatch, value)
+	}
+	return version, nil
+}
+
 func GetEmoji(statusCode int) string {
 	if statusCode >= 200 && statusCode < 300 {
 		return "\u2705"
@@ -1,8 +1,11 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"strings"
 	"time"
 
 	"tcp/utils"
@@ -15,6 +18,12 @@ func StartClient(config *Config) error {
 	logger := utils.GetLogger()
 	connOK := false
 	tryCount := 0
+	// A transação é da conexão: se ela cair, o servidor a desfaz
+	inTransaction := false
+	// Cursor e tamanho da página da última listagem incompleta
+	var nextPage, pageSize string
+	// Termo sugerido num 404 que o usuário escolheu consultar
+	var lookupNext string
 
 	conn, err := net.Dial("tcp", config.AddressString())
 	if err != nil {
@@ -22,7 +31,8 @@ func StartClient(config *Config) error {
 		return err
 	}
 	connOK = true
-	defer conn.Close()
+	pipeline := NewPipeline(conn, config.MaxInFlight)
+	defer func() { pipeline.Close() }()
 	logger.Info("Connected to server", zap.String("address", config.AddressString()))
 
 	for {
@@ -37,27 +47,56 @@ func StartClient(config *Config) error {
 			}
 			connOK = true
 			tryCount = 0
+			pipeline = NewPipeline(conn, config.MaxInFlight)
+			if inTransaction {
+				fmt.Println("A conexão caiu durante a transação; o servidor desfez as alterações pendentes")
+				inTransaction = false
+			}
 		}
 
-		prompt := promptui.Select{
-			Label: "Selecione um comando",
-			Items: []string{"LIST", "LOOKUP", "INSERT", "UPDATE"},
-		}
+		// Com uma sugestão escolhida, o LOOKUP dela dispensa o menu
+		result := "LOOKUP"
+		if lookupNext == "" {
+			label := "Selecione um comando"
+			if inTransaction {
+				label += " (transação aberta)"
+			}
+			prompt := promptui.Select{
+				Label: label,
+				Items: []string{"LIST", "LOOKUP", "SEARCH", "INSERT", "UPDATE", "CAS", "DELETE", "BEGIN", "COMMIT", "ROLLBACK"},
+			}
 
-		_, result, err := prompt.Run()
-		if err != nil {
-			fmt.Printf("Prompt failed %v\n", err)
-			return err
+			if _, result, err = prompt.Run(); err != nil {
+				fmt.Printf("Prompt failed %v\n", err)
+				return err
+			}
 		}
 
-		var message string
+		var message, sort, cursor string
 
 		switch result {
 		case "LIST":
-			message = "LIST"
+			// O cursor da página anterior já guarda o prefixo e a ordem
+			if nextPage != "" && promptString("Continuar a listagem anterior? (s/N):") == "s" {
+				message, cursor = listCommand("", pageSize), nextPage
+				break
+			}
+			prefix := promptString("Prefixo (vazio para todos):")
+			pageSize = promptString("Termos por página (vazio para todos):")
+			if promptString("Ordem alfabética? (s/N):") == "s" {
+				sort = "alpha"
+			}
+			message = listCommand(prefix, pageSize)
 		case "LOOKUP":
-			term := promptString("Digite o termo para busca:")
+			term := lookupNext
+			if term == "" {
+				term = promptString("Digite o termo para busca:")
+			}
+			lookupNext = ""
 			message = fmt.Sprintf("LOOKUP %s", term)
+		case "SEARCH":
+			query := promptString("Palavras a buscar (termine com * para buscar pelo começo):")
+			message = fmt.Sprintf("SEARCH %s", query)
 		case "INSERT":
 			term := promptString("Termo:")
 			def := promptString("Definição:")
@@ -66,6 +105,16 @@ func StartClient(config *Config) error {
 			term := promptString("Termo:")
 			def := promptString("Nova definição:")
 			message = fmt.Sprintf("UPDATE %s %s", term, def)
+		case "CAS":
+			term := promptString("Termo:")
+			version := promptString("Versão esperada (do LOOKUP):")
+			def := promptString("Nova definição:")
+			message = fmt.Sprintf("CAS %s %s %s", term, version, def)
+		case "DELETE":
+			term := promptString("Termo a remover:")
+			message = fmt.Sprintf("DELETE %s", term)
+		case "BEGIN", "COMMIT", "ROLLBACK":
+			message = result
 		}
 
 		request, err := ParseCommandToHTTPRequest(message)
@@ -75,24 +124,27 @@ func StartClient(config *Config) error {
 			continue
 		}
 
-		_, err = conn.Write(request.Bytes())
-		if err != nil {
-			logger.Warn("Error sending data", zap.Error(err))
-			return err
+		// O servidor desiste depois de RequestTimeout e responde 408; a espera
+		// aqui é um pouco maior para que essa resposta ainda chegue
+		if request.Header == nil {
+			request.Header = utils.Header{}
 		}
-
-		err = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-		if err != nil {
-			logger.Warn("Error setting up read deadline", zap.Error(err))
-			return err
+		request.Header.Set(utils.HeaderTimeout, config.RequestTimeout.String())
+		if sort != "" {
+			request.Header.Set(utils.HeaderSort, sort)
 		}
-
-		buffer := make([]byte, 1024)
-		n, err := conn.Read(buffer)
+		if cursor != "" {
+			request.Header.Set(utils.HeaderCursor, cursor)
+		}
+		wait := config.RequestTimeout + responseGrace
+		call := pipeline.Go(*request)
+		err = call.Wait(wait)
 		if err != nil {
 			logger.Warn("Error reading response", zap.Error(err))
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				logger.Info("Read timeout: no response within 30 seconds")
+			if errors.Is(err, ErrResponseTimeout) {
+				logger.Info("Read timeout: no response in time", zap.Duration("timeout", wait))
+				// A resposta pode chegar depois e desalinhar a conexão; abre outra
+				pipeline.Close()
 				connOK = false
 				continue
 			} else {
@@ -100,8 +152,15 @@ func StartClient(config *Config) error {
 			}
 		}
 
-		responseStr := string(buffer[:n])
-		statusCode, statusText, body := ParseHTTPResponse(responseStr)
+		statusCode, body := call.Response.StatusCode, call.Response.Body
+		statusText := http.StatusText(statusCode)
+		switch {
+		case result == "BEGIN" && statusCode == http.StatusOK:
+			inTransaction = true
+		case result == "ROLLBACK", result == "COMMIT" && statusCode != http.StatusRequestTimeout && statusCode != http.StatusServiceUnavailable:
+			// Um COMMIT que desistiu de esperar pelos termos mantém a transação aberta
+			inTransaction = false
+		}
 
 		if statusCode >= 200 && statusCode < 300 {
 			fmt.Printf("%s SUCCESS (%d %s): %s\n", utils.GetEmoji(statusCode), statusCode, statusText, body)
@@ -112,6 +171,16 @@ func StartClient(config *Config) error {
 		} else {
 			fmt.Printf("%s RESPONSE (%d %s): %s\n", utils.GetEmoji(statusCode), statusCode, statusText, body)
 		}
+		printVersion(call.Response.Header)
+		if statusCode == http.StatusNotFound {
+			lookupNext = chooseSuggestion(call.Response.Header)
+		}
+		if result == "LIST" && statusCode == http.StatusOK {
+			nextPage = call.Response.Header.Get(utils.HeaderNextCursor)
+		}
+		if result == "LIST" || result == "SEARCH" {
+			printPage(call.Response.Header)
+		}
 	}
 }
 
@@ -126,3 +195,23 @@ func promptString(label string) string {
 	}
 	return result
 }
+
+// chooseSuggestion mostra os termos sugeridos num 404, no cabeçalho
+// Suggestions, e pergunta se o usuário quer consultar um deles. Retorna o termo
+// escolhido, ou "" se não há sugestões ou o usuário não quer nenhuma.
+func chooseSuggestion(header utils.Header) string {
+	suggestions := utils.ParseSuggestions(header.Get(utils.HeaderSuggestions))
+	if len(suggestions) == 0 {
+		return ""
+	}
+	fmt.Printf("   Você quis dizer: %s?\n", strings.Join(suggestions, ", "))
+	prompt := promptui.Select{
+		Label: "Consultar um dos termos sugeridos?",
+		Items: append(suggestions, "Não"),
+	}
+	i, _, err := prompt.Run()
+	if err != nil || i == len(suggestions) {
+		return ""
+	}
+	return suggestions[i]
+}
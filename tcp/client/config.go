@@ -1,20 +1,32 @@
 package client
 
-import "strconv"
+import (
+	"strconv"
+	"time"
+
+	"tcp/utils"
+)
 
 type Config struct {
-	Address string
-	Port    int
+	Address        string
+	Port           int
+	MaxInFlight    int           // Requisições sem resposta na Pipeline
+	RequestTimeout time.Duration // Prazo enviado ao servidor no cabeçalho Timeout
 }
 
+// Tempo extra de espera pela resposta depois do prazo da requisição
+const responseGrace = 5 * time.Second
+
 func NewConfig() *Config {
 	return DefaultConfig()
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Address: "localhost",
-		Port:    8000,
+		Address:        "localhost",
+		Port:           8000,
+		MaxInFlight:    utils.DefaultMaxInFlight,
+		RequestTimeout: 30 * time.Second,
 	}
 }
 
@@ -26,6 +38,14 @@ func (c *Config) SetPort(port int) {
 	c.Port = port
 }
 
+func (c *Config) SetMaxInFlight(n int) {
+	c.MaxInFlight = n
+}
+
+func (c *Config) SetRequestTimeout(timeout time.Duration) {
+	c.RequestTimeout = timeout
+}
+
 func (c *Config) AddressString() string {
 	return c.Address + ":" + strconv.Itoa(c.Port)
 }
@@ -0,0 +1,216 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"tcp/utils"
+)
+
+// Cada requisição leva um Request-Id, que o servidor devolve na resposta, e a
+// Pipeline entrega cada resposta à requisição de mesmo Request-Id, mesmo que
+// as respostas cheguem fora de ordem. Respostas sem Request-Id (servidores
+// antigos, que respondem na ordem de envio) vão para a requisição mais antiga
+// ainda sem resposta.
+
+var ErrPipelineClosed = errors.New("pipeline closed")
+var ErrResponseTimeout = errors.New("no response within timeout")
+
+// Call é uma requisição enviada pela Pipeline. Done é fechado quando a
+// resposta chega ou a conexão falha; Err indica o erro nesse caso.
+type Call struct {
+	Request  utils.HTTPRequest
+	Response utils.HTTPResponse
+	Err      error
+	Done     chan struct{}
+}
+
+// Wait espera a resposta por até timeout (sem limite se timeout <= 0).
+func (c *Call) Wait(timeout time.Duration) error {
+	if timeout <= 0 {
+		<-c.Done
+		return c.Err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.Done:
+		return c.Err
+	case <-timer.C:
+		return ErrResponseTimeout
+	}
+}
+
+type Pipeline struct {
+	conn       net.Conn
+	writer     *utils.FrameWriter
+	mux        sync.Mutex    // Mantém a ordem da fila igual à ordem de envio
+	slots      chan struct{} // Uma vaga por requisição sem resposta, até maxInFlight
+	pendingMux sync.Mutex
+	pending    []*Call // Requisições sem resposta, na ordem de envio
+	closed     chan struct{}
+	once       sync.Once
+	err        error
+	nextID     uint64
+}
+
+// NewPipeline passa a ler as respostas de conn. Com maxInFlight requisições
+// sem resposta, Go espera até que uma delas seja respondida.
+func NewPipeline(conn net.Conn, maxInFlight int) *Pipeline {
+	if maxInFlight <= 0 {
+		maxInFlight = utils.DefaultMaxInFlight
+	}
+	p := &Pipeline{
+		conn:   conn,
+		writer: utils.NewFrameWriter(conn),
+		slots:  make(chan struct{}, maxInFlight),
+		closed: make(chan struct{}),
+	}
+	go p.readResponses()
+	return p
+}
+
+// Go envia a requisição sem esperar a resposta.
+func (p *Pipeline) Go(request utils.HTTPRequest) *Call {
+	header := utils.Header{}
+	for key, value := range request.Header {
+		header[key] = value
+	}
+	request.Header = header
+	call := &Call{
+		Request: request,
+		Done:    make(chan struct{}),
+	}
+	p.mux.Lock()
+	select {
+	case <-p.closed:
+		p.mux.Unlock()
+		call.Err = p.err
+		close(call.Done)
+		return call
+	default:
+	}
+	p.nextID++
+	request.Header.Set(utils.HeaderRequestID, strconv.FormatUint(p.nextID, 10))
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.closed:
+		p.mux.Unlock()
+		call.Err = p.err
+		close(call.Done)
+		return call
+	}
+	p.pendingMux.Lock()
+	p.pending = append(p.pending, call)
+	p.pendingMux.Unlock()
+	err := request.WriteFrame(p.writer)
+	p.mux.Unlock()
+	if err != nil {
+		// A chamada já está na fila e recebe o erro junto com as demais
+		p.fail(err)
+	}
+	return call
+}
+
+// Do envia todas as requisições em sequência, sem esperar cada resposta, e
+// retorna as chamadas na mesma ordem depois que todas forem respondidas ou
+// timeout expirar.
+func (p *Pipeline) Do(timeout time.Duration, requests ...utils.HTTPRequest) []*Call {
+	calls := make([]*Call, len(requests))
+	for i, request := range requests {
+		calls[i] = p.Go(request)
+	}
+	deadline := time.Now().Add(timeout)
+	for _, call := range calls {
+		remaining := time.Duration(0)
+		if timeout > 0 {
+			remaining = max(time.Until(deadline), time.Nanosecond)
+		}
+		if err := call.Wait(remaining); errors.Is(err, ErrResponseTimeout) {
+			// A requisição continuaria ocupando uma vaga da conexão
+			p.fail(err)
+			<-call.Done
+		}
+	}
+	return calls
+}
+
+func (p *Pipeline) Close() error {
+	p.fail(ErrPipelineClosed)
+	return nil
+}
+
+func (p *Pipeline) Err() error {
+	select {
+	case <-p.closed:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+func (p *Pipeline) readResponses() {
+	reader := utils.NewFrameReader(p.conn, 0)
+	for {
+		data, err := reader.ReadFrame()
+		if err != nil {
+			p.fail(err)
+			return
+		}
+		response, parseErr := utils.ParseHTTPResponse(data)
+		id := ""
+		if parseErr == nil {
+			id = response.Header.Get(utils.HeaderRequestID)
+		}
+		call := p.take(id)
+		if call == nil {
+			if id != "" {
+				p.fail(fmt.Errorf("response for request %s, which is not pending", id))
+			} else {
+				p.fail(errors.New("response without a pending request"))
+			}
+			return
+		}
+		if parseErr != nil {
+			call.Err = fmt.Errorf("invalid response: %w", parseErr)
+		} else {
+			call.Response = *response
+		}
+		close(call.Done)
+	}
+}
+
+// take retira da fila a requisição de Request-Id id, ou a mais antiga se id
+// for vazio, e libera a sua vaga.
+func (p *Pipeline) take(id string) *Call {
+	p.pendingMux.Lock()
+	defer p.pendingMux.Unlock()
+	for i, call := range p.pending {
+		if id == "" || call.Request.Header.Get(utils.HeaderRequestID) == id {
+			p.pending = append(p.pending[:i], p.pending[i+1:]...)
+			<-p.slots
+			return call
+		}
+	}
+	return nil
+}
+
+// fail encerra a conexão e entrega err a todas as chamadas pendentes.
+func (p *Pipeline) fail(err error) {
+	p.once.Do(func() {
+		p.err = err
+		close(p.closed)
+		p.conn.Close()
+	})
+	// Go não enfileira mais nada depois que closed é fechado
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for call := p.take(""); call != nil; call = p.take("") {
+		call.Err = p.err
+		close(call.Done)
+	}
+}
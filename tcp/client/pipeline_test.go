@@ -0,0 +1,190 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"tcp/utils"
+)
+
+// fakeServer lê as requisições do lado servidor de um net.Pipe.
+type fakeServer struct {
+	t      *testing.T
+	reader *utils.FrameReader
+	writer *utils.FrameWriter
+}
+
+func newFakeServer(t *testing.T, conn net.Conn) *fakeServer {
+	return &fakeServer{t: t, reader: utils.NewFrameReader(conn, 0), writer: utils.NewFrameWriter(conn)}
+}
+
+func (s *fakeServer) read() *utils.HTTPRequest {
+	data, err := s.reader.ReadFrame()
+	if err != nil {
+		// A Pipeline fechou a conexão
+		return nil
+	}
+	request, err := utils.ParseHTTPRequest(data)
+	if err != nil {
+		s.t.Errorf("server parse: %v", err)
+		return nil
+	}
+	return request
+}
+
+// reply responde com o termo da requisição no corpo e o Request-Id indicado.
+func (s *fakeServer) reply(request *utils.HTTPRequest, id string) {
+	response := utils.HTTPResponse{StatusCode: 200, Body: request.Path}
+	if id != "" {
+		response.SetHeader(utils.HeaderRequestID, id)
+	}
+	s.writer.WriteFrame(response.Bytes())
+}
+
+func lookups(terms ...string) []utils.HTTPRequest {
+	requests := make([]utils.HTTPRequest, len(terms))
+	for i, term := range terms {
+		requests[i] = utils.HTTPRequest{Method: "LOOKUP", Path: term}
+	}
+	return requests
+}
+
+func TestPipelineMatchesRequestID(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []int // Ordem em que o servidor responde às requisições
+		noID  bool  // Respostas sem Request-Id, como de um servidor antigo
+	}{
+		{"in order", []int{0, 1, 2, 3}, false},
+		{"reversed", []int{3, 2, 1, 0}, false},
+		{"interleaved", []int{1, 3, 0, 2}, false},
+		{"without Request-Id", []int{0, 1, 2, 3}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			server := newFakeServer(t, serverConn)
+			pipeline := NewPipeline(clientConn, 8)
+			defer pipeline.Close()
+
+			terms := []string{"alfa", "beta", "gama", "delta"}
+			go func() {
+				requests := make([]*utils.HTTPRequest, len(terms))
+				for i := range requests {
+					if requests[i] = server.read(); requests[i] == nil {
+						return
+					}
+				}
+				for _, i := range tt.order {
+					id := requests[i].Header.Get(utils.HeaderRequestID)
+					if tt.noID {
+						id = ""
+					}
+					server.reply(requests[i], id)
+				}
+			}()
+
+			calls := pipeline.Do(5*time.Second, lookups(terms...)...)
+			for i, call := range calls {
+				if call.Err != nil {
+					t.Fatalf("call %d: %v", i, call.Err)
+				}
+				if call.Response.Body != terms[i] {
+					t.Errorf("call for %q got the response for %q", terms[i], call.Response.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestPipelineUnknownRequestID(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	server := newFakeServer(t, serverConn)
+	pipeline := NewPipeline(clientConn, 8)
+	defer pipeline.Close()
+
+	go func() {
+		if request := server.read(); request != nil {
+			server.reply(request, "999")
+		}
+	}()
+	call := pipeline.Go(lookups("alfa")[0])
+	if err := call.Wait(5 * time.Second); err == nil {
+		t.Fatalf("call succeeded with a response for another request")
+	}
+	if pipeline.Err() == nil {
+		t.Errorf("pipeline still open after a response for an unknown request")
+	}
+}
+
+// Com maxInFlight requisições sem resposta, Go espera uma resposta antes de
+// enviar a próxima.
+func TestPipelineBackpressure(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	server := newFakeServer(t, serverConn)
+	pipeline := NewPipeline(clientConn, 2)
+	defer pipeline.Close()
+
+	received := make(chan *utils.HTTPRequest, 3)
+	go func() {
+		for range 3 {
+			request := server.read()
+			if request == nil {
+				return
+			}
+			received <- request
+		}
+	}()
+
+	first := pipeline.Go(lookups("alfa")[0])
+	pipeline.Go(lookups("beta")[0])
+	third := make(chan *Call)
+	go func() { third <- pipeline.Go(lookups("gama")[0]) }()
+
+	request := <-received
+	<-received
+	select {
+	case <-third:
+		t.Fatalf("third request sent with 2 requests in flight and a limit of 2")
+	case <-received:
+		t.Fatalf("server received a third request with 2 in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	server.reply(request, request.Header.Get(utils.HeaderRequestID))
+	if err := first.Wait(5 * time.Second); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	select {
+	case <-third:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("third request still blocked after a response freed a slot")
+	}
+	if request := <-received; request.Path != "gama" {
+		t.Errorf("third request is %q, want \"gama\"", request.Path)
+	}
+}
+
+// Fechar a Pipeline entrega o erro a todas as chamadas pendentes.
+func TestPipelineCloseFailsPending(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	server := newFakeServer(t, serverConn)
+	pipeline := NewPipeline(clientConn, 4)
+
+	go func() {
+		for server.read() != nil {
+		}
+	}()
+	calls := []*Call{pipeline.Go(lookups("alfa")[0]), pipeline.Go(lookups("beta")[0])}
+	pipeline.Close()
+	for i, call := range calls {
+		if err := call.Wait(5 * time.Second); !errors.Is(err, ErrPipelineClosed) {
+			t.Errorf("call %d error = %v, want ErrPipelineClosed", i, err)
+		}
+	}
+	if call := pipeline.Go(lookups("gama")[0]); !errors.Is(call.Err, ErrPipelineClosed) {
+		t.Errorf("Go after Close error = %v, want ErrPipelineClosed", call.Err)
+	}
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tcp/utils"
+)
+
+// ImportFile envia o arquivo ao servidor com IMPORT e mostra o relatório. Sem
+// format, o formato vem da extensão do arquivo; sem conflict, o servidor
+// mantém os termos que já existem. O arquivo é copiado para a conexão à
+// medida que é lido, sem ser carregado na memória.
+func ImportFile(config *Config, file, format, conflict string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	request := utils.HTTPRequest{
+		Method:     "IMPORT",
+		Path:       formatOf(file, format),
+		Header:     utils.Header{},
+		BodyReader: f,
+		BodySize:   int(info.Size()),
+	}
+	if conflict != "" {
+		request.Header.Set(utils.HeaderConflict, conflict)
+	}
+	response, err := exchangeOnce(config, request)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s (%d %s): %s\n", utils.GetEmoji(response.StatusCode), response.StatusCode, http.StatusText(response.StatusCode), response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("import of %s failed with status %d", file, response.StatusCode)
+	}
+	return nil
+}
+
+// ExportFile grava no arquivo o dicionário exportado pelo servidor com
+// EXPORT, ou o escreve na saída padrão se file é vazio. Sem format, o formato
+// vem da extensão do arquivo ou é JSON.
+func ExportFile(config *Config, file, format string) error {
+	response, err := exchangeOnce(config, utils.HTTPRequest{
+		Method: "EXPORT",
+		Path:   formatOf(file, format),
+		Header: utils.Header{},
+	})
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("export failed with status %d: %s", response.StatusCode, response.Body)
+	}
+	if file == "" {
+		_, err = os.Stdout.WriteString(response.Body)
+		return err
+	}
+	if err := os.WriteFile(file, []byte(response.Body), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("%s termos exportados para %s\n", response.Header.Get(utils.HeaderTotal), file)
+	return nil
+}
+
+// formatOf retorna o formato pedido ou, se vazio, a extensão do arquivo.
+func formatOf(file, format string) string {
+	if format != "" {
+		return format
+	}
+	return strings.TrimPrefix(filepath.Ext(file), ".")
+}
+
+// exchangeOnce abre uma conexão só para a requisição e espera a resposta.
+func exchangeOnce(config *Config, request utils.HTTPRequest) (*utils.HTTPResponse, error) {
+	conn, err := net.Dial("tcp", config.AddressString())
+	if err != nil {
+		return nil, err
+	}
+	pipeline := NewPipeline(conn, 1)
+	defer pipeline.Close()
+
+	request.Header.Set(utils.HeaderTimeout, config.RequestTimeout.String())
+	call := pipeline.Go(request)
+	if err := call.Wait(config.RequestTimeout + responseGrace); err != nil {
+		return nil, err
+	}
+	return &call.Response, nil
+}
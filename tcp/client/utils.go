@@ -1,9 +1,13 @@
 package client
 
 import (
+	"cmp"
 	"fmt"
+	"net/http"
 	"strings"
 	"tcp/utils"
+
+	"go.uber.org/zap"
 )
 
 func ToLowercase(data string) string {
@@ -36,13 +40,29 @@ func ParseCommandToHTTPRequest(command string) (*utils.HTTPRequest, error) {
 	term := ""
 	body := ""
 
-	if method == "LIST" {
-		term = ""
-		body = ""
+	if method == "SEARCH" {
+		// SEARCH <busca>: a busca inteira vai no caminho
+		term = strings.Join(parts[1:], " ")
+	} else if method == "CAS" {
+		// CAS <termo> <versão> <definição>: a versão vai no cabeçalho If-Match
+		if len(parts) < 4 {
+			return nil, fmt.Errorf("usage: CAS <term> <version> <definition>")
+		}
+		if _, err := utils.ParseVersion(parts[2]); err != nil {
+			return nil, err
+		}
+		return &utils.HTTPRequest{
+			Method: method,
+			Path:   parts[1],
+			Header: utils.Header{utils.HeaderIfMatch: parts[2]},
+			Body:   strings.Join(parts[3:], " "),
+		}, nil
 	} else {
 		if len(parts) > 1 {
 			term = parts[1]
 		}
+		// LIST [prefixo] [offset] [limite] segue a mesma forma: o prefixo no
+		// caminho, o offset e o limite no corpo
 		if len(parts) > 2 {
 			body = strings.Join(parts[2:], " ")
 		}
@@ -58,27 +78,38 @@ func ParseCommandToHTTPRequest(command string) (*utils.HTTPRequest, error) {
 }
 
 func ParseHTTPResponse(response string) (statusCode int, statusText string, message string) {
-	// utils.Logger.Info("Parsing HTTP response", zap.String("response", response))
-	response = strings.TrimSpace(response)
-
-	parts := strings.SplitN(response, " ", 2)
-	if len(parts) < 2 {
-		return 0, "UNKNOWN", response
-	}
-
-	_, err := fmt.Sscanf(parts[0], "%d", &statusCode)
+	parsed, err := utils.ParseHTTPResponse([]byte(response))
 	if err != nil {
+		utils.GetLogger().Warn("Invalid response", zap.Error(err))
 		return 0, "UNKNOWN", response
 	}
+	return parsed.StatusCode, http.StatusText(parsed.StatusCode), parsed.Body
+}
 
-	rest := parts[1]
-	colonIdx := strings.Index(rest, ": ")
-	if colonIdx == -1 {
-		return statusCode, rest, ""
+// printVersion mostra a versão do termo devolvida no cabeçalho Version, que
+// um CAS posterior usa para não sobrescrever alterações de outros clientes.
+func printVersion(header utils.Header) {
+	if version := header.Get(utils.HeaderVersion); version != "" {
+		fmt.Printf("   Versão: %s\n", version)
 	}
+}
 
-	statusText = rest[:colonIdx]
-	message = rest[colonIdx+2:]
+// listCommand monta LIST [prefixo] [offset] [limite], sempre a partir do
+// início; sem prefixo e com limite, o prefixo é "*", que lista todos os termos.
+func listCommand(prefix, limit string) string {
+	if limit == "" {
+		return strings.TrimSpace("LIST " + prefix)
+	}
+	return fmt.Sprintf("LIST %s 0 %s", cmp.Or(prefix, "*"), limit)
+}
 
-	return statusCode, statusText, message
+// printPage mostra o total da listagem ou da busca, devolvido no cabeçalho
+// Total, e avisa se a listagem tem mais páginas.
+func printPage(header utils.Header) {
+	if total := header.Get(utils.HeaderTotal); total != "" {
+		fmt.Printf("   Total: %s\n", total)
+	}
+	if header.Get(utils.HeaderNextCursor) != "" {
+		fmt.Println("   Há mais termos: escolha LIST de novo para ver a próxima página")
+	}
 }
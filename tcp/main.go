@@ -34,16 +34,26 @@ func main() {
 	}
 
 	// Define flags
-	mode := flag.String("mode", "", "Mode to run: 'server' or 'client'")
+	mode := flag.String("mode", "", "Mode to run: 'server', 'client', 'import' or 'export'")
 	address := flag.String("address", addrDefault, "Address to bind/connect to")
 	port := flag.Int("port", portDefault, "Port to bind/connect to")
+	store := flag.String("store", "", "Dictionary store (server): 'memory', 'file' (write-ahead log and snapshots) or 'kv' (key-value file); defaults to 'file' with -data-dir and 'memory' without")
+	dataDir := flag.String("data-dir", "", "Directory for the dictionary files (server); empty keeps it in memory only")
+	snapshotEvery := flag.Int("snapshot-every", server.DefaultSnapshotEvery, "Logged operations between dictionary snapshots (server)")
+	requestTimeout := flag.Duration("request-timeout", server.DefaultRequestTimeout, "Time limit for each request, including waiting for term locks; the client sends it in the Timeout header")
+	maxInFlight := flag.Int("max-inflight", utils.DefaultMaxInFlight, "Pipelined requests pending per connection")
+	serveHTTP := flag.Bool("http", true, "Also serve HTTP/1.1 requests on the server port")
+	maxMessage := flag.Int("max-message", utils.DefaultMaxFrameSize, "Largest request accepted by the server, in bytes")
+	file := flag.String("file", "", "File to import from or export to (import/export); export writes to stdout without it")
+	format := flag.String("format", "", "File format (import/export): 'json', 'csv' or 'jsonl'; defaults to the file extension")
+	conflict := flag.String("conflict", "", "What import does with terms that already exist: 'skip' (default), 'overwrite' or 'fail'")
 
 	flag.Parse()
 
 	// Validate mode
 	if *mode == "" {
 		fmt.Println("Error: mode flag is required")
-		fmt.Println("Usage: go run main.go -mode=<server|client> [-address=<address>] [-port=<port>]")
+		fmt.Println("Usage: go run main.go -mode=<server|client|import|export> [-address=<address>] [-port=<port>] [-store=<memory|file|kv>] [-data-dir=<dir>] [-max-message=<bytes>] [-max-inflight=<n>] [-http=<true|false>] [-request-timeout=<duration>] [-file=<file>] [-format=<json|csv|jsonl>] [-conflict=<skip|overwrite|fail>]")
 		os.Exit(1)
 	}
 
@@ -52,6 +62,13 @@ func main() {
 		config := server.NewConfig()
 		config.SetAddress(*address)
 		config.SetPort(*port)
+		config.SetStore(*store)
+		config.SetDataDir(*dataDir)
+		config.SetSnapshotEvery(*snapshotEvery)
+		config.SetMaxMessageSize(*maxMessage)
+		config.SetMaxInFlight(*maxInFlight)
+		config.SetHTTP(*serveHTTP)
+		config.SetRequestTimeout(*requestTimeout)
 
 		logger.Info("Starting TCP server", zap.String("address", config.AddressString()))
 		if err := server.StartServer(config); err != nil {
@@ -62,15 +79,37 @@ func main() {
 		config := client.NewConfig()
 		config.SetAddress(*address)
 		config.SetPort(*port)
+		config.SetMaxInFlight(*maxInFlight)
+		config.SetRequestTimeout(*requestTimeout)
 
 		logger.Info("Starting client", zap.String("address", config.AddressString()))
 		if err := client.StartClient(config); err != nil {
 			logger.Fatal("Failed to start client", zap.Error(err))
 		}
 
+	case "import", "export":
+		config := client.NewConfig()
+		config.SetAddress(*address)
+		config.SetPort(*port)
+		config.SetRequestTimeout(*requestTimeout)
+
+		var err error
+		if *mode == "import" {
+			if *file == "" {
+				fmt.Println("Error: import requires -file")
+				os.Exit(1)
+			}
+			err = client.ImportFile(config, *file, *format, *conflict)
+		} else {
+			err = client.ExportFile(config, *file, *format)
+		}
+		if err != nil {
+			logger.Fatal("Failed to "+*mode+" dictionary", zap.Error(err))
+		}
+
 	default:
 		fmt.Printf("Error: invalid mode '%s'\n", *mode)
-		fmt.Println("Mode must be either 'server' or 'client'")
+		fmt.Println("Mode must be one of 'server', 'client', 'import' or 'export'")
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,94 @@
+package server
+
+import "sync"
+
+// Commit em grupo (group commit) para os armazenamentos em disco. Cada
+// alteração é escrita no fim do arquivo com o lock do armazenamento, mas o
+// fsync acontece sem ele: enquanto um fsync está em andamento, outras
+// alterações são escritas e esperam, e o próximo fsync confirma todas de uma
+// vez. Assim escritores de termos diferentes só se serializam na escrita, que
+// é rápida, e não na espera pelo disco. As alterações só são aplicadas na
+// memória depois do fsync que as confirma, na ordem em que foram escritas.
+
+// groupLog é o arquivo de um armazenamento, visto pelo groupCommit. Os
+// registros pendentes são os escritos e ainda não confirmados, em ordem.
+type groupLog interface {
+	// sync confirma no disco tudo o que já foi escrito. É chamado sem o lock
+	sync() error
+	// commit aplica os n primeiros registros pendentes, já confirmados. Um
+	// erro indica que os demais registros pendentes foram descartados
+	commit(n int) error
+	// abort descarta todos os registros pendentes depois de uma falha no
+	// fsync, voltando o arquivo ao fim do último registro confirmado
+	abort()
+}
+
+type groupCommit struct {
+	mu      *sync.Mutex // Lock do armazenamento, que protege o arquivo e os registros pendentes
+	cond    *sync.Cond
+	syncing bool
+	waiting []*commitWait // Na mesma ordem dos registros pendentes do groupLog
+}
+
+type commitWait struct {
+	done bool
+	err  error
+}
+
+func newGroupCommit(mu *sync.Mutex) *groupCommit {
+	return &groupCommit{mu: mu, cond: sync.NewCond(mu)}
+}
+
+// wait é chamado com o lock logo depois de o registro ser escrito, e retorna
+// sem o lock quando um fsync o confirmou e ele foi aplicado, ou com o erro do
+// fsync. Se nenhum fsync estiver em andamento, quem chega faz o próximo.
+func (g *groupCommit) wait(log groupLog) error {
+	w := &commitWait{}
+	g.waiting = append(g.waiting, w)
+	for !w.done {
+		if g.syncing {
+			g.cond.Wait()
+			continue
+		}
+		g.lead(log)
+	}
+	g.mu.Unlock()
+	return w.err
+}
+
+// lead faz o fsync dos registros pendentes, com o lock liberado durante o
+// fsync. Uma falha descarta também os registros escritos durante o fsync,
+// já que o arquivo volta ao fim do último registro confirmado.
+func (g *groupCommit) lead(log groupLog) {
+	batch := g.waiting
+	g.waiting = nil
+	g.syncing = true
+	g.mu.Unlock()
+	err := log.sync()
+	g.mu.Lock()
+	rest := err
+	if err == nil {
+		rest = log.commit(len(batch))
+	} else {
+		log.abort()
+	}
+	for _, w := range batch {
+		w.done, w.err = true, err
+	}
+	if rest != nil {
+		for _, w := range g.waiting {
+			w.done, w.err = true, rest
+		}
+		g.waiting = nil
+	}
+	g.syncing = false
+	g.cond.Broadcast()
+}
+
+// idle espera, com o lock, até que não haja fsync em andamento nem registros
+// pendentes, por exemplo antes de fechar o arquivo.
+func (g *groupCommit) idle() {
+	for g.syncing || len(g.waiting) > 0 {
+		g.cond.Wait()
+	}
+}
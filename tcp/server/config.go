@@ -1,10 +1,22 @@
 package server
 
-import "strconv"
+import (
+	"strconv"
+	"time"
+
+	"tcp/utils"
+)
 
 type Config struct {
-	Address string
-	Port    int
+	Address        string
+	Port           int
+	MaxMessageSize int           // Maior requisição aceita, em bytes
+	MaxInFlight    int           // Requisições processadas ao mesmo tempo por conexão
+	HTTP           bool          // Atende também HTTP/1.1 na mesma porta
+	RequestTimeout time.Duration // Prazo de cada requisição, incluindo a espera pelos locks
+	Store          string        // Tipo de armazenamento: memory, file ou kv (vazio escolhe pelo DataDir)
+	DataDir        string        // Diretório dos arquivos do dicionário (vazio mantém o dicionário só na memória)
+	SnapshotEvery  int           // Operações no log entre dois snapshots
 }
 
 func NewConfig() *Config {
@@ -13,8 +25,13 @@ func NewConfig() *Config {
 
 func DefaultConfig() *Config {
 	return &Config{
-		Address: "localhost",
-		Port:    8000,
+		Address:        "localhost",
+		Port:           8000,
+		MaxMessageSize: utils.DefaultMaxFrameSize,
+		MaxInFlight:    utils.DefaultMaxInFlight,
+		HTTP:           true,
+		RequestTimeout: DefaultRequestTimeout,
+		SnapshotEvery:  DefaultSnapshotEvery,
 	}
 }
 
@@ -26,6 +43,34 @@ func (c *Config) SetPort(port int) {
 	c.Port = port
 }
 
+func (c *Config) SetMaxMessageSize(size int) {
+	c.MaxMessageSize = size
+}
+
+func (c *Config) SetMaxInFlight(n int) {
+	c.MaxInFlight = n
+}
+
+func (c *Config) SetHTTP(enabled bool) {
+	c.HTTP = enabled
+}
+
+func (c *Config) SetRequestTimeout(timeout time.Duration) {
+	c.RequestTimeout = timeout
+}
+
+func (c *Config) SetStore(store string) {
+	c.Store = store
+}
+
+func (c *Config) SetDataDir(dir string) {
+	c.DataDir = dir
+}
+
+func (c *Config) SetSnapshotEvery(n int) {
+	c.SnapshotEvery = n
+}
+
 func (c *Config) AddressString() string {
 	return c.Address + ":" + strconv.Itoa(c.Port)
 }
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"tcp/utils"
+
+	"go.uber.org/zap"
+)
+
+// O servidor também atende HTTP/1.1 de verdade na mesma porta, para que curl
+// e navegadores possam consultar o dicionário. O protocolo é identificado
+// pelo primeiro byte da conexão: uma requisição HTTP começa com o nome do
+// método (letra maiúscula), enquanto uma mensagem do protocolo próprio começa
+// com o byte mais significativo do prefixo de tamanho, que é 0x00 para
+// mensagens menores que 16 MiB.
+
+// sniffedConn devolve os bytes já lidos na identificação do protocolo antes
+// de continuar lendo da conexão.
+type sniffedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func isHTTP(reader *bufio.Reader) bool {
+	first, err := reader.Peek(1)
+	return err == nil && first[0] >= 'A' && first[0] <= 'Z'
+}
+
+// connListener entrega ao http.Server as conexões identificadas como HTTP.
+type connListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// serve passa a conexão ao http.Server, ou a fecha se ele já foi encerrado.
+func (l *connListener) serve(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func startHTTPServer(config *Config, addr net.Addr, logger *zap.Logger) *connListener {
+	listener := newConnListener(addr)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /termos", dictHandler("LIST", config, logger))
+	mux.HandleFunc("GET /termos/busca", dictHandler("SEARCH", config, logger))
+	mux.HandleFunc("GET /termos/export", dictHandler("EXPORT", config, logger))
+	mux.HandleFunc("POST /termos/import", dictHandler("IMPORT", config, logger))
+	mux.HandleFunc("GET /termos/{termo}", dictHandler("LOOKUP", config, logger))
+	mux.HandleFunc("POST /termos/{termo}", dictHandler("INSERT", config, logger))
+	mux.HandleFunc("PUT /termos/{termo}", dictHandler("UPDATE", config, logger))
+	mux.HandleFunc("DELETE /termos/{termo}", dictHandler("DELETE", config, logger))
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 30 * time.Second,
+		ErrorLog:          zap.NewStdLog(logger),
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Warn("Error serving HTTP", zap.Error(err))
+		}
+	}()
+	return listener
+}
+
+// listFromQuery traduz GET /termos?prefix=&offset=&limit=&sort=&cursor= para
+// LIST [prefixo] [offset] [limite], com a ordem e o cursor nos cabeçalhos.
+// Total e Next-Cursor voltam nos cabeçalhos da resposta.
+func listFromQuery(request *utils.HTTPRequest, query url.Values) {
+	request.Path = query.Get("prefix")
+	request.Body = query.Get("offset")
+	if limit := query.Get("limit"); limit != "" {
+		request.Body = cmp.Or(request.Body, "0") + " " + limit
+	}
+	for key, param := range map[string]string{utils.HeaderSort: "sort", utils.HeaderCursor: "cursor"} {
+		if value := query.Get(param); value != "" {
+			request.Header.Set(key, value)
+		}
+	}
+}
+
+// dictHandler traduz a requisição HTTP para o comando equivalente do
+// protocolo próprio, de modo que as duas formas de acesso compartilham o
+// dicionário e as mesmas regras de bloqueio. O corpo é a definição, em texto,
+// ou o arquivo importado. A requisição é cancelada se o cliente desconectar e
+// tem o mesmo prazo das requisições do protocolo próprio, inclusive o
+// cabeçalho Timeout. If-Match também é repassado, e a versão do termo volta
+// como ETag.
+func dictHandler(method string, config *Config, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(config.MaxMessageSize)))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			status := http.StatusBadRequest
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		logger.Info("HTTP request",
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("proto", r.Proto))
+
+		request := &utils.HTTPRequest{
+			Method: method,
+			Path:   r.PathValue("termo"),
+			Header: utils.Header{},
+			Body:   string(body),
+		}
+		for _, key := range []string{utils.HeaderTimeout, utils.HeaderIfMatch} {
+			if value := r.Header.Get(key); value != "" {
+				request.Header.Set(key, value)
+			}
+		}
+		switch method {
+		case "LIST":
+			listFromQuery(request, r.URL.Query())
+		case "SEARCH":
+			request.Path = r.URL.Query().Get("q")
+		case "EXPORT", "IMPORT":
+			request.Path = r.URL.Query().Get("format")
+			if conflict := r.URL.Query().Get("conflict"); conflict != "" {
+				request.Header.Set(utils.HeaderConflict, conflict)
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), config.RequestTimeout)
+		defer cancel()
+		response := ProcessDictCommand(ctx, request, dict, dictLocks)
+
+		for key, value := range response.Header {
+			w.Header().Set(key, value)
+		}
+		if version, err := strconv.ParseUint(response.Header.Get(utils.HeaderVersion), 10, 64); err == nil {
+			w.Header().Set("ETag", utils.FormatETag(version))
+		}
+		if response.Header.Get(utils.HeaderContentType) == "" {
+			w.Header().Set(utils.HeaderContentType, utils.ContentTypeText)
+		}
+		if id := r.Header.Get(utils.HeaderRequestID); id != "" {
+			w.Header().Set(utils.HeaderRequestID, id)
+		}
+		w.WriteHeader(response.StatusCode)
+		if response.BodyReader != nil {
+			// A exportação, que já termina com uma quebra de linha
+			response.WriteBody(w)
+			return
+		}
+		io.WriteString(w, response.Body+"\n")
+	}
+}
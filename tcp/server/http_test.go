@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"tcp/utils"
+)
+
+// useTestDictionary troca o dicionário global por um em memória com os termos
+// indicados, e o logger por um que não escreve nada, até o fim do teste.
+func useTestDictionary(t *testing.T, terms map[string]string) Store {
+	t.Helper()
+	silenceLogger(t)
+	store := NewDictionary()
+	for term, definition := range terms {
+		if _, _, err := store.Insert(term, definition); err != nil {
+			t.Fatal(err)
+		}
+	}
+	useStore(t, store)
+	return store
+}
+
+func TestIsHTTP(t *testing.T) {
+	tests := []struct {
+		name  string
+		first string
+		want  bool
+	}{
+		{"GET request", "GET /termos HTTP/1.1\r\n", true},
+		{"POST request", "POST /termos/x HTTP/1.1\r\n", true},
+		{"length prefix", "\x00\x00\x00\x10LOOKUP /x\r\n\r\n", false},
+		{"lowercase", "get / HTTP/1.1\r\n", false},
+		{"empty connection", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isHTTP(bufio.NewReader(strings.NewReader(tt.first))); got != tt.want {
+				t.Errorf("isHTTP(%q) = %t, want %t", tt.first, got, tt.want)
+			}
+		})
+	}
+}
+
+// serveTestConn atende o lado servidor de um net.Pipe com handleConnection,
+// com ou sem o servidor HTTP, e retorna o lado do cliente.
+func serveTestConn(t *testing.T, withHTTP bool) net.Conn {
+	t.Helper()
+	config := DefaultConfig()
+	config.MaxMessageSize = 1 << 10
+	client, server := net.Pipe()
+	var httpConns *connListener
+	if withHTTP {
+		httpConns = startHTTPServer(config, server.LocalAddr(), logger)
+		t.Cleanup(func() { httpConns.Close() })
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go handleConnection(server, config, httpConns, logger, wg)
+	t.Cleanup(func() {
+		client.Close()
+		wg.Wait()
+	})
+	return client
+}
+
+func TestSniffedHTTPRequest(t *testing.T) {
+	useTestDictionary(t, map[string]string{"go": "linguagem"})
+	conn := serveTestConn(t, true)
+
+	request, _ := http.NewRequest("GET", "http://dicionario/termos/go", nil)
+	if err := request.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	response, err := http.ReadResponse(bufio.NewReader(conn), request)
+	if err != nil {
+		t.Fatalf("reading the HTTP response: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(body), "linguagem") {
+		t.Errorf("GET /termos/go = %d %q", response.StatusCode, body)
+	}
+	if etag := response.Header.Get("ETag"); etag != utils.FormatETag(1) {
+		t.Errorf("ETag = %q, want %q", etag, utils.FormatETag(1))
+	}
+}
+
+// Uma mensagem com o prefixo de tamanho não é desviada para o servidor HTTP.
+func TestSniffedFrame(t *testing.T) {
+	useTestDictionary(t, map[string]string{"go": "linguagem"})
+	conn := serveTestConn(t, true)
+
+	request := utils.HTTPRequest{Method: "LOOKUP", Path: "go", Header: utils.Header{}}
+	if err := utils.NewFrameWriter(conn).WriteFrame(request.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := utils.NewFrameReader(conn, 0).ReadFrame()
+	if err != nil {
+		t.Fatalf("reading the framed response: %v", err)
+	}
+	response, err := utils.ParseHTTPResponse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 || !strings.Contains(response.Body, "linguagem") {
+		t.Errorf("LOOKUP /go = %d %q", response.StatusCode, response.Body)
+	}
+}
+
+// Sem -http, "GET " é lido como um prefixo de tamanho grande demais.
+func TestHTTPDisabled(t *testing.T) {
+	useTestDictionary(t, nil)
+	conn := serveTestConn(t, false)
+
+	go io.WriteString(conn, "GET /termos HTTP/1.1\r\nHost: x\r\n\r\n")
+	data, err := utils.NewFrameReader(conn, 0).ReadFrame()
+	if err != nil {
+		t.Fatalf("reading the framed response: %v", err)
+	}
+	if response, err := utils.ParseHTTPResponse(data); err != nil || response.StatusCode != 413 {
+		t.Errorf("response = %v, %v, want 413", response, err)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+// A listagem com cursor continua do ponto em que parou mesmo que o dicionário
+// mude entre as páginas: termos removidos, removidos e inseridos de novo (que
+// vão para o fim) ou inseridos antes do cursor.
+func TestPageTermsCursor(t *testing.T) {
+	silenceLogger(t)
+	tests := []struct {
+		name   string
+		sort   string
+		change func(store Store)
+		rest   []string // Termos das páginas seguintes à primeira, [a b]
+	}{
+		{"unchanged", SortInsertion, func(Store) {}, []string{"c", "d", "e", "f"}},
+		{"cursor term deleted", SortInsertion, func(s Store) { s.Delete("b") }, []string{"c", "d", "e", "f"}},
+		{"cursor term reinserted", SortInsertion, func(s Store) {
+			s.Delete("b")
+			s.Insert("b", "de novo")
+		}, []string{"c", "d", "e", "f", "b"}},
+		{"earlier term deleted", SortInsertion, func(s Store) { s.Delete("a") }, []string{"c", "d", "e", "f"}},
+		{"earlier term reinserted", SortInsertion, func(s Store) {
+			s.Delete("a")
+			s.Insert("a", "de novo")
+		}, []string{"c", "d", "e", "f", "a"}},
+		{"later term reinserted", SortInsertion, func(s Store) {
+			s.Delete("c")
+			s.Insert("c", "de novo")
+		}, []string{"d", "e", "f", "c"}},
+		{"term inserted", SortInsertion, func(s Store) { s.Insert("aa", "nova") }, []string{"c", "d", "e", "f", "aa"}},
+		{"alpha, inserted before the cursor", SortAlpha, func(s Store) { s.Insert("aa", "nova") }, []string{"c", "d", "e", "f"}},
+		{"alpha, cursor term deleted", SortAlpha, func(s Store) { s.Delete("b") }, []string{"c", "d", "e", "f"}},
+		{"alpha, cursor term reinserted", SortAlpha, func(s Store) {
+			s.Delete("b")
+			s.Insert("b", "de novo")
+		}, []string{"c", "d", "e", "f"}},
+	}
+	for _, kind := range []string{StoreMemory, StoreFile, StoreKV} {
+		for _, tt := range tests {
+			t.Run(kind+"/"+tt.name, func(t *testing.T) {
+				dir := ""
+				if kind != StoreMemory {
+					dir = t.TempDir()
+				}
+				store := openTestStore(t, kind, dir)
+				defer store.Close()
+				for _, term := range []string{"a", "b", "c", "d", "e", "f"} {
+					store.Insert(term, "definição")
+				}
+
+				page, err := pageTerms(store.ListEntries(), listQuery{Sort: tt.sort, Limit: 2})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(page.Terms, []string{"a", "b"}) {
+					t.Fatalf("first page = %v, want [a b]", page.Terms)
+				}
+				tt.change(store)
+
+				var rest []string
+				for cursor := page.Next; cursor != ""; cursor = page.Next {
+					if page, err = pageTerms(store.ListEntries(), listQuery{Limit: 2, Cursor: cursor}); err != nil {
+						t.Fatal(err)
+					}
+					rest = append(rest, page.Terms...)
+				}
+				if !slices.Equal(rest, tt.rest) {
+					t.Errorf("following pages = %v, want %v", rest, tt.rest)
+				}
+			})
+		}
+	}
+}
+
+// A sequência de inserção cresce na ordem de List, inclusive depois de o
+// armazenamento ser reaberto.
+func TestListEntriesSeq(t *testing.T) {
+	silenceLogger(t)
+	for _, kind := range persistentKinds {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			store := openTestStore(t, kind, dir)
+			for _, term := range []string{"a", "b", "c"} {
+				store.Insert(term, "definição")
+			}
+			store.Delete("a")
+			store.Insert("a", "de novo")
+			store.Update("b", "alterada")
+			checkSeq := func(when string, want []string) {
+				t.Helper()
+				entries := store.ListEntries()
+				var terms []string
+				for i, entry := range entries {
+					terms = append(terms, entry.Term)
+					if i > 0 && entry.Seq <= entries[i-1].Seq {
+						t.Errorf("%s: %+v does not grow in List order", when, entries)
+					}
+				}
+				if !slices.Equal(terms, want) {
+					t.Errorf("%s: terms = %v, want %v", when, terms, want)
+				}
+			}
+			checkSeq("before reopening", []string{"b", "c", "a"})
+			store.Close()
+
+			store = openTestStore(t, kind, dir)
+			defer store.Close()
+			checkSeq("after reopening", []string{"b", "c", "a"})
+		})
+	}
+}
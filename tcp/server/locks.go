@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Número de locks entre os quais os termos são distribuídos
+const lockStripes = 256
+
+// Prazo padrão de uma requisição, incluindo a espera pelos locks
+const DefaultRequestTimeout = 30 * time.Second
+
+// errClientTimeout é a causa do cancelamento quando o prazo que acabou foi o
+// pedido pelo cliente no cabeçalho Timeout, e não o do servidor.
+var errClientTimeout = errors.New("client timeout expired")
+
+// TermLocks serializa as operações sobre um mesmo termo sem bloquear as
+// operações sobre outros termos. Cada termo é associado por hash a um de um
+// número fixo de locks de leitura e escrita, de modo que consultas ao mesmo
+// termo rodam em paralelo e só as alterações são exclusivas. Dois termos podem
+// cair no mesmo lock; isso reduz o paralelismo, mas não afeta a correção.
+type TermLocks struct {
+	stripes []TermLock
+}
+
+func NewTermLocks(stripes int) *TermLocks {
+	l := &TermLocks{stripes: make([]TermLock, max(stripes, 1))}
+	for i := range l.stripes {
+		l.stripes[i].changed = make(chan struct{})
+	}
+	return l
+}
+
+// For retorna o lock do termo.
+func (l *TermLocks) For(term string) *TermLock {
+	return &l.stripes[l.stripe(term)]
+}
+
+// ForTerms retorna os locks dos termos, sem repetir os compartilhados, sempre
+// na mesma ordem. Quem trava vários termos ao mesmo tempo segue essa ordem,
+// então duas requisições nunca esperam uma pela outra em ciclo.
+func (l *TermLocks) ForTerms(terms []string) []*TermLock {
+	stripes := make([]int, 0, len(terms))
+	for _, term := range terms {
+		stripes = append(stripes, l.stripe(term))
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+	locks := make([]*TermLock, len(stripes))
+	for i, stripe := range stripes {
+		locks[i] = &l.stripes[stripe]
+	}
+	return locks
+}
+
+func (l *TermLocks) stripe(term string) int {
+	h := fnv.New32a()
+	h.Write([]byte(term))
+	return int(h.Sum32() % uint32(len(l.stripes)))
+}
+
+// TermLock é um lock de leitura e escrita cuja espera termina quando o
+// contexto da requisição é cancelado ou passa do prazo. Quem espera fica
+// bloqueado em um canal, sem ocupar a CPU. Com um escritor esperando, novos
+// leitores também esperam, para que as alterações não fiquem para sempre
+// atrás de consultas.
+type TermLock struct {
+	mu      sync.Mutex
+	readers int
+	writer  bool
+	waiting int           // Escritores esperando
+	changed chan struct{} // Fechado e recriado a cada liberação
+}
+
+func (l *TermLock) Lock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waiting++
+	defer func() { l.waiting-- }()
+	for l.writer || l.readers > 0 {
+		if err := l.wait(ctx); err != nil {
+			// Leitores podem estar esperando só por este escritor
+			l.notify()
+			return err
+		}
+	}
+	l.writer = true
+	return nil
+}
+
+func (l *TermLock) Unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writer = false
+	l.notify()
+}
+
+func (l *TermLock) RLock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.writer || l.waiting > 0 {
+		if err := l.wait(ctx); err != nil {
+			return err
+		}
+	}
+	l.readers++
+	return nil
+}
+
+func (l *TermLock) RUnlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.readers--
+	if l.readers == 0 {
+		l.notify()
+	}
+}
+
+// wait libera l.mu até a próxima liberação do lock ou o fim do contexto.
+func (l *TermLock) wait(ctx context.Context) error {
+	changed := l.changed
+	l.mu.Unlock()
+	defer l.mu.Lock()
+	select {
+	case <-changed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *TermLock) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// lockWaitStatus é o status da resposta a uma requisição que desistiu de
+// esperar por um lock: 408 se acabou o prazo pedido pelo cliente, 503 se
+// acabou o do servidor ou se a requisição foi cancelada (a conexão fechou,
+// por exemplo).
+func lockWaitStatus(ctx context.Context) int {
+	if errors.Is(context.Cause(ctx), errClientTimeout) {
+		return http.StatusRequestTimeout
+	}
+	return http.StatusServiceUnavailable
+}
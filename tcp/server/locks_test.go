@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tcp/utils"
+
+	"go.uber.org/zap"
+)
+
+// A espera por um lock ocupado termina com o contexto, e o status da resposta
+// diz de quem era o prazo.
+func TestLockWaitStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctx    func() (context.Context, context.CancelFunc)
+		status int
+	}{
+		{"client timeout", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeoutCause(context.Background(), 10*time.Millisecond, errClientTimeout)
+		}, http.StatusRequestTimeout},
+		{"server timeout", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 10*time.Millisecond)
+		}, http.StatusServiceUnavailable},
+		{"canceled", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		for _, mode := range []string{"Lock", "RLock"} {
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				var lock TermLock
+				lock.changed = make(chan struct{})
+				if err := lock.Lock(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				defer lock.Unlock()
+
+				ctx, cancel := tt.ctx()
+				defer cancel()
+				wait := lock.Lock
+				if mode == "RLock" {
+					wait = lock.RLock
+				}
+				if err := wait(ctx); err == nil {
+					t.Fatalf("%s succeeded on a held lock", mode)
+				}
+				if status := lockWaitStatus(ctx); status != tt.status {
+					t.Errorf("lockWaitStatus = %d, want %d", status, tt.status)
+				}
+			})
+		}
+	}
+}
+
+// Um escritor que desiste de esperar libera os leitores que estavam na fila
+// atrás dele.
+func TestLockAbandonedWriterReleasesReaders(t *testing.T) {
+	locks := NewTermLocks(1)
+	lock := locks.For("x")
+	if err := lock.RLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := make(chan error)
+	go func() { writer <- lock.Lock(ctx) }()
+	// Espera o escritor entrar na fila
+	for {
+		lock.mu.Lock()
+		waiting := lock.waiting
+		lock.mu.Unlock()
+		if waiting > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	reader := make(chan error)
+	go func() { reader <- lock.RLock(context.Background()) }()
+
+	cancel()
+	if err := <-writer; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Lock error = %v, want context.Canceled", err)
+	}
+	select {
+	case err := <-reader:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader still waiting after the writer gave up")
+	}
+}
+
+// Pelo ProcessDictCommand, a espera por um termo ocupado responde 408 quando
+// acaba o prazo do cabeçalho Timeout e 503, com Retry-After, quando acaba o do
+// servidor.
+func TestProcessDictCommandLockTimeout(t *testing.T) {
+	silenceLogger(t)
+	tests := []struct {
+		name       string
+		timeout    string // Cabeçalho Timeout
+		serverWait time.Duration
+		status     int
+		body       string
+	}{
+		{"client timeout", "20ms", time.Minute, http.StatusRequestTimeout, "Client timeout expired"},
+		{"server timeout", "", 20 * time.Millisecond, http.StatusServiceUnavailable, "Dictionary busy"},
+		{"client asks for more than the server gives", "1m", 20 * time.Millisecond, http.StatusServiceUnavailable, "Dictionary busy"},
+	}
+	for _, tt := range tests {
+		for _, method := range []string{"LOOKUP", "UPDATE", "DELETE"} {
+			t.Run(tt.name+"/"+method, func(t *testing.T) {
+				dict := NewDictionary()
+				dict.Insert("x", "definição")
+				locks := NewTermLocks(lockStripes)
+				if err := locks.For("x").Lock(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				defer locks.For("x").Unlock()
+
+				request := &utils.HTTPRequest{Method: method, Path: "x", Header: utils.Header{}, Body: "nova"}
+				if tt.timeout != "" {
+					request.Header.Set(utils.HeaderTimeout, tt.timeout)
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), tt.serverWait)
+				defer cancel()
+				response := ProcessDictCommand(ctx, request, dict, locks)
+				if response.StatusCode != tt.status || !strings.HasPrefix(response.Body, tt.body) {
+					t.Errorf("response = %d %q, want %d %q...", response.StatusCode, response.Body, tt.status, tt.body)
+				}
+				if retry := response.Header.Get("Retry-After"); (tt.status == http.StatusServiceUnavailable) != (retry == "1") {
+					t.Errorf("Retry-After = %q", retry)
+				}
+			})
+		}
+	}
+}
+
+// Carga mista dos benchmarks: termos consultados e alterados, fração de
+// alterações e goroutines por CPU, como no teste de carga do cliente UDP
+// (-workers=16 -write-ratio=0.2)
+const (
+	benchTerms       = 256
+	benchWriteRatio  = 0.2
+	benchParallelism = 16
+)
+
+// BenchmarkTermLocks mede LOOKUP e UPDATE em paralelo com os locks por termo,
+// e BenchmarkGlobalMutex, a mesma carga com um único mutex para o dicionário,
+// como antes dos locks por termo. As variantes Writes só alteram, e mostram o
+// commit em grupo: com os locks por termo, as alterações de termos diferentes
+// dividem o fsync.
+//
+//	go test ./server -run '^$' -bench 'TermLocks|GlobalMutex' -benchtime 3s -cpu 1,4,8
+func BenchmarkTermLocks(b *testing.B) {
+	benchmarkStores(b, benchWriteRatio, termLocksOp())
+}
+
+func BenchmarkTermLocksWrites(b *testing.B) {
+	benchmarkStores(b, 1, termLocksOp())
+}
+
+func BenchmarkGlobalMutex(b *testing.B) {
+	benchmarkStores(b, benchWriteRatio, globalMutexOp())
+}
+
+func BenchmarkGlobalMutexWrites(b *testing.B) {
+	benchmarkStores(b, 1, globalMutexOp())
+}
+
+func termLocksOp() func(dict Store, term string, write bool) error {
+	locks := NewTermLocks(lockStripes)
+	ctx := context.Background()
+	return func(dict Store, term string, write bool) error {
+		lock := locks.For(term)
+		if write {
+			if err := lock.Lock(ctx); err != nil {
+				return err
+			}
+			defer lock.Unlock()
+			_, _, err := dict.Update(term, "nova definição")
+			return err
+		}
+		if err := lock.RLock(ctx); err != nil {
+			return err
+		}
+		defer lock.RUnlock()
+		_, _, _, err := dict.LookUp(term)
+		return err
+	}
+}
+
+func globalMutexOp() func(dict Store, term string, write bool) error {
+	var mux sync.Mutex
+	return func(dict Store, term string, write bool) error {
+		mux.Lock()
+		defer mux.Unlock()
+		if write {
+			_, _, err := dict.Update(term, "nova definição")
+			return err
+		}
+		_, _, _, err := dict.LookUp(term)
+		return err
+	}
+}
+
+// benchmarkStores roda a carga em cada tipo de Store, com op fazendo uma
+// consulta ou, se write, uma alteração sob o lock que está sendo medido.
+// writeRatio é a fração de alterações.
+func benchmarkStores(b *testing.B, writeRatio float64, op func(dict Store, term string, write bool) error) {
+	// Os snapshots e compactações registrados no log se misturariam aos resultados
+	defer func(l *zap.Logger) { logger = l }(logger)
+	logger = zap.NewNop()
+	for _, kind := range []string{StoreMemory, StoreFile, StoreKV} {
+		b.Run(kind, func(b *testing.B) {
+			dir := ""
+			if kind != StoreMemory {
+				dir = b.TempDir()
+			}
+			dict, err := OpenStore(kind, dir, 1000)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer dict.Close()
+
+			terms := make([]string, benchTerms)
+			for i := range terms {
+				terms[i] = fmt.Sprintf("termo-%d", i)
+				if _, _, err := dict.Insert(terms[i], "definição"); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.SetParallelism(benchParallelism)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					term := terms[rand.IntN(len(terms))]
+					if err := op(dict, term, rand.Float64() < writeRatio); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Busca textual nos termos e nas definições. O índice invertido associa cada
+// palavra, em minúsculas e sem acentos, aos termos em que ela aparece. Ele é
+// mantido pelo próprio Store a cada alteração aplicada (ver Dictionary.apply e
+// KVStore.apply), inclusive na leitura do disco, e é protegido pelo mesmo lock
+// que protege os termos.
+//
+// Um termo só é encontrado se tiver todas as palavras da busca, no próprio
+// termo ou na definição. Uma palavra terminada em * casa com qualquer palavra
+// que comece com ela ("defin*" casa com "definição" e "definir"). Os
+// resultados são ordenados por TF-IDF: palavras que aparecem mais vezes no
+// termo pesam mais, palavras raras no dicionário pesam mais que as comuns, e as
+// palavras do próprio termo valem termWeight ocorrências na definição.
+
+// Resultados devolvidos quando quem busca não pede outro limite
+const DefaultSearchLimit = 10
+
+// Peso de uma palavra do termo em relação a uma da definição
+const termWeight = 3
+
+// Tamanho máximo, em caracteres, do trecho da definição nos resultados
+const snippetRunes = 80
+
+// SearchResult é um termo encontrado pela busca, com a relevância e um trecho
+// da definição em que as palavras buscadas aparecem entre « e ».
+type SearchResult struct {
+	Term    string
+	Score   float64
+	Snippet string
+}
+
+type searchIndex struct {
+	postings map[string]map[string]int // Palavra -> termo -> ocorrências, com peso
+	words    map[string][]string       // Termo -> palavras indexadas, para a remoção
+	sorted   []string                  // Todas as palavras, em ordem, para as buscas por prefixo
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string]int),
+		words:    make(map[string][]string),
+	}
+}
+
+// add indexa o termo com a definição, substituindo a anterior.
+func (x *searchIndex) add(term, definition string) {
+	x.remove(term)
+	counts := make(map[string]int)
+	for _, t := range tokenize(term) {
+		counts[t.text] += termWeight
+	}
+	for _, t := range tokenize(definition) {
+		counts[t.text]++
+	}
+	words := make([]string, 0, len(counts))
+	for word, count := range counts {
+		postings := x.postings[word]
+		if postings == nil {
+			postings = make(map[string]int)
+			x.postings[word] = postings
+			i, _ := slices.BinarySearch(x.sorted, word)
+			x.sorted = slices.Insert(x.sorted, i, word)
+		}
+		postings[term] = count
+		words = append(words, word)
+	}
+	x.words[term] = words
+}
+
+func (x *searchIndex) remove(term string) {
+	for _, word := range x.words[term] {
+		postings := x.postings[word]
+		delete(postings, term)
+		if len(postings) == 0 {
+			delete(x.postings, word)
+			if i, found := slices.BinarySearch(x.sorted, word); found {
+				x.sorted = slices.Delete(x.sorted, i, i+1)
+			}
+		}
+	}
+	delete(x.words, term)
+}
+
+// expand retorna as palavras do índice que casam com a palavra da busca.
+func (x *searchIndex) expand(word queryWord) []string {
+	if !word.prefix {
+		if _, ok := x.postings[word.text]; ok {
+			return []string{word.text}
+		}
+		return nil
+	}
+	start, _ := slices.BinarySearch(x.sorted, word.text)
+	end := start
+	for end < len(x.sorted) && strings.HasPrefix(x.sorted[end], word.text) {
+		end++
+	}
+	return x.sorted[start:end]
+}
+
+// search retorna todos os termos que têm as palavras, do mais relevante ao
+// menos; empates ficam em ordem alfabética.
+func (x *searchIndex) search(words []queryWord) []SearchResult {
+	if len(words) == 0 {
+		return nil
+	}
+	var scores map[string]float64
+	for _, word := range words {
+		frequencies := make(map[string]int)
+		for _, indexed := range x.expand(word) {
+			for term, count := range x.postings[indexed] {
+				frequencies[term] += count
+			}
+		}
+		if len(frequencies) == 0 {
+			return nil
+		}
+		idf := math.Log(1 + float64(len(x.words))/float64(len(frequencies)))
+		next := make(map[string]float64, len(frequencies))
+		for term, count := range frequencies {
+			previous, ok := scores[term]
+			if scores != nil && !ok {
+				continue
+			}
+			next[term] = previous + (1+math.Log(float64(count)))*idf
+		}
+		scores = next
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for term, score := range scores {
+		results = append(results, SearchResult{Term: term, Score: math.Round(score*1000) / 1000})
+	}
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Term, b.Term)
+	})
+	return results
+}
+
+// queryWord é uma palavra da busca, já normalizada; com prefix, casa com as
+// palavras que começam com ela.
+type queryWord struct {
+	text   string
+	prefix bool
+}
+
+func (w queryWord) matches(word string) bool {
+	if w.prefix {
+		return strings.HasPrefix(word, w.text)
+	}
+	return word == w.text
+}
+
+// parseSearchQuery separa a busca em palavras normalizadas. O * no fim de uma
+// palavra vale para a última parte dela ("pré-defin*" busca "pre" e o prefixo
+// "defin").
+func parseSearchQuery(query string) []queryWord {
+	var words []queryWord
+	for _, field := range strings.Fields(query) {
+		prefix := strings.HasSuffix(field, "*")
+		tokens := tokenize(field)
+		for i, t := range tokens {
+			words = append(words, queryWord{text: t.text, prefix: prefix && i == len(tokens)-1})
+		}
+	}
+	return words
+}
+
+// token é uma palavra normalizada e a posição dela, em runas, no texto
+// original.
+type token struct {
+	text       string
+	start, end int
+}
+
+// tokenize separa o texto em palavras (sequências de letras e dígitos) em
+// minúsculas e sem acentos. Acentos como caracteres combinantes, de textos
+// decompostos, também são descartados.
+func tokenize(text string) []token {
+	runes := []rune(text)
+	var tokens []token
+	var b strings.Builder
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) {
+			r := runes[i]
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				if start < 0 {
+					start = i
+					b.Reset()
+				}
+				b.WriteRune(foldRune(r))
+				continue
+			}
+			if start >= 0 && unicode.Is(unicode.Mn, r) {
+				continue
+			}
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{text: b.String(), start: start, end: i})
+			start = -1
+		}
+	}
+	return tokens
+}
+
+// accentFolds leva cada letra acentuada, em minúscula, à letra sem acento.
+var accentFolds = func() map[rune]rune {
+	folds := make(map[rune]rune)
+	for base, accented := range map[rune]string{
+		'a': "àáâãäåā",
+		'c': "çć",
+		'e': "èéêëē",
+		'i': "ìíîïī",
+		'n': "ñ",
+		'o': "òóôõöøō",
+		'u': "ùúûüū",
+		'y': "ýÿ",
+	} {
+		for _, r := range accented {
+			folds[r] = base
+		}
+	}
+	return folds
+}()
+
+func foldRune(r rune) rune {
+	r = unicode.ToLower(r)
+	if base, ok := accentFolds[r]; ok {
+		return base
+	}
+	return r
+}
+
+// snippet recorta da definição até snippetRunes caracteres em torno da
+// primeira palavra buscada, sem cortar palavras, e marca as palavras buscadas
+// com « e ». Se elas só aparecem no termo, o trecho é o começo da definição.
+func snippet(definition string, words []queryWord) string {
+	runes := []rune(definition)
+	tokens := tokenize(definition)
+	var hits []token
+	for _, t := range tokens {
+		if slices.ContainsFunc(words, func(w queryWord) bool { return w.matches(t.text) }) {
+			hits = append(hits, t)
+		}
+	}
+
+	start, end := 0, min(len(runes), snippetRunes)
+	if len(hits) > 0 {
+		end = min(len(runes), max(hits[0].start-snippetRunes/4, 0)+snippetRunes)
+		start = max(end-snippetRunes, 0)
+	}
+	// Ajusta as pontas para o começo e o fim das palavras dentro da janela
+	if start > 0 {
+		if i := slices.IndexFunc(tokens, func(t token) bool { return t.start >= start }); i >= 0 && tokens[i].start < end {
+			start = tokens[i].start
+		}
+	}
+	if end < len(runes) {
+		for i := len(tokens) - 1; i >= 0; i-- {
+			if tokens[i].end <= end {
+				if tokens[i].end > start {
+					end = tokens[i].end
+				}
+				break
+			}
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, hit := range hits {
+		if hit.start < start || hit.end > end {
+			continue
+		}
+		b.WriteString(string(runes[pos:hit.start]))
+		b.WriteString("«" + string(runes[hit.start:hit.end]) + "»")
+		pos = hit.end
+	}
+	b.WriteString(string(runes[pos:end]))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
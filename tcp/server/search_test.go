@@ -0,0 +1,93 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+// A busca ignora maiúsculas e acentos, tanto compostos quanto decompostos, no
+// dicionário e na busca, e uma palavra terminada em * casa com as palavras que
+// começam com ela. Os termos precisam ter todas as palavras buscadas.
+func TestSearch(t *testing.T) {
+	silenceLogger(t)
+	tests := []struct {
+		name  string
+		query string
+		want  []string // Termos encontrados, do mais relevante ao menos; empates pelos bytes do termo
+	}{
+		{"without accents", "acao", []string{"ação"}},
+		{"upper case", "AÇÃO", []string{"ação"}},
+		{"other accent", "acão", []string{"ação"}},
+		{"decomposed query", "café", []string{"café"}},
+		{"decomposed definition", "definicao", []string{"definição", "dicionário"}},
+		{"word in definition only", "graos", []string{"café"}},
+		{"prefix", "defin*", []string{"pré-definido", "definir", "definição", "dicionário"}},
+		{"prefix without accents", "DEFINI*", []string{"pré-definido", "definir", "definição", "dicionário"}},
+		{"prefix with accent", "definiçã*", []string{"definição", "dicionário"}},
+		{"prefix is whole word", "cafe*", []string{"café"}},
+		{"prefix after hyphen", "pre-defin*", []string{"pré-definido"}},
+		{"prefix and word", "signif* palavra", []string{"definição"}},
+		{"prefix matching nothing", "xyz*", nil},
+		{"all words required", "defin* cafe", nil},
+		{"only an asterisk", "*", nil},
+		{"asterisk inside a word", "de*nir", nil},
+	}
+	for _, kind := range []string{StoreMemory, StoreFile, StoreKV} {
+		t.Run(kind, func(t *testing.T) {
+			dir := ""
+			if kind != StoreMemory {
+				dir = t.TempDir()
+			}
+			store := openTestStore(t, kind, dir)
+			defer store.Close()
+			for _, entry := range []Entry{
+				{"ação", "Efeito de agir"},
+				{"café", "Bebida feita com grãos torrados"},
+				{"definição", "Significado de uma palavra"},
+				{"definir", "Dar o significado; determinar"},
+				{"pré-definido", "Definido antes"},
+				{"dicionário", "Lista de palavras com a sua definiç̃ao"[:0] + "Lista de palavras com a sua definição"},
+			} {
+				if _, _, err := store.Insert(entry.Term, entry.Definition); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					results, total, err := store.Search(tt.query, 0)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var terms []string
+					for _, result := range results {
+						terms = append(terms, result.Term)
+					}
+					if !slices.Equal(terms, tt.want) || total != len(tt.want) {
+						t.Errorf("Search(%q) = %v (total %d), want %v", tt.query, terms, total, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+// O trecho marca as palavras buscadas como aparecem na definição, com os
+// acentos originais.
+func TestSearchSnippet(t *testing.T) {
+	tests := []struct {
+		query      string
+		definition string
+		want       string
+	}{
+		{"acao", "Uma Ação rápida", "Uma «Ação» rápida"},
+		{"defin*", "Definir e redefinir a definição", "«Definir» e redefinir a «definição»"},
+		{"cafe", "Café forte", "«Café» forte"},
+		{"outra", "Só no termo", "Só no termo"},
+	}
+	for _, tt := range tests {
+		if got := snippet(tt.definition, parseSearchQuery(tt.query)); got != tt.want {
+			t.Errorf("snippet(%q, %q) = %q, want %q", tt.definition, tt.query, got, tt.want)
+		}
+	}
+}
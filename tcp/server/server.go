@@ -1,21 +1,35 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
 	"net"
+	"strings"
 	"sync"
+	"time"
 
 	"tcp/utils"
 
 	"go.uber.org/zap"
 )
 
-var dict = NewDictionary()
-var dictMutex sync.Mutex
+var dict Store = NewDictionary()
+var dictLocks = NewTermLocks(lockStripes)
 
 func StartServer(config *Config) error {
 	logger := utils.GetLogger()
 	wg := &sync.WaitGroup{}
 
+	store, err := OpenStore(config.Store, config.DataDir, config.SnapshotEvery)
+	if err != nil {
+		logger.Warn("Error loading dictionary", zap.Error(err))
+		return err
+	}
+	defer store.Close()
+	dict = store
+
 	listener, err := net.Listen("tcp", config.AddressString())
 	if err != nil {
 		logger.Warn("Error starting server", zap.Error(err))
@@ -25,6 +39,12 @@ func StartServer(config *Config) error {
 	defer wg.Wait()
 	logger.Info("Server started", zap.String("address", config.AddressString()))
 
+	var httpConns *connListener
+	if config.HTTP {
+		httpConns = startHTTPServer(config, listener.Addr(), logger)
+		defer httpConns.Close()
+	}
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -33,43 +53,118 @@ func StartServer(config *Config) error {
 		}
 		logger.Info("Client connected", zap.String("remote_addr", conn.RemoteAddr().String()))
 		wg.Add(1)
-		go handleConnection(conn, logger, wg)
+		go handleConnection(conn, config, httpConns, logger, wg)
 	}
 }
 
-func handleConnection(conn net.Conn, logger *zap.Logger, wg *sync.WaitGroup) {
+// handleConnection lê as requisições da conexão e as repassa, em ordem, para
+// serveRequests. O cliente pode enviar várias requisições sem esperar as
+// respostas (pipeline); até config.MaxInFlight ficam na fila e, com a fila
+// cheia, a leitura espera e o TCP propaga a contenção ao cliente. Quando a
+// leitura termina (o cliente fechou a conexão, por exemplo), as requisições
+// que ainda esperam por um lock são canceladas e a transação aberta, se houver,
+// é desfeita. Conexões HTTP/1.1 são repassadas para httpConns (ver http.go).
+func handleConnection(conn net.Conn, config *Config, httpConns *connListener, logger *zap.Logger, wg *sync.WaitGroup) {
+	buffered := bufio.NewReader(conn)
+	if httpConns != nil && isHTTP(buffered) {
+		logger.Info("HTTP client detected", zap.String("remote_addr", conn.RemoteAddr().String()))
+		wg.Done()
+		httpConns.serve(&sniffedConn{Conn: conn, reader: buffered})
+		return
+	}
+	conn = &sniffedConn{Conn: conn, reader: buffered}
+
 	defer func() {
 		logger.Info("Client disconnected", zap.String("remote_addr", conn.RemoteAddr().String()))
 		conn.Close()
 		wg.Done()
 	}()
-	buffer := make([]byte, 1024)
+	reader := utils.NewFrameReader(conn, config.MaxMessageSize)
+	writer := utils.NewFrameWriter(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	inFlight := make(chan []byte, max(config.MaxInFlight, 1))
+	done := make(chan struct{})
+	session := &txSession{}
+	go serveRequests(ctx, conn, writer, session, inFlight, done, config.RequestTimeout, logger)
+	var rejected *utils.HTTPResponse
+	defer func() {
+		cancel()
+		// Responde as requisições que ainda estão na fila
+		close(inFlight)
+		<-done
+		session.rollback(conn.RemoteAddr().String())
+		if rejected != nil {
+			writer.WriteFrame(rejected.Bytes())
+		}
+	}()
+
 	for {
-		n, err := conn.Read(buffer)
+		data, err := reader.ReadFrame()
 		if err != nil {
-			logger.Warn("Error reading from connection", zap.Error(err))
+			if errors.Is(err, utils.ErrFrameTooLarge) {
+				// O restante da mensagem não pode ser descartado com segurança,
+				// então a conexão é encerrada depois da resposta
+				rejected = &utils.HTTPResponse{
+					StatusCode: 413,
+					Body:       err.Error(),
+				}
+			}
+			if err != io.EOF {
+				logger.Warn("Error reading from connection", zap.Error(err))
+			}
 			return
 		}
-		data := buffer[:n]
-		logger.Info("Received data", zap.ByteString("data", data))
-		wg.Add(1)
-		go processData(data, conn, logger, wg)
+		logger.Info("Received data",
+			zap.Int("bytes", len(data)),
+			zap.Int("in_flight", len(inFlight)))
+		inFlight <- data
+	}
+}
+
+// serveRequests processa as requisições de uma conexão uma de cada vez, na
+// ordem em que chegaram, de modo que as respostas saem na mesma ordem e cada
+// requisição vê o efeito das anteriores (um LOOKUP depois de um INSERT, por
+// exemplo). Conexões diferentes continuam sendo atendidas em paralelo. Depois
+// de um erro de escrita ou de uma requisição com "Connection: close" as
+// requisições restantes são descartadas. Cada requisição tem até timeout para
+// ser atendida, contado a partir do momento em que começa a ser processada.
+// Como as requisições são atendidas em ordem, a transação da conexão (session)
+// não precisa de lock.
+func serveRequests(ctx context.Context, conn net.Conn, writer *utils.FrameWriter, session *txSession, inFlight <-chan []byte, done chan<- struct{}, timeout time.Duration, logger *zap.Logger) {
+	defer close(done)
+	closing := false
+	for data := range inFlight {
+		if closing {
+			continue
+		}
+		requestCtx, cancel := context.WithTimeout(ctx, timeout)
+		response := processData(requestCtx, data, session, logger)
+		cancel()
+		if err := response.WriteFrame(writer); err != nil {
+			logger.Warn("Error writing to connection", zap.Error(err))
+			closing = true
+		} else if response.Header.Get(utils.HeaderConnection) == "close" {
+			closing = true
+		}
+		if closing {
+			// Interrompe a leitura para que a conexão seja encerrada
+			conn.Close()
+		}
 	}
 }
 
-func processData(data []byte, conn net.Conn, logger *zap.Logger, wg *sync.WaitGroup) {
-	defer wg.Done()
+func processData(ctx context.Context, data []byte, session *txSession, logger *zap.Logger) utils.HTTPResponse {
 	logger.Info("Processing data", zap.ByteString("data", data))
 
 	request, err := utils.ParseHTTPRequest(data)
 	if err != nil {
 		response := utils.HTTPResponse{
 			StatusCode: 400,
-			Message:    "Invalid request format: " + err.Error(),
+			Body:       "Invalid request format: " + err.Error(),
 		}
 		logger.Warn("Invalid request", zap.Error(err))
-		conn.Write(response.Bytes())
-		return
+		return response
 	}
 
 	logger.Info("Parsed request",
@@ -83,7 +178,7 @@ func processData(data []byte, conn net.Conn, logger *zap.Logger, wg *sync.WaitGr
 		Use functions from server/utils.go as needed.
 		==================================================
 	*/
-	response := ProcessDictCommand(request, dict, &dictMutex)
+	response := session.process(ctx, request)
 
 	/*
 		==================================================
@@ -91,8 +186,17 @@ func processData(data []byte, conn net.Conn, logger *zap.Logger, wg *sync.WaitGr
 		==================================================
 	*/
 
-	_, err = conn.Write(response.Bytes())
-	if err != nil {
-		logger.Warn("Error writing to connection", zap.Error(err))
+	return withRequestHeaders(request, response)
+}
+
+// withRequestHeaders devolve o Request-Id da requisição, para o cliente
+// associar a resposta, e confirma o pedido de encerramento da conexão.
+func withRequestHeaders(request *utils.HTTPRequest, response utils.HTTPResponse) utils.HTTPResponse {
+	if id := request.Header.Get(utils.HeaderRequestID); id != "" {
+		response.SetHeader(utils.HeaderRequestID, id)
+	}
+	if strings.EqualFold(request.Header.Get(utils.HeaderConnection), "close") {
+		response.SetHeader(utils.HeaderConnection, "close")
 	}
+	return response
 }
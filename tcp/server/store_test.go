@@ -0,0 +1,565 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tcp/utils"
+)
+
+// persistentKinds são os tipos de Store que gravam em disco.
+var persistentKinds = []string{StoreFile, StoreKV}
+
+func openTestStore(t *testing.T, kind, dir string) Store {
+	t.Helper()
+	store, err := OpenStore(kind, dir, DefaultSnapshotEvery)
+	if err != nil {
+		t.Fatalf("OpenStore(%s): %v", kind, err)
+	}
+	return store
+}
+
+// dataFile é o arquivo onde o Store do tipo kind acrescenta as alterações.
+func dataFile(kind, dir string) string {
+	if kind == StoreKV {
+		return filepath.Join(dir, kvFileName)
+	}
+	return filepath.Join(dir, walFileName)
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+// storeContents descreve o Store como "termo=definição@versão", na ordem de
+// List.
+func storeContents(t *testing.T, store Store) []string {
+	t.Helper()
+	var contents []string
+	for _, term := range store.List() {
+		definition, version, exists, err := store.LookUp(term)
+		if err != nil || !exists {
+			t.Fatalf("LookUp(%q) = %v, %v for a listed term", term, exists, err)
+		}
+		contents = append(contents, term+"="+definition+"@"+utils.FormatETag(version))
+	}
+	return contents
+}
+
+func TestStoreOperations(t *testing.T) {
+	silenceLogger(t)
+	for _, kind := range []string{StoreMemory, StoreFile, StoreKV} {
+		t.Run(kind, func(t *testing.T) {
+			dir := ""
+			if kind != StoreMemory {
+				dir = t.TempDir()
+			}
+			store := openTestStore(t, kind, dir)
+			defer store.Close()
+
+			if v, ok, err := store.Insert("go", "linguagem"); err != nil || !ok || v != 1 {
+				t.Fatalf("Insert = %d, %t, %v", v, ok, err)
+			}
+			if _, ok, _ := store.Insert("go", "outra"); ok {
+				t.Fatalf("Insert of an existing term succeeded")
+			}
+			store.Insert("rust", "outra linguagem")
+			if v, ok, err := store.Update("go", "linguagem do Google"); err != nil || !ok || v != 3 {
+				t.Fatalf("Update = %d, %t, %v", v, ok, err)
+			}
+			if _, ok, _ := store.Update("zig", "x"); ok {
+				t.Fatalf("Update of a missing term succeeded")
+			}
+			if ok, err := store.Delete("rust"); err != nil || !ok {
+				t.Fatalf("Delete = %t, %v", ok, err)
+			}
+			if ok, _ := store.Delete("rust"); ok {
+				t.Fatalf("Delete of a missing term succeeded")
+			}
+			// A remoção também consome uma versão
+			if v, _, _ := store.Insert("rust", "de novo"); v != 5 {
+				t.Errorf("reinserted term got version %d, want 5", v)
+			}
+			want := []string{`go=linguagem do Google@"3"`, `rust=de novo@"5"`}
+			if got := storeContents(t, store); !slices.Equal(got, want) {
+				t.Errorf("contents = %v, want %v", got, want)
+			}
+
+			results, total, err := store.Search("google", 0)
+			if err != nil || total != 1 || results[0].Term != "go" {
+				t.Errorf("Search = %v, %d, %v", results, total, err)
+			}
+			var ranged []string
+			store.Range(func(term, _ string) bool {
+				ranged = append(ranged, term)
+				return false
+			})
+			if !slices.Equal(ranged, []string{"go"}) {
+				t.Errorf("Range stopped after %v, want [go]", ranged)
+			}
+		})
+	}
+}
+
+// O log (ou o arquivo chave-valor) é reaplicado na abertura, com as mesmas
+// versões, e a revisão não volta atrás por causa de um termo removido.
+func TestStoreReplayAfterRestart(t *testing.T) {
+	silenceLogger(t)
+	for _, kind := range persistentKinds {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			store := openTestStore(t, kind, dir)
+			store.Insert("go", "linguagem")
+			store.Insert("rust", "outra")
+			store.Update("go", "linguagem do Google")
+			store.Apply([]Change{{Term: "zig", Definition: "mais uma"}, {Term: "rust", Delete: true}})
+			want := storeContents(t, store)
+			store.Close()
+
+			store = openTestStore(t, kind, dir)
+			defer store.Close()
+			if got := storeContents(t, store); !slices.Equal(got, want) {
+				t.Errorf("after reopening: %v, want %v", got, want)
+			}
+			if v, _, _ := store.Insert("rust", "de novo"); v != 5 {
+				t.Errorf("insert after reopening got version %d, want 5", v)
+			}
+		})
+	}
+}
+
+// Uma queda no meio da gravação deixa o último registro cortado: ele é
+// descartado na abertura, e o arquivo volta a aceitar gravações.
+func TestStoreTornLastRecord(t *testing.T) {
+	silenceLogger(t)
+	for _, kind := range persistentKinds {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			store := openTestStore(t, kind, dir)
+			store.Insert("go", "linguagem")
+			want := storeContents(t, store)
+			before := fileSize(t, dataFile(kind, dir))
+			store.Insert("rust", "registro que a queda corta ao meio")
+			after := fileSize(t, dataFile(kind, dir))
+			store.Close()
+			if err := os.Truncate(dataFile(kind, dir), before+(after-before)/2); err != nil {
+				t.Fatal(err)
+			}
+
+			store = openTestStore(t, kind, dir)
+			if got := storeContents(t, store); !slices.Equal(got, want) {
+				t.Errorf("after the torn write: %v, want %v", got, want)
+			}
+			if size := fileSize(t, dataFile(kind, dir)); size != before {
+				t.Errorf("file has %d bytes after recovery, want %d", size, before)
+			}
+			store.Insert("zig", "depois da queda")
+			want = storeContents(t, store)
+			store.Close()
+
+			store = openTestStore(t, kind, dir)
+			defer store.Close()
+			if got := storeContents(t, store); !slices.Equal(got, want) {
+				t.Errorf("after writing past the recovered record: %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// Um registro cujo fsync falhou não é aplicado, e também não pode reaparecer
+// quando o arquivo for lido de novo.
+func TestStoreSyncFailure(t *testing.T) {
+	silenceLogger(t)
+	defer func(sync func(*os.File) error) { syncFile = sync }(syncFile)
+	errDisk := errors.New("disco cheio")
+	for _, kind := range persistentKinds {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			store := openTestStore(t, kind, dir)
+			store.Insert("go", "linguagem")
+			size := fileSize(t, dataFile(kind, dir))
+
+			syncFile = func(*os.File) error { return errDisk }
+			_, _, err := store.Insert("rust", "não sincronizado")
+			syncFile = (*os.File).Sync
+			if !errors.Is(err, errDisk) {
+				t.Fatalf("Insert error = %v, want the fsync error", err)
+			}
+			if _, _, exists, _ := store.LookUp("rust"); exists {
+				t.Errorf("failed insert visible in memory")
+			}
+			if got := fileSize(t, dataFile(kind, dir)); got != size {
+				t.Errorf("file has %d bytes after the failed write, want %d", got, size)
+			}
+			if v, ok, err := store.Insert("zig", "depois da falha"); err != nil || !ok || v != 2 {
+				t.Fatalf("Insert after the failure = %d, %t, %v", v, ok, err)
+			}
+			store.Close()
+
+			store = openTestStore(t, kind, dir)
+			defer store.Close()
+			want := []string{`go=linguagem@"1"`, `zig=depois da falha@"2"`}
+			if got := storeContents(t, store); !slices.Equal(got, want) {
+				t.Errorf("after reopening: %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// pendingWrites conta os registros escritos que esperam um fsync.
+func pendingWrites(store Store) int {
+	switch s := store.(type) {
+	case *Dictionary:
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		return len(s.storage.pending)
+	case *KVStore:
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		return len(s.pending)
+	}
+	return 0
+}
+
+// As alterações escritas enquanto um fsync está em andamento são confirmadas
+// juntas pelo próximo; se o fsync falhar, todas as que estavam no arquivo
+// falham com ele.
+func TestStoreGroupCommit(t *testing.T) {
+	silenceLogger(t)
+	defer func(sync func(*os.File) error) { syncFile = sync }(syncFile)
+	errDisk := errors.New("disco cheio")
+	const writers = 5
+	tests := []struct {
+		name    string
+		syncErr error // Resultado do primeiro fsync
+		syncs   int32
+		want    []string
+	}{
+		{"fsync succeeds", nil, 2, []string{`a=0@"1"`, `b=1@"2"`, `c=2@"3"`, `d=3@"4"`, `e=4@"5"`, `f=5@"6"`}},
+		{"fsync fails", errDisk, 1, nil},
+	}
+	for _, tt := range tests {
+		for _, kind := range persistentKinds {
+			t.Run(tt.name+"/"+kind, func(t *testing.T) {
+				dir := t.TempDir()
+				store := openTestStore(t, kind, dir)
+				defer func() { store.Close() }()
+				size := fileSize(t, dataFile(kind, dir))
+
+				var syncs atomic.Int32
+				started, release := make(chan struct{}), make(chan struct{})
+				syncFile = func(f *os.File) error {
+					if syncs.Add(1) == 1 {
+						close(started)
+						<-release
+						if tt.syncErr != nil {
+							return tt.syncErr
+						}
+					}
+					return f.Sync()
+				}
+				defer func() { syncFile = (*os.File).Sync }()
+
+				errs := make(chan error, writers+1)
+				insert := func(i int) {
+					_, _, err := store.Insert(string(rune('a'+i)), strconv.Itoa(i))
+					errs <- err
+				}
+				go insert(0)
+				<-started
+				// Os outros escrevem durante o primeiro fsync, um de cada vez para que
+				// as versões sigam a ordem, e esperam pelo próximo
+				for i := 1; i <= writers; i++ {
+					go insert(i)
+					for pendingWrites(store) < i+1 {
+						time.Sleep(time.Millisecond)
+					}
+				}
+				close(release)
+				for range writers + 1 {
+					if err := <-errs; !errors.Is(err, tt.syncErr) {
+						t.Errorf("Insert error = %v, want %v", err, tt.syncErr)
+					}
+				}
+				if n := syncs.Load(); n != tt.syncs {
+					t.Errorf("%d fsyncs, want %d", n, tt.syncs)
+				}
+				want := tt.want
+				if got := storeContents(t, store); !slices.Equal(got, want) {
+					t.Errorf("contents = %v, want %v", got, want)
+				}
+				if tt.syncErr != nil {
+					if got := fileSize(t, dataFile(kind, dir)); got != size {
+						t.Errorf("file has %d bytes after the failed fsync, want %d", got, size)
+					}
+					if v, _, err := store.Insert("z", "depois da falha"); err != nil || v != 1 {
+						t.Fatalf("Insert after the failure = %d, %v", v, err)
+					}
+					want = []string{`z=depois da falha@"1"`}
+				}
+				store.Close()
+
+				store = openTestStore(t, kind, dir)
+				if got := storeContents(t, store); !slices.Equal(got, want) {
+					t.Errorf("after reopening: %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+// Um snapshot com alterações esperando o fsync mantém essas alterações no log.
+func TestFileStoreSnapshotWithPendingWrites(t *testing.T) {
+	silenceLogger(t)
+	defer func(sync func(*os.File) error) { syncFile = sync }(syncFile)
+	dir := t.TempDir()
+	store, err := OpenStore(StoreFile, dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	var syncs atomic.Int32
+	syncFile = func(f *os.File) error {
+		if syncs.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		return f.Sync()
+	}
+	defer func() { syncFile = (*os.File).Sync }()
+
+	errs := make(chan error, 2)
+	go func() { _, _, err := store.Insert("go", "linguagem"); errs <- err }()
+	<-started
+	go func() { _, _, err := store.Insert("rust", "escrita durante o fsync"); errs <- err }()
+	for pendingWrites(store) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// O snapshot depois do primeiro fsync só contém "go"
+	close(release)
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{`go=linguagem@"1"`, `rust=escrita durante o fsync@"2"`}
+	if got := storeContents(t, store); !slices.Equal(got, want) {
+		t.Fatalf("contents = %v, want %v", got, want)
+	}
+	store.Close()
+
+	store = openTestStore(t, StoreFile, dir)
+	defer store.Close()
+	if got := storeContents(t, store); !slices.Equal(got, want) {
+		t.Errorf("after reopening: %v, want %v", got, want)
+	}
+}
+
+// Com os registros substituídos passando da metade do arquivo, o KVStore o
+// reescreve só com os termos atuais.
+func TestKVCompaction(t *testing.T) {
+	silenceLogger(t)
+	dir := t.TempDir()
+	store := openTestStore(t, StoreKV, dir)
+	definition := strings.Repeat("x", 64<<10)
+	store.Insert("go", definition)
+	store.Insert("rust", "curta")
+	store.Delete("rust")
+	peak := int64(0)
+	for i := range 40 {
+		store.Update("go", definition+string(rune('a'+i%26)))
+		peak = max(peak, fileSize(t, dataFile(StoreKV, dir)))
+	}
+	want := storeContents(t, store)
+	if size := fileSize(t, dataFile(StoreKV, dir)); size >= kvCompactMinBytes || size >= peak {
+		t.Fatalf("file has %d bytes (peak %d), want it compacted", size, peak)
+	}
+	if _, err := os.Stat(dataFile(StoreKV, dir) + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary compaction file left behind: %v", err)
+	}
+	store.Close()
+
+	store = openTestStore(t, StoreKV, dir)
+	defer store.Close()
+	if got := storeContents(t, store); !slices.Equal(got, want) {
+		t.Errorf("after reopening the compacted file: %.60q, want %.60q", got, want)
+	}
+	if v, _, _ := store.Insert("rust", "de novo"); v != 44 {
+		t.Errorf("insert after compaction got version %d, want 44", v)
+	}
+}
+
+// A cada snapshotEvery operações o StoreFile grava o snapshot e esvazia o log.
+func TestFileStoreSnapshot(t *testing.T) {
+	silenceLogger(t)
+	dir := t.TempDir()
+	store, err := OpenStore(StoreFile, dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Insert("go", "linguagem")
+	store.Insert("rust", "outra")
+	store.Delete("rust")
+	if size := fileSize(t, dataFile(StoreFile, dir)); size != 0 {
+		t.Errorf("log has %d bytes after the snapshot, want 0", size)
+	}
+	store.Insert("zig", "depois do snapshot")
+	want := storeContents(t, store)
+	store.Close()
+
+	store = openTestStore(t, StoreFile, dir)
+	defer store.Close()
+	if got := storeContents(t, store); !slices.Equal(got, want) {
+		t.Errorf("after reopening: %v, want %v", got, want)
+	}
+	if v, _, _ := store.Insert("rust", "de novo"); v != 5 {
+		t.Errorf("insert after the snapshot got version %d, want 5", v)
+	}
+}
+
+// fakeStore é um Store em memória cujas operações podem falhar.
+type fakeStore struct {
+	Store
+	readErr  error // Retornado por LookUp, Search e Range
+	writeErr error // Retornado por Insert, Update, Delete e Apply
+	writes   int
+}
+
+func (f *fakeStore) LookUp(term string) (string, uint64, bool, error) {
+	if f.readErr != nil {
+		return "", 0, false, f.readErr
+	}
+	return f.Store.LookUp(term)
+}
+
+func (f *fakeStore) Search(query string, limit int) ([]SearchResult, int, error) {
+	if f.readErr != nil {
+		return nil, 0, f.readErr
+	}
+	return f.Store.Search(query, limit)
+}
+
+func (f *fakeStore) Range(fn func(term, definition string) bool) error {
+	if f.readErr != nil {
+		return f.readErr
+	}
+	return f.Store.Range(fn)
+}
+
+func (f *fakeStore) Insert(term, definition string) (uint64, bool, error) {
+	f.writes++
+	if f.writeErr != nil {
+		return 0, false, f.writeErr
+	}
+	return f.Store.Insert(term, definition)
+}
+
+func (f *fakeStore) Update(term, definition string) (uint64, bool, error) {
+	f.writes++
+	if f.writeErr != nil {
+		return 0, false, f.writeErr
+	}
+	return f.Store.Update(term, definition)
+}
+
+func (f *fakeStore) Delete(term string) (bool, error) {
+	f.writes++
+	if f.writeErr != nil {
+		return false, f.writeErr
+	}
+	return f.Store.Delete(term)
+}
+
+func (f *fakeStore) Apply(changes []Change) (uint64, error) {
+	f.writes++
+	if f.writeErr != nil {
+		return 0, f.writeErr
+	}
+	return f.Store.Apply(changes)
+}
+
+func TestProcessDictCommandStoreErrors(t *testing.T) {
+	silenceLogger(t)
+	errDisk := errors.New("disco cheio")
+	tests := []struct {
+		name    string
+		method  string
+		term    string
+		body    string
+		header  []string
+		store   fakeStore
+		status  int
+		message string
+		writes  int
+	}{
+		{"LOOKUP read error", "LOOKUP", "go", "", nil, fakeStore{readErr: errDisk}, http.StatusInternalServerError, "Error reading dictionary: disco cheio", 0},
+		{"SEARCH read error", "SEARCH", "go", "", nil, fakeStore{readErr: errDisk}, http.StatusInternalServerError, "Error reading dictionary: disco cheio", 0},
+		{"INSERT write error", "INSERT", "zig", "x", nil, fakeStore{writeErr: errDisk}, http.StatusInternalServerError, "Error saving dictionary: disco cheio", 1},
+		{"UPDATE write error", "UPDATE", "go", "x", nil, fakeStore{writeErr: errDisk}, http.StatusInternalServerError, "Error saving dictionary: disco cheio", 1},
+		{"DELETE write error", "DELETE", "go", "", nil, fakeStore{writeErr: errDisk}, http.StatusInternalServerError, "Error saving dictionary: disco cheio", 1},
+		// O CAS lê a versão antes de gravar, e não grava se a leitura falhar
+		{"CAS read error", "CAS", "go", "x", []string{utils.HeaderIfMatch, "1"}, fakeStore{readErr: errDisk}, http.StatusInternalServerError, "Error reading dictionary: disco cheio", 0},
+		{"CAS conflict", "CAS", "go", "x", []string{utils.HeaderIfMatch, "9"}, fakeStore{}, http.StatusConflict, "Term 'go' was modified", 0},
+		{"LIST without errors", "LIST", "", "", nil, fakeStore{readErr: errDisk, writeErr: errDisk}, http.StatusOK, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store
+			store.Store = NewDictionary()
+			store.Store.Insert("go", "linguagem")
+			response := command(t, &store, NewTermLocks(lockStripes), tt.method, tt.term, tt.body, tt.header...)
+			if response.StatusCode != tt.status || !strings.HasPrefix(response.Body, tt.message) {
+				t.Errorf("%s = %d %q, want %d %q...", tt.method, response.StatusCode, response.Body, tt.status, tt.message)
+			}
+			if store.writes != tt.writes {
+				t.Errorf("%d writes reached the store, want %d", store.writes, tt.writes)
+			}
+		})
+	}
+}
+
+// Uma transação cujo COMMIT falha ao gravar não deixa alteração nenhuma.
+func TestCommitStoreError(t *testing.T) {
+	silenceLogger(t)
+	store := &fakeStore{Store: NewDictionary()}
+	store.Store.Insert("go", "linguagem")
+	useStore(t, store)
+	session := &txSession{}
+	for _, request := range []utils.HTTPRequest{
+		{Method: "BEGIN"},
+		{Method: "UPDATE", Path: "go", Body: "nova"},
+		{Method: "INSERT", Path: "zig", Body: "outra"},
+	} {
+		session.process(context.Background(), &request)
+	}
+	store.writeErr = errors.New("disco cheio")
+	response := session.process(context.Background(), &utils.HTTPRequest{Method: "COMMIT"})
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("COMMIT = %d %q, want 500", response.StatusCode, response.Body)
+	}
+	if got := storeContents(t, store); !slices.Equal(got, []string{`go=linguagem@"1"`}) {
+		t.Errorf("contents after the failed COMMIT = %v", got)
+	}
+}
+
+// useStore troca o dicionário global, usado pelas transações, até o fim do
+// teste.
+func useStore(t *testing.T, store Store) {
+	previousDict, previousLocks := dict, dictLocks
+	t.Cleanup(func() { dict, dictLocks = previousDict, previousLocks })
+	dict, dictLocks = store, NewTermLocks(lockStripes)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"tcp/utils"
+)
+
+// Máximo de termos sugeridos quando um termo não é encontrado
+const maxSuggestions = 3
+
+// suggestTerms retorna até maxSuggestions termos de terms parecidos com term,
+// do mais parecido ao menos, para as respostas 404. A comparação ignora
+// maiúsculas e acentos, como a busca (ver foldRune), e tolera mais erros em
+// termos maiores: nenhum até 2 letras, 1 até 4, 2 até 8 e 3 acima disso.
+// Assim "definicao" sugere "Definição", e "golnag" sugere "golang".
+func suggestTerms(terms []string, term string) []string {
+	target := foldText(term)
+	limit := 3
+	switch {
+	case len(target) <= 2:
+		limit = 0
+	case len(target) <= 4:
+		limit = 1
+	case len(target) <= 8:
+		limit = 2
+	}
+
+	type candidate struct {
+		term     string
+		distance int
+	}
+	var candidates []candidate
+	for _, t := range terms {
+		if t == term {
+			continue
+		}
+		if distance := editDistance(target, foldText(t), limit); distance <= limit {
+			candidates = append(candidates, candidate{term: t, distance: distance})
+		}
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		if c := cmp.Compare(a.distance, b.distance); c != 0 {
+			return c
+		}
+		return strings.Compare(a.term, b.term)
+	})
+
+	suggestions := make([]string, 0, min(len(candidates), maxSuggestions))
+	for _, c := range candidates[:min(len(candidates), maxSuggestions)] {
+		suggestions = append(suggestions, c.term)
+	}
+	return suggestions
+}
+
+// foldText passa o texto para minúsculas e sem acentos, letra a letra.
+func foldText(text string) []rune {
+	folded := make([]rune, 0, len(text))
+	for _, r := range text {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		folded = append(folded, foldRune(r))
+	}
+	return folded
+}
+
+// editDistance é a distância de Damerau-Levenshtein, na variante que não
+// edita duas vezes o mesmo trecho: o número de inserções, remoções, trocas e
+// transposições de letras vizinhas que levam a em b. Retorna limit+1 assim que
+// a distância passa de limit, sem terminar as contas.
+func editDistance(a, b []rune, limit int) int {
+	if abs(len(a)-len(b)) > limit {
+		return limit + 1
+	}
+	// Só três linhas da matriz são necessárias: a atual e as duas anteriores
+	before := make([]int, len(b)+1)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		best := current[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = min(current[j], before[j-2]+1)
+			}
+			best = min(best, current[j])
+		}
+		if best > limit {
+			return limit + 1
+		}
+		before, previous, current = previous, current, before
+	}
+	return previous[len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// notFound responde 404 com os termos parecidos com term no cabeçalho
+// Suggestions, para os clientes oferecerem consultá-los, e no fim da
+// mensagem, para quem só lê o corpo.
+func notFound(dict Store, term, message string) utils.HTTPResponse {
+	response := utils.HTTPResponse{
+		StatusCode: http.StatusNotFound,
+		Body:       message,
+	}
+	if suggestions := suggestTerms(dict.List(), term); len(suggestions) > 0 {
+		response.Body += fmt.Sprintf(". Did you mean: %s?", strings.Join(suggestions, ", "))
+		response.SetHeader(utils.HeaderSuggestions, utils.FormatSuggestions(suggestions))
+	}
+	return response
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"golang", "golang", 3, 0},
+		{"kitten", "sitting", 3, 3},
+		{"", "abc", 3, 3},
+		{"abcd", "acbd", 3, 1}, // Transposição
+		{"lsit", "list", 1, 1},
+		{"golnag", "goland", 3, 2},
+		// Na variante sem edições repetidas, "ca" -> "ac" -> "abc" não vale
+		{"ca", "abc", 3, 3},
+		// Passou do limite: limit+1
+		{"abc", "xyz", 1, 2},
+		{"a", "abcd", 1, 2},
+		{"kitten", "sitting", 2, 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance([]rune(tt.a), []rune(tt.b), tt.limit); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
+
+// As sugestões vêm da menor distância à maior, com empates em ordem
+// alfabética, sem considerar maiúsculas e acentos e sem o próprio termo.
+func TestSuggestTerms(t *testing.T) {
+	terms := []string{"golang", "goland", "gopher", "Go", "Definição", "definir", "list", "bat", "cat", "hat", "rat", "cart"}
+	tests := []struct {
+		term string
+		want []string
+	}{
+		// A transposição custa 1, então golang vem antes de goland, que é
+		// anterior na ordem alfabética
+		{"golnag", []string{"golang", "goland"}},
+		{"definicao", []string{"Definição", "definir"}},
+		{"DEFINIÇÃO", []string{"Definição", "definir"}},
+		// Até 4 letras, só um erro, que a transposição não ultrapassa
+		{"lsit", []string{"list"}},
+		// Até 2 letras, só a mesma palavra com outras maiúsculas ou acentos
+		{"go", []string{"Go"}},
+		{"gp", []string{}},
+		// No máximo maxSuggestions, os primeiros em ordem alfabética
+		{"xat", []string{"bat", "cat", "hat"}},
+		{"catr", []string{"cart", "cat"}},
+		{"cat", []string{"bat", "cart", "hat"}},
+		{"zzzzzz", []string{}},
+	}
+	for _, tt := range tests {
+		if got := suggestTerms(terms, tt.term); !slices.Equal(got, tt.want) {
+			t.Errorf("suggestTerms(%q) = %v, want %v", tt.term, got, tt.want)
+		}
+	}
+}
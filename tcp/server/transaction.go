@@ -0,0 +1,394 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tcp/utils"
+
+	"go.uber.org/zap"
+)
+
+// Transações por conexão. BEGIN abre uma transação; a partir daí INSERT,
+// UPDATE, CAS e DELETE são conferidos e guardados, sem alterar o dicionário, e
+// LOOKUP e LIST veem o dicionário com essas alterações. COMMIT aplica todas de
+// uma vez com Store.Apply, e ROLLBACK as descarta, assim como o fim da conexão.
+//
+// O isolamento é otimista: a transação guarda a versão de cada termo que leu
+// ou alterou, e o COMMIT, com os locks de escrita de todos esses termos,
+// confere que nenhuma mudou. Se outra conexão alterou algum deles nesse meio
+// tempo, a transação inteira é descartada com 409. Assim nenhum lock fica
+// preso entre uma requisição e outra, e um cliente que some no meio da
+// transação não bloqueia ninguém.
+
+// txTerm é um termo na visão da transação.
+type txTerm struct {
+	version    uint64 // Versão lida do dicionário, 0 se o termo não existia
+	existed    bool   // Se o termo existia quando foi lido
+	exists     bool   // Se o termo existe depois das alterações da transação
+	definition string
+	changed    bool
+}
+
+type transaction struct {
+	started time.Time
+	terms   map[string]*txTerm
+	order   []string // Termos lidos ou alterados, na ordem em que a transação os viu
+}
+
+// changes retorna as alterações a aplicar no COMMIT. Um termo inserido e
+// removido na mesma transação não gera alteração, mas continua sendo
+// conferido.
+func (tx *transaction) changes() []Change {
+	var changes []Change
+	for _, term := range tx.order {
+		t := tx.terms[term]
+		if !t.changed || (!t.existed && !t.exists) {
+			continue
+		}
+		changes = append(changes, Change{Term: term, Definition: t.definition, Delete: !t.exists})
+	}
+	return changes
+}
+
+// txSession guarda a transação aberta de uma conexão. É usada só pela
+// goroutine que atende as requisições da conexão (ver serveRequests).
+type txSession struct {
+	tx *transaction
+}
+
+// process trata BEGIN, COMMIT e ROLLBACK e, com uma transação aberta, os
+// comandos sobre o dicionário. Sem transação, os outros comandos seguem para
+// ProcessDictCommand.
+func (s *txSession) process(ctx context.Context, request *utils.HTTPRequest) utils.HTTPResponse {
+	switch request.Method {
+	case "BEGIN", "COMMIT", "ROLLBACK":
+	case "SEARCH", "EXPORT":
+		// A busca e a exportação veem só o dicionário confirmado, sem as
+		// alterações da transação
+		return ProcessDictCommand(ctx, request, dict, dictLocks)
+	default:
+		if s.tx == nil {
+			return ProcessDictCommand(ctx, request, dict, dictLocks)
+		}
+	}
+
+	startTime := time.Now()
+	var response utils.HTTPResponse
+	defer func() {
+		logger.Info("Processed transaction command",
+			zap.String("method", request.Method),
+			zap.String("path", request.Path),
+			zap.Int("status_code", response.StatusCode),
+			zap.Bool("in_transaction", s.tx != nil),
+			zap.Int64("elapsed_time", time.Since(startTime).Nanoseconds()))
+	}()
+
+	ctx, cancel, err := withClientTimeout(ctx, request.Header.Get(utils.HeaderTimeout))
+	if err != nil {
+		response = utils.HTTPResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       err.Error(),
+		}
+		return response
+	}
+	defer cancel()
+
+	switch request.Method {
+	case "BEGIN":
+		if s.tx != nil {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       "Transaction already in progress; COMMIT or ROLLBACK it first",
+			}
+			return response
+		}
+		s.tx = &transaction{started: time.Now(), terms: make(map[string]*txTerm)}
+		response = utils.HTTPResponse{
+			StatusCode: http.StatusOK,
+			Body:       "Transaction started",
+		}
+		return response
+
+	case "ROLLBACK":
+		if s.tx == nil {
+			response = noTransaction()
+			return response
+		}
+		discarded := len(s.tx.changes())
+		s.tx = nil
+		response = utils.HTTPResponse{
+			StatusCode: http.StatusOK,
+			Body:       fmt.Sprintf("Transaction rolled back: %d changes discarded", discarded),
+		}
+		return response
+
+	case "COMMIT":
+		if s.tx == nil {
+			response = noTransaction()
+			return response
+		}
+		response = s.commit(ctx, startTime)
+		return response
+
+	default:
+		response = s.tx.process(ctx, request, startTime)
+		return response
+	}
+}
+
+// rollback descarta a transação aberta quando a conexão termina.
+func (s *txSession) rollback(remoteAddr string) {
+	if s.tx == nil {
+		return
+	}
+	logger.Info("Transaction rolled back: connection closed",
+		zap.String("remote_addr", remoteAddr),
+		zap.Int("changes", len(s.tx.changes())),
+		zap.Duration("age", time.Since(s.tx.started)))
+	s.tx = nil
+}
+
+// commit trava todos os termos da transação, confere que nenhum mudou desde
+// que foi lido e aplica as alterações. Se não conseguir os locks a transação
+// continua aberta, e o cliente pode repetir o COMMIT ou desistir com ROLLBACK;
+// nos outros casos ela termina.
+func (s *txSession) commit(ctx context.Context, startTime time.Time) utils.HTTPResponse {
+	tx := s.tx
+	locks := dictLocks.ForTerms(tx.order)
+	for i, lock := range locks {
+		if err := lock.Lock(ctx); err != nil {
+			for _, held := range locks[:i] {
+				held.Unlock()
+			}
+			return lockFailure(ctx, tx.termFor(lock), time.Since(startTime))
+		}
+	}
+	defer func() {
+		for _, lock := range locks {
+			lock.Unlock()
+		}
+	}()
+	s.tx = nil
+
+	for _, term := range tx.order {
+		_, version, _, err := dict.LookUp(term)
+		if err != nil {
+			return utils.HTTPResponse{
+				StatusCode: http.StatusInternalServerError,
+				Body:       "Error reading dictionary: " + err.Error(),
+			}
+		}
+		if version != tx.terms[term].version {
+			return utils.HTTPResponse{
+				StatusCode: http.StatusConflict,
+				Body:       fmt.Sprintf("Transaction aborted: term '%s' was modified by another client", term),
+			}
+		}
+	}
+
+	changes := tx.changes()
+	if len(changes) == 0 {
+		return utils.HTTPResponse{
+			StatusCode: http.StatusOK,
+			Body:       "Transaction committed: no changes",
+		}
+	}
+	version, err := dict.Apply(changes)
+	if err != nil {
+		return utils.HTTPResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       "Error saving dictionary: " + err.Error(),
+		}
+	}
+	response := utils.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Body:       fmt.Sprintf("Transaction committed: %d changes", len(changes)),
+	}
+	response.SetHeader(utils.HeaderVersion, fmt.Sprint(version))
+	return response
+}
+
+// termFor retorna o primeiro termo da transação que usa o lock, para a
+// mensagem de erro quando o COMMIT desiste de esperar por ele.
+func (tx *transaction) termFor(lock *TermLock) string {
+	for _, term := range tx.order {
+		if dictLocks.For(term) == lock {
+			return term
+		}
+	}
+	return ""
+}
+
+// read retorna o termo na visão da transação, lendo-o do dicionário na
+// primeira vez.
+func (tx *transaction) read(ctx context.Context, term string) (*txTerm, error) {
+	if t, ok := tx.terms[term]; ok {
+		return t, nil
+	}
+	lock := dictLocks.For(term)
+	if err := lock.RLock(ctx); err != nil {
+		return nil, err
+	}
+	definition, version, exists, err := dict.LookUp(term)
+	lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	t := &txTerm{version: version, existed: exists, exists: exists, definition: definition}
+	tx.terms[term] = t
+	tx.order = append(tx.order, term)
+	return t, nil
+}
+
+// process executa um comando dentro da transação. As alterações respondem
+// 202, porque só são aplicadas no COMMIT.
+func (tx *transaction) process(ctx context.Context, request *utils.HTTPRequest, startTime time.Time) utils.HTTPResponse {
+	command := request.Method
+	term := request.Path
+
+	if command == "LIST" {
+		query, err := listQueryFromRequest(request)
+		if err != nil {
+			return utils.HTTPResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       err.Error(),
+			}
+		}
+		// A lista não é conferida no COMMIT: só os termos lidos ou alterados.
+		// Os termos inseridos pela transação vão para o fim, com sequências
+		// depois das do dicionário
+		entries := dict.ListEntries()
+		list := make([]ListEntry, 0, len(entries))
+		var seq uint64
+		for _, entry := range entries {
+			seq = max(seq, entry.Seq)
+			if t, ok := tx.terms[entry.Term]; !ok || t.exists {
+				list = append(list, entry)
+			}
+		}
+		for _, term := range tx.order {
+			if t := tx.terms[term]; t.exists && !t.existed {
+				seq++
+				list = append(list, ListEntry{Term: term, Seq: seq})
+			}
+		}
+		page, err := pageTerms(list, query)
+		if err != nil {
+			return utils.HTTPResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       err.Error(),
+			}
+		}
+		return listResponse(page)
+	}
+
+	switch command {
+	case "LOOKUP", "INSERT", "UPDATE", "CAS", "DELETE":
+	case "IMPORT":
+		return utils.HTTPResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       "IMPORT is not available inside a transaction; COMMIT or ROLLBACK first",
+		}
+	default:
+		return utils.HTTPResponse{
+			StatusCode: http.StatusNotImplemented,
+			Body:       fmt.Sprintf("Unknown command '%s'. Try one of: LIST, LOOKUP, SEARCH, INSERT, UPDATE, CAS, DELETE, EXPORT, COMMIT, ROLLBACK", command),
+		}
+	}
+	if (command == "INSERT" || command == "UPDATE" || command == "CAS") && request.Body == "" {
+		return utils.HTTPResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       command + " command requires a body (definition)",
+		}
+	}
+
+	// Dentro da transação, If-Match é conferido com a versão que o termo tinha
+	// quando a transação o leu
+	var expected uint64
+	if ifMatch := request.Header.Get(utils.HeaderIfMatch); ifMatch != "" && (command == "UPDATE" || command == "CAS") {
+		var err error
+		if expected, err = utils.ParseVersion(ifMatch); err != nil {
+			return utils.HTTPResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       err.Error(),
+			}
+		}
+	} else if command == "CAS" {
+		return utils.HTTPResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       "CAS command requires the expected version in the If-Match header",
+		}
+	}
+
+	t, err := tx.read(ctx, term)
+	if err != nil {
+		if ctx.Err() != nil {
+			return lockFailure(ctx, term, time.Since(startTime))
+		}
+		return utils.HTTPResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       "Error reading dictionary: " + err.Error(),
+		}
+	}
+
+	switch command {
+	case "LOOKUP":
+		if !t.exists {
+			return notFound(dict, term, fmt.Sprintf("Term '%s' not found", term))
+		}
+		response := utils.HTTPResponse{
+			StatusCode: http.StatusOK,
+			Body:       t.definition,
+		}
+		if !t.changed {
+			response.SetHeader(utils.HeaderVersion, fmt.Sprint(t.version))
+		}
+		return response
+
+	case "INSERT":
+		if t.exists {
+			return utils.HTTPResponse{
+				StatusCode: http.StatusConflict,
+				Body:       fmt.Sprintf("Term '%s' already exists", term),
+			}
+		}
+
+	default:
+		if !t.exists {
+			return notFound(dict, term, fmt.Sprintf("Term '%s' does not exist", term))
+		}
+		if expected != 0 && expected != t.version {
+			status := http.StatusPreconditionFailed
+			if command == "CAS" {
+				status = http.StatusConflict
+			}
+			response := utils.HTTPResponse{
+				StatusCode: status,
+				Body:       fmt.Sprintf("Term '%s' was modified: expected version %d, current version is %d", term, expected, t.version),
+			}
+			response.SetHeader(utils.HeaderVersion, fmt.Sprint(t.version))
+			return response
+		}
+	}
+
+	t.changed = true
+	t.exists = command != "DELETE"
+	t.definition = request.Body
+	if command == "DELETE" {
+		t.definition = ""
+	}
+	return utils.HTTPResponse{
+		StatusCode: http.StatusAccepted,
+		Body:       fmt.Sprintf("%s of term '%s' staged; %d changes pending until COMMIT", command, term, len(tx.changes())),
+	}
+}
+
+func noTransaction() utils.HTTPResponse {
+	return utils.HTTPResponse{
+		StatusCode: http.StatusBadRequest,
+		Body:       "No transaction in progress; send BEGIN first",
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+
+	"tcp/utils"
+)
+
+// Se outro cliente altera um termo que a transação leu ou alterou, o COMMIT
+// responde 409, não aplica nada e encerra a transação. Alterações em outros
+// termos não interferem.
+func TestTransactionConflict(t *testing.T) {
+	silenceLogger(t)
+	tests := []struct {
+		name   string
+		tx     []utils.HTTPRequest // Depois do BEGIN
+		other  []utils.HTTPRequest // Do outro cliente, antes do COMMIT
+		status int
+		want   []string
+	}{
+		{
+			"term read and changed",
+			[]utils.HTTPRequest{{Method: "LOOKUP", Path: "go"}, {Method: "INSERT", Path: "zig", Body: "nova"}},
+			[]utils.HTTPRequest{{Method: "UPDATE", Path: "go", Body: "de outro cliente"}},
+			http.StatusConflict,
+			[]string{`go=de outro cliente@"3"`, `rust=sistemas@"2"`},
+		},
+		{
+			"staged term changed",
+			[]utils.HTTPRequest{{Method: "UPDATE", Path: "rust", Body: "da transação"}, {Method: "DELETE", Path: "go"}},
+			[]utils.HTTPRequest{{Method: "UPDATE", Path: "rust", Body: "de outro cliente"}},
+			http.StatusConflict,
+			[]string{`go=linguagem@"1"`, `rust=de outro cliente@"3"`},
+		},
+		{
+			"absent term inserted",
+			[]utils.HTTPRequest{{Method: "INSERT", Path: "zig", Body: "da transação"}, {Method: "UPDATE", Path: "go", Body: "da transação"}},
+			[]utils.HTTPRequest{{Method: "INSERT", Path: "zig", Body: "de outro cliente"}},
+			http.StatusConflict,
+			[]string{`go=linguagem@"1"`, `rust=sistemas@"2"`, `zig=de outro cliente@"3"`},
+		},
+		{
+			"term deleted and reinserted",
+			[]utils.HTTPRequest{{Method: "LOOKUP", Path: "go"}, {Method: "UPDATE", Path: "rust", Body: "da transação"}},
+			[]utils.HTTPRequest{{Method: "DELETE", Path: "go"}, {Method: "INSERT", Path: "go", Body: "linguagem"}},
+			http.StatusConflict,
+			[]string{`rust=sistemas@"2"`, `go=linguagem@"4"`},
+		},
+		{
+			"inserted and deleted inside the transaction",
+			[]utils.HTTPRequest{{Method: "INSERT", Path: "zig", Body: "temporária"}, {Method: "DELETE", Path: "zig"}},
+			[]utils.HTTPRequest{{Method: "INSERT", Path: "zig", Body: "de outro cliente"}},
+			http.StatusConflict,
+			[]string{`go=linguagem@"1"`, `rust=sistemas@"2"`, `zig=de outro cliente@"3"`},
+		},
+		{
+			"unrelated term changed",
+			[]utils.HTTPRequest{{Method: "UPDATE", Path: "go", Body: "da transação"}},
+			[]utils.HTTPRequest{{Method: "UPDATE", Path: "rust", Body: "de outro cliente"}},
+			http.StatusOK,
+			[]string{`go=da transação@"4"`, `rust=de outro cliente@"3"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewDictionary()
+			store.Insert("go", "linguagem")
+			store.Insert("rust", "sistemas")
+			useStore(t, store)
+			ctx := context.Background()
+
+			session := &txSession{}
+			for _, request := range append([]utils.HTTPRequest{{Method: "BEGIN"}}, tt.tx...) {
+				if response := session.process(ctx, &request); response.StatusCode >= 300 {
+					t.Fatalf("%s %s = %d %q", request.Method, request.Path, response.StatusCode, response.Body)
+				}
+			}
+			for _, request := range tt.other {
+				if response := ProcessDictCommand(ctx, &request, dict, dictLocks); response.StatusCode >= 300 {
+					t.Fatalf("other client %s %s = %d %q", request.Method, request.Path, response.StatusCode, response.Body)
+				}
+			}
+
+			response := session.process(ctx, &utils.HTTPRequest{Method: "COMMIT"})
+			if response.StatusCode != tt.status {
+				t.Fatalf("COMMIT = %d %q, want %d", response.StatusCode, response.Body, tt.status)
+			}
+			if got := storeContents(t, store); !slices.Equal(got, tt.want) {
+				t.Errorf("contents after COMMIT = %v, want %v", got, tt.want)
+			}
+			if session.tx != nil {
+				t.Errorf("transaction still open after COMMIT")
+			}
+		})
+	}
+}
+
+// Um lote de Apply cujo registro foi cortado por uma queda some inteiro na
+// reabertura, sem deixar parte das alterações.
+func TestApplyTornBatch(t *testing.T) {
+	silenceLogger(t)
+	for _, kind := range persistentKinds {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			store := openTestStore(t, kind, dir)
+			store.Insert("go", "linguagem")
+			store.Insert("rust", "sistemas")
+			want := storeContents(t, store)
+			_, err := store.Apply([]Change{
+				{Term: "go", Definition: "alterada no lote"},
+				{Term: "rust", Delete: true},
+				{Term: "zig", Definition: "inserida no lote"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+
+			// Só o último byte do lote fica faltando
+			path := dataFile(kind, dir)
+			if err := os.Truncate(path, fileSize(t, path)-1); err != nil {
+				t.Fatal(err)
+			}
+			store = openTestStore(t, kind, dir)
+			defer store.Close()
+			if got := storeContents(t, store); !slices.Equal(got, want) {
+				t.Errorf("after reopening: %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// Quem consulta durante um Apply vê o lote inteiro ou nada dele.
+func TestApplyAtomicForReaders(t *testing.T) {
+	silenceLogger(t)
+	for _, kind := range []string{StoreMemory, StoreFile, StoreKV} {
+		t.Run(kind, func(t *testing.T) {
+			dir := ""
+			if kind != StoreMemory {
+				dir = t.TempDir()
+			}
+			store := openTestStore(t, kind, dir)
+			defer store.Close()
+
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					terms := store.List()
+					if hasA, hasB := slices.Contains(terms, "a"), slices.Contains(terms, "b"); hasA != hasB {
+						t.Errorf("List = %v, half of a batch", terms)
+						return
+					}
+				}
+			}()
+			for i := range 100 {
+				changes := []Change{{Term: "a", Definition: "1"}, {Term: "b", Definition: "2"}}
+				if i%2 == 1 {
+					changes = []Change{{Term: "a", Delete: true}, {Term: "b", Delete: true}}
+				}
+				if _, err := store.Apply(changes); err != nil {
+					t.Error(err)
+					break
+				}
+			}
+			close(done)
+			wg.Wait()
+		})
+	}
+}
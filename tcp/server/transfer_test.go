@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"tcp/utils"
+)
+
+// A importação segue a política de conflito com termos que já existem e com
+// termos repetidos no arquivo. Com overwrite, um termo repetido conta uma vez
+// só e fica com a definição da última linha.
+func TestImportConflictPolicies(t *testing.T) {
+	silenceLogger(t)
+	const file = "termo,definicao\n" +
+		"go,do arquivo\n" +
+		"zig,primeira\n" +
+		"zig,segunda\n" +
+		"odin,nova\n"
+	tests := []struct {
+		conflict string
+		report   ImportReport
+		err      error
+		want     []string
+	}{
+		{
+			ConflictSkip,
+			ImportReport{Inserted: 2, Skipped: 2, Version: 3},
+			nil,
+			[]string{`go=linguagem@"1"`, `rust=sistemas@"2"`, `zig=primeira@"3"`, `odin=nova@"3"`},
+		},
+		{
+			ConflictOverwrite,
+			ImportReport{Inserted: 2, Overwritten: 1, Version: 3},
+			nil,
+			[]string{`go=do arquivo@"3"`, `rust=sistemas@"2"`, `zig=segunda@"3"`, `odin=nova@"3"`},
+		},
+		{
+			ConflictFail,
+			ImportReport{Errors: []RowError{
+				{Row: 2, Term: "go", Error: "term already exists"},
+				{Row: 4, Term: "zig", Error: "term repeats row 3"},
+			}},
+			errImportConflict,
+			[]string{`go=linguagem@"1"`, `rust=sistemas@"2"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.conflict, func(t *testing.T) {
+			store := NewDictionary()
+			store.Insert("go", "linguagem")
+			store.Insert("rust", "sistemas")
+
+			report, err := ImportTerms(context.Background(), strings.NewReader(file), store, NewTermLocks(lockStripes), FormatCSV, tt.conflict)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ImportTerms error = %v, want %v", err, tt.err)
+			}
+			checkReport(t, report, tt.report)
+			if got := storeContents(t, store); !slices.Equal(got, tt.want) {
+				t.Errorf("contents = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// As linhas inválidas vão para o relatório com sua posição no arquivo, e as
+// válidas são importadas.
+func TestImportRowErrors(t *testing.T) {
+	silenceLogger(t)
+	tests := []struct {
+		name   string
+		format string
+		file   string
+		errors []RowError
+		want   []string
+	}{
+		{
+			"csv",
+			FormatCSV,
+			"termo,definicao\n" +
+				"go,linguagem\n" +
+				" ,sem termo\n" +
+				"rust,\n" +
+				"zig,a,b\n" +
+				"odin,nova\n",
+			[]RowError{
+				{Row: 3, Error: "missing term"},
+				{Row: 4, Term: "rust", Error: "missing definition"},
+				{Row: 5, Term: "zig", Error: "expected 2 columns (term, definition), got 3"},
+			},
+			[]string{`go=linguagem@"1"`, `odin=nova@"1"`},
+		},
+		{
+			"jsonl",
+			FormatJSONL,
+			`{"termo": "go", "definicao": "linguagem"}` + "\n" +
+				`{"termo": "rust"` + "\n" +
+				"\n" +
+				`{"definicao": "sem termo"}` + "\n" +
+				`{"termo": "odin", "definicao": "nova"}`,
+			[]RowError{
+				{Row: 2, Error: "invalid JSON: unexpected end of JSON input"},
+				{Row: 4, Error: "missing term"},
+			},
+			[]string{`go=linguagem@"1"`, `odin=nova@"1"`},
+		},
+		{
+			"json",
+			FormatJSON,
+			`[{"termo": "go", "definicao": "linguagem"}, "texto", {"termo": "rust", "definicao": " "}, {"termo": "odin", "definicao": "nova"}]`,
+			[]RowError{
+				{Row: 2, Error: "expected an object with termo and definicao, got string"},
+				{Row: 3, Term: "rust", Error: "missing definition"},
+			},
+			[]string{`go=linguagem@"1"`, `odin=nova@"1"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewDictionary()
+			report, err := ImportTerms(context.Background(), strings.NewReader(tt.file), store, NewTermLocks(lockStripes), tt.format, ConflictSkip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkReport(t, report, ImportReport{Inserted: 2, Errors: tt.errors, Version: 1})
+			if got := storeContents(t, store); !slices.Equal(got, tt.want) {
+				t.Errorf("contents = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Um arquivo que não pode ser lido até o fim não importa nada.
+func TestImportMalformed(t *testing.T) {
+	silenceLogger(t)
+	for format, file := range map[string]string{
+		FormatJSON: `{"termo": "go", "definicao": "linguagem"}`,
+		FormatCSV:  "go,\"linguagem\n",
+	} {
+		t.Run(format, func(t *testing.T) {
+			store := NewDictionary()
+			_, err := ImportTerms(context.Background(), strings.NewReader(file), store, NewTermLocks(lockStripes), format, ConflictSkip)
+			if !errors.Is(err, errMalformedImport) {
+				t.Errorf("ImportTerms error = %v, want %v", err, errMalformedImport)
+			}
+			if terms := store.List(); len(terms) > 0 {
+				t.Errorf("imported %v", terms)
+			}
+		})
+	}
+}
+
+func checkReport(t *testing.T, got, want ImportReport) {
+	t.Helper()
+	if got.Inserted != want.Inserted || got.Overwritten != want.Overwritten || got.Skipped != want.Skipped || got.Version != want.Version {
+		t.Errorf("report = %+v, want %+v", got, want)
+	}
+	if !slices.Equal(got.Errors, want.Errors) {
+		t.Errorf("report errors = %+v, want %+v", got.Errors, want.Errors)
+	}
+}
+
+// A exportação chega à resposta por um arquivo temporário, com o mesmo
+// conteúdo de ExportTerms, e o arquivo é removido quando o corpo é enviado.
+// O que é exportado volta igual pela importação.
+func TestExportResponse(t *testing.T) {
+	silenceLogger(t)
+	store := NewDictionary()
+	store.Insert("go", "linguagem, compilada")
+	store.Insert("ação", `com "aspas"`)
+	for _, format := range []string{FormatJSON, FormatCSV, FormatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			response := exportResponse(store, format)
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d %q", response.StatusCode, response.Body)
+			}
+			if total := response.Header.Get(utils.HeaderTotal); total != "2" {
+				t.Errorf("Total = %q, want 2", total)
+			}
+			file, ok := response.BodyReader.(exportFile)
+			if !ok {
+				t.Fatalf("BodyReader is %T, want exportFile", response.BodyReader)
+			}
+			var body strings.Builder
+			if err := response.WriteBody(&body); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(file.Name()); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("export file still exists after the body was written: %v", err)
+			}
+
+			var want strings.Builder
+			if _, err := ExportTerms(&want, store, format); err != nil {
+				t.Fatal(err)
+			}
+			if body.String() != want.String() || response.BodySize != body.Len() {
+				t.Errorf("body (%d bytes, BodySize %d) = %q, want %q", body.Len(), response.BodySize, body.String(), want.String())
+			}
+
+			imported := NewDictionary()
+			if _, err := ImportTerms(context.Background(), strings.NewReader(body.String()), imported, NewTermLocks(lockStripes), format, ConflictFail); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := storeContents(t, imported), []string{`go=linguagem, compilada@"1"`, `ação=com "aspas"@"1"`}; !slices.Equal(got, want) {
+				t.Errorf("imported back = %v, want %v", got, want)
+			}
+		})
+	}
+}
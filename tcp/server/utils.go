@@ -1,10 +1,11 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
-	"sync"
 	"tcp/utils"
 	"time"
 
@@ -33,7 +34,22 @@ func ToUppercase(data string) string {
 	termo simultaneamente, assegurando a integridade transacional dos dados.
 */
 
-func ProcessDictCommand(request *utils.HTTPRequest, dict *Dictionary, mux *sync.Mutex) utils.HTTPResponse {
+// ProcessDictCommand executa o comando sobre o dicionário. A espera pelo lock
+// do termo termina com o prazo ou o cancelamento de ctx e, se o cliente
+// informou o cabeçalho Timeout, também com esse prazo.
+//
+// As respostas de LOOKUP, INSERT e UPDATE trazem a versão do termo no
+// cabeçalho Version. Um UPDATE com If-Match só é aplicado se o termo ainda
+// estiver nessa versão; senão responde 412 com a versão atual. CAS é o mesmo
+// UPDATE condicional, com If-Match obrigatório e 409 quando a versão mudou.
+//
+// SEARCH <busca> responde até DefaultSearchLimit termos, um por linha com um
+// trecho da definição, e o total encontrado no cabeçalho Total.
+//
+// EXPORT [formato] responde o dicionário inteiro, e IMPORT [formato] grava os
+// termos do corpo com a política de conflito do cabeçalho Conflict (ver
+// transfer.go).
+func ProcessDictCommand(ctx context.Context, request *utils.HTTPRequest, dict Store, locks *TermLocks) utils.HTTPResponse {
 	startTime := time.Now()
 	var response utils.HTTPResponse
 
@@ -44,138 +60,301 @@ func ProcessDictCommand(request *utils.HTTPRequest, dict *Dictionary, mux *sync.
 			zap.String("path", request.Path),
 			zap.Int("status_code", response.StatusCode),
 			zap.Int64("elapsed_time", elapsed.Nanoseconds()))
-		logger.Info("Response", zap.Int("status_code", response.StatusCode), zap.String("message", response.Message))
+		logger.Info("Response", zap.Int("status_code", response.StatusCode), zap.String("body", response.Body))
 		time.Sleep(5 * time.Nanosecond)
 	}()
 
 	command := request.Method
 	term := request.Path
 
+	ctx, cancel, err := withClientTimeout(ctx, request.Header.Get(utils.HeaderTimeout))
+	if err != nil {
+		response = utils.HTTPResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       err.Error(),
+		}
+		return response
+	}
+	defer cancel()
+
 	switch command {
 	case "LIST":
-		for !mux.TryLock() {
-			if time.Since(startTime) > 30*time.Second {
-				response = utils.HTTPResponse{
-					StatusCode: http.StatusRequestTimeout,
-					Message:    "Timeout while trying to access dictionary",
-				}
-				return response
+		query, err := listQueryFromRequest(request)
+		if err != nil {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       err.Error(),
 			}
+			return response
 		}
-		terms := dict.List()
-		mux.Unlock()
-		keys := "[" + strings.Join(terms, ", ") + "]"
+		// Não precisa do lock de nenhum termo: o Store devolve um estado
+		// consistente mesmo com alterações em andamento
+		page, err := pageTerms(dict.ListEntries(), query)
+		if err != nil {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       err.Error(),
+			}
+			return response
+		}
+		response = listResponse(page)
+		return response
 
+	case "SEARCH":
+		// Como LIST, não usa os locks dos termos. A busca vem no caminho
+		if strings.TrimSpace(term) == "" {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       "SEARCH command requires a query",
+			}
+			return response
+		}
+		results, total, err := dict.Search(term, DefaultSearchLimit)
+		if err != nil {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusInternalServerError,
+				Body:       "Error reading dictionary: " + err.Error(),
+			}
+			return response
+		}
+
+		// Um resultado por linha, do mais relevante ao menos
+		lines := make([]string, len(results))
+		for i, result := range results {
+			lines[i] = fmt.Sprintf("%s: %s", result.Term, result.Snippet)
+		}
+		if total == 0 {
+			lines = []string{fmt.Sprintf("No terms match '%s'", term)}
+		}
 		response = utils.HTTPResponse{
 			StatusCode: http.StatusOK,
-			Message:    keys,
+			Body:       strings.Join(lines, "\n"),
 		}
+		response.SetHeader(utils.HeaderTotal, fmt.Sprint(total))
+		return response
+
+	case "EXPORT":
+		// Como LIST, não usa os locks dos termos
+		response = exportResponse(dict, term)
+		return response
+
+	case "IMPORT":
+		response = importResponse(ctx, request, dict, locks, startTime)
 		return response
 
 	case "LOOKUP":
-		for !mux.TryLock() {
-			if time.Since(startTime) > 30*time.Second {
-				response = utils.HTTPResponse{
-					StatusCode: http.StatusRequestTimeout,
-					Message:    "Timeout while trying to access dictionary",
-				}
-				return response
-			}
+		lock := locks.For(term)
+		if err := lock.RLock(ctx); err != nil {
+			response = lockFailure(ctx, term, time.Since(startTime))
+			return response
 		}
-		definition, exists := dict.LookUp(term)
-		mux.Unlock()
+		definition, version, exists, err := dict.LookUp(term)
+		lock.RUnlock()
 
-		if !exists {
+		if err != nil {
 			response = utils.HTTPResponse{
-				StatusCode: http.StatusNotFound,
-				Message:    fmt.Sprintf("Term '%s' not found", term),
+				StatusCode: http.StatusInternalServerError,
+				Body:       "Error reading dictionary: " + err.Error(),
 			}
 			return response
 		}
 
+		if !exists {
+			response = notFound(dict, term, fmt.Sprintf("Term '%s' not found", term))
+			return response
+		}
+
 		response = utils.HTTPResponse{
 			StatusCode: http.StatusOK,
-			Message:    definition,
+			Body:       definition,
 		}
+		response.SetHeader(utils.HeaderVersion, fmt.Sprint(version))
 		return response
 
 	case "INSERT":
 		if request.Body == "" {
 			response = utils.HTTPResponse{
 				StatusCode: http.StatusBadRequest,
-				Message:    "INSERT command requires a body (definition)",
+				Body:       "INSERT command requires a body (definition)",
 			}
 			return response
 		}
 
-		for !mux.TryLock() {
-			if time.Since(startTime) > 30*time.Second {
-				response = utils.HTTPResponse{
-					StatusCode: http.StatusRequestTimeout,
-					Message:    "Timeout while trying to access dictionary",
-				}
-				return response
-			}
+		lock := locks.For(term)
+		if err := lock.Lock(ctx); err != nil {
+			response = lockFailure(ctx, term, time.Since(startTime))
+			return response
 		}
-		defer mux.Unlock()
+		defer lock.Unlock()
 
-		success := dict.Insert(term, request.Body)
+		version, success, err := dict.Insert(term, request.Body)
+		if err != nil {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusInternalServerError,
+				Body:       "Error saving dictionary: " + err.Error(),
+			}
+			return response
+		}
 
 		if !success {
 			response = utils.HTTPResponse{
 				StatusCode: http.StatusConflict,
-				Message:    fmt.Sprintf("Term '%s' already exists", term),
+				Body:       fmt.Sprintf("Term '%s' already exists", term),
 			}
 			return response
 		}
 
 		response = utils.HTTPResponse{
 			StatusCode: http.StatusCreated,
-			Message:    fmt.Sprintf("Term '%s' inserted successfully", term),
+			Body:       fmt.Sprintf("Term '%s' inserted successfully", term),
 		}
+		response.SetHeader(utils.HeaderVersion, fmt.Sprint(version))
 		return response
 
-	case "UPDATE":
+	case "UPDATE", "CAS":
 		if request.Body == "" {
 			response = utils.HTTPResponse{
 				StatusCode: http.StatusBadRequest,
-				Message:    "UPDATE command requires a body (new definition)",
+				Body:       command + " command requires a body (new definition)",
+			}
+			return response
+		}
+
+		// Sem If-Match, o UPDATE sobrescreve qualquer versão
+		var expected uint64
+		if ifMatch := request.Header.Get(utils.HeaderIfMatch); ifMatch != "" {
+			if expected, err = utils.ParseVersion(ifMatch); err != nil {
+				response = utils.HTTPResponse{
+					StatusCode: http.StatusBadRequest,
+					Body:       err.Error(),
+				}
+				return response
+			}
+		} else if command == "CAS" {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       "CAS command requires the expected version in the If-Match header",
 			}
 			return response
 		}
 
-		for !mux.TryLock() {
-			if time.Since(startTime) > 30*time.Second {
+		lock := locks.For(term)
+		if err := lock.Lock(ctx); err != nil {
+			response = lockFailure(ctx, term, time.Since(startTime))
+			return response
+		}
+		defer lock.Unlock()
+
+		// Com o lock do termo, a versão não muda entre a conferência e o Update
+		if expected != 0 {
+			_, current, exists, err := dict.LookUp(term)
+			if err != nil {
+				response = utils.HTTPResponse{
+					StatusCode: http.StatusInternalServerError,
+					Body:       "Error reading dictionary: " + err.Error(),
+				}
+				return response
+			}
+			if exists && current != expected {
+				status := http.StatusPreconditionFailed
+				if command == "CAS" {
+					status = http.StatusConflict
+				}
 				response = utils.HTTPResponse{
-					StatusCode: http.StatusRequestTimeout,
-					Message:    "Timeout while trying to access dictionary",
+					StatusCode: status,
+					Body:       fmt.Sprintf("Term '%s' was modified: expected version %d, current version is %d", term, expected, current),
 				}
+				response.SetHeader(utils.HeaderVersion, fmt.Sprint(current))
 				return response
 			}
 		}
-		defer mux.Unlock()
 
-		success := dict.Update(term, request.Body)
+		version, success, err := dict.Update(term, request.Body)
+		if err != nil {
+			response = utils.HTTPResponse{
+				StatusCode: http.StatusInternalServerError,
+				Body:       "Error saving dictionary: " + err.Error(),
+			}
+			return response
+		}
 
 		if !success {
+			response = notFound(dict, term, fmt.Sprintf("Term '%s' does not exist", term))
+			return response
+		}
+
+		response = utils.HTTPResponse{
+			StatusCode: http.StatusOK,
+			Body:       fmt.Sprintf("Term '%s' updated successfully", term),
+		}
+		response.SetHeader(utils.HeaderVersion, fmt.Sprint(version))
+		return response
+
+	case "DELETE":
+		lock := locks.For(term)
+		if err := lock.Lock(ctx); err != nil {
+			response = lockFailure(ctx, term, time.Since(startTime))
+			return response
+		}
+		defer lock.Unlock()
+
+		success, err := dict.Delete(term)
+		if err != nil {
 			response = utils.HTTPResponse{
-				StatusCode: http.StatusNotFound,
-				Message:    fmt.Sprintf("Term '%s' does not exist", term),
+				StatusCode: http.StatusInternalServerError,
+				Body:       "Error saving dictionary: " + err.Error(),
 			}
 			return response
 		}
 
+		if !success {
+			response = notFound(dict, term, fmt.Sprintf("Term '%s' does not exist", term))
+			return response
+		}
+
 		response = utils.HTTPResponse{
 			StatusCode: http.StatusOK,
-			Message:    fmt.Sprintf("Term '%s' updated successfully", term),
+			Body:       fmt.Sprintf("Term '%s' deleted successfully", term),
 		}
 		return response
 
 	default:
 		response = utils.HTTPResponse{
 			StatusCode: http.StatusNotImplemented,
-			Message:    fmt.Sprintf("Unknown command '%s'. Try one of: LIST, LOOKUP, INSERT, UPDATE", command),
+			Body:       fmt.Sprintf("Unknown command '%s'. Try one of: LIST, LOOKUP, SEARCH, INSERT, UPDATE, CAS, DELETE, EXPORT, IMPORT", command),
 		}
 		return response
 	}
 }
+
+// withClientTimeout limita ctx ao prazo pedido pelo cliente, se houver. Um
+// prazo do servidor menor continua valendo.
+func withClientTimeout(ctx context.Context, value string) (context.Context, context.CancelFunc, error) {
+	if value == "" {
+		return ctx, func() {}, nil
+	}
+	timeout, err := utils.ParseTimeout(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, errClientTimeout)
+	return ctx, cancel, nil
+}
+
+// lockFailure é a resposta a uma requisição que desistiu de esperar pelo lock
+// do termo, com o tempo total desde o início da requisição.
+func lockFailure(ctx context.Context, term string, elapsed time.Duration) utils.HTTPResponse {
+	waited := elapsed.Round(time.Millisecond)
+	status := lockWaitStatus(ctx)
+	response := utils.HTTPResponse{StatusCode: status}
+	switch {
+	case status == http.StatusRequestTimeout:
+		response.Body = fmt.Sprintf("Client timeout expired after %s waiting for term '%s'", waited, term)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		response.Body = fmt.Sprintf("Dictionary busy: gave up after %s waiting for term '%s'", waited, term)
+		response.SetHeader("Retry-After", "1")
+	default:
+		response.Body = fmt.Sprintf("Request canceled after %s waiting for term '%s'", waited, term)
+	}
+	return response
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"tcp/utils"
+
+	"go.uber.org/zap"
+)
+
+// command executa o comando no dicionário com ProcessDictCommand; headers
+// alterna nomes e valores de cabeçalhos.
+func command(t *testing.T, dict Store, locks *TermLocks, method, term, body string, headers ...string) utils.HTTPResponse {
+	t.Helper()
+	request := &utils.HTTPRequest{Method: method, Path: term, Header: utils.Header{}, Body: body}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	return ProcessDictCommand(context.Background(), request, dict, locks)
+}
+
+func silenceLogger(t *testing.T) {
+	previous := logger
+	t.Cleanup(func() { logger = previous })
+	logger = zap.NewNop()
+}
+
+func TestConditionalUpdate(t *testing.T) {
+	silenceLogger(t)
+	tests := []struct {
+		name    string
+		method  string
+		term    string
+		ifMatch string
+		status  int
+		version string // Cabeçalho Version esperado na resposta
+	}{
+		{"UPDATE without If-Match", "UPDATE", "go", "", http.StatusOK, "3"},
+		{"UPDATE with the current version", "UPDATE", "go", `"2"`, http.StatusOK, "3"},
+		{"UPDATE with a bare version number", "UPDATE", "go", "2", http.StatusOK, "3"},
+		{"UPDATE with a stale version", "UPDATE", "go", `"1"`, http.StatusPreconditionFailed, "2"},
+		{"CAS with the current version", "CAS", "go", `"2"`, http.StatusOK, "3"},
+		{"CAS with a stale version", "CAS", "go", `"1"`, http.StatusConflict, "2"},
+		{"CAS without If-Match", "CAS", "go", "", http.StatusBadRequest, ""},
+		{"malformed If-Match", "UPDATE", "go", `"abc"`, http.StatusBadRequest, ""},
+		{"zero version", "CAS", "go", "0", http.StatusBadRequest, ""},
+		{"CAS on a missing term", "CAS", "rust", `"1"`, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dict, locks := NewDictionary(), NewTermLocks(lockStripes)
+			dict.Insert("go", "linguagem")
+			dict.Update("go", "linguagem do Google") // Versão 2
+			var headers []string
+			if tt.ifMatch != "" {
+				headers = []string{utils.HeaderIfMatch, tt.ifMatch}
+			}
+			response := command(t, dict, locks, tt.method, tt.term, "nova definição", headers...)
+			if response.StatusCode != tt.status {
+				t.Fatalf("%s = %d %q, want %d", tt.method, response.StatusCode, response.Body, tt.status)
+			}
+			if version := response.Header.Get(utils.HeaderVersion); version != tt.version {
+				t.Errorf("Version = %q, want %q", version, tt.version)
+			}
+			definition, _, _, _ := dict.LookUp("go")
+			if updated := definition == "nova definição"; updated != (tt.status == http.StatusOK) {
+				t.Errorf("definition = %q after a %d", definition, tt.status)
+			}
+		})
+	}
+}
+
+// Um termo removido e inserido de novo recebe uma versão nova, então um
+// If-Match da inserção anterior não vale mais.
+func TestVersionSurvivesReinsert(t *testing.T) {
+	silenceLogger(t)
+	dict, locks := NewDictionary(), NewTermLocks(lockStripes)
+	first := command(t, dict, locks, "INSERT", "go", "linguagem")
+	command(t, dict, locks, "DELETE", "go", "")
+	second := command(t, dict, locks, "INSERT", "go", "linguagem")
+	if first.Header.Get(utils.HeaderVersion) == second.Header.Get(utils.HeaderVersion) {
+		t.Fatalf("reinserted term kept version %s", first.Header.Get(utils.HeaderVersion))
+	}
+	stale := utils.FormatETag(mustParseUint(t, first.Header.Get(utils.HeaderVersion)))
+	if response := command(t, dict, locks, "CAS", "go", "nova", utils.HeaderIfMatch, stale); response.StatusCode != http.StatusConflict {
+		t.Errorf("CAS with the version before the reinsert = %d, want 409", response.StatusCode)
+	}
+}
+
+// Dois clientes que leram a mesma versão tentam o CAS ao mesmo tempo: só um
+// consegue, e o outro recebe 409 em vez de sobrescrever a alteração.
+func TestConcurrentCAS(t *testing.T) {
+	silenceLogger(t)
+	dict, locks := NewDictionary(), NewTermLocks(lockStripes)
+	version := command(t, dict, locks, "INSERT", "go", "linguagem").Header.Get(utils.HeaderVersion)
+
+	const clients = 8
+	statuses := make(chan int, clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response := command(t, dict, locks, "CAS", "go", "definição "+strconv.Itoa(i), utils.HeaderIfMatch, version)
+			statuses <- response.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusConflict] != clients-1 {
+		t.Errorf("CAS statuses = %v, want one 200 and %d 409", counts, clients-1)
+	}
+}
+
+func mustParseUint(t *testing.T, value string) uint64 {
+	t.Helper()
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
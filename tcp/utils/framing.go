@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Enquadramento das mensagens sobre a conexão TCP: cada mensagem (requisição
+// ou resposta) é precedida pelo seu tamanho em 4 bytes big-endian. Assim o
+// receptor sabe exatamente onde cada mensagem termina, independentemente de
+// como o TCP agrupa ou divide os segmentos.
+
+const frameHeaderSize = 4
+
+// Maior mensagem aceita por padrão
+const DefaultMaxFrameSize = 16 << 20
+
+// Requisições pendentes por conexão, quando enviadas em pipeline
+const DefaultMaxInFlight = 32
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+type FrameReader struct {
+	r       *bufio.Reader
+	maxSize int
+	current *io.LimitedReader
+}
+
+func NewFrameReader(r io.Reader, maxSize int) *FrameReader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	return &FrameReader{
+		r:       bufio.NewReader(r),
+		maxSize: maxSize,
+	}
+}
+
+// NextFrame lê o cabeçalho da próxima mensagem e retorna um leitor limitado
+// ao seu conteúdo, para que mensagens grandes possam ser processadas sem
+// carregá-las inteiras na memória. O que não for lido da mensagem anterior é
+// descartado.
+func (fr *FrameReader) NextFrame() (io.Reader, int, error) {
+	if fr.current != nil && fr.current.N > 0 {
+		if _, err := io.Copy(io.Discard, fr.current); err != nil {
+			return nil, 0, err
+		}
+	}
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(fr.r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if uint64(size) > uint64(fr.maxSize) {
+		return nil, 0, fmt.Errorf("%w: %d bytes (limit %d)", ErrFrameTooLarge, size, fr.maxSize)
+	}
+	fr.current = &io.LimitedReader{R: fr.r, N: int64(size)}
+	return fr.current, int(size), nil
+}
+
+// ReadFrame lê a próxima mensagem inteira.
+func (fr *FrameReader) ReadFrame() ([]byte, error) {
+	frame, size, err := fr.NextFrame()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(frame, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// FrameWriter escreve mensagens enquadradas. É seguro para uso concorrente:
+// cada mensagem é escrita por inteiro antes da próxima.
+type FrameWriter struct {
+	w   io.Writer
+	mux sync.Mutex
+}
+
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w}
+}
+
+func (fw *FrameWriter) WriteFrame(data []byte) error {
+	return fw.WriteFrameFrom(bytes.NewReader(data), len(data))
+}
+
+// WriteFrameFrom escreve uma mensagem de size bytes lida de r, sem exigir
+// que ela esteja inteira na memória.
+func (fw *FrameWriter) WriteFrameFrom(r io.Reader, size int) error {
+	if uint64(size) > uint64(^uint32(0)) {
+		return fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, size)
+	}
+	fw.mux.Lock()
+	defer fw.mux.Unlock()
+	var header [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:], uint32(size))
+	if _, err := fw.w.Write(header[:]); err != nil {
+		return err
+	}
+	n, err := io.CopyN(fw.w, r, int64(size))
+	if err != nil {
+		return fmt.Errorf("frame truncated after %d of %d bytes: %w", n, size, err)
+	}
+	return nil
+}
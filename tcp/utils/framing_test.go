@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// writeInPieces escreve data em w em pedaços de até size bytes, como o TCP
+// pode entregar uma mensagem dividida em vários segmentos.
+func writeInPieces(w io.WriteCloser, data []byte, size int) {
+	defer w.Close()
+	for len(data) > 0 {
+		n := min(size, len(data))
+		if _, err := w.Write(data[:n]); err != nil {
+			return
+		}
+		data = data[n:]
+	}
+}
+
+func frame(payload []byte) []byte {
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	return append(data, payload...)
+}
+
+func TestFrameReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		piece  int // Tamanho de cada escrita na conexão
+	}{
+		{"one frame in one write", [][]byte{[]byte("LOOKUP /go\r\n\r\n")}, 1 << 10},
+		{"frame split across reads", [][]byte{bytes.Repeat([]byte("abc"), 100)}, 7},
+		{"header split across reads", [][]byte{[]byte("hello")}, 1},
+		{"frames coalesced in one write", [][]byte{[]byte("first"), []byte("second"), []byte("third")}, 1 << 10},
+		{"zero-length frame", [][]byte{{}, []byte("after"), {}}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			var stream []byte
+			for _, f := range tt.frames {
+				stream = append(stream, frame(f)...)
+			}
+			go writeInPieces(client, stream, tt.piece)
+
+			reader := NewFrameReader(server, 1<<10)
+			for i, want := range tt.frames {
+				got, err := reader.ReadFrame()
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("frame %d = %q, want %q", i, got, want)
+				}
+			}
+			if _, err := reader.ReadFrame(); err != io.EOF {
+				t.Errorf("after the last frame: %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestFrameReaderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream []byte
+		err    error
+	}{
+		{"frame over the limit", frame(make([]byte, 65)), ErrFrameTooLarge},
+		{"4 GiB length", []byte{0xFF, 0xFF, 0xFF, 0xFF}, ErrFrameTooLarge},
+		{"truncated header", []byte{0, 0}, io.ErrUnexpectedEOF},
+		{"truncated body", frame([]byte("hello"))[:7], io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			go writeInPieces(client, tt.stream, 1<<10)
+
+			_, err := NewFrameReader(server, 64).ReadFrame()
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ReadFrame error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// NextFrame descarta o que não foi lido da mensagem anterior.
+func TestFrameReaderSkipsUnreadBody(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go writeInPieces(client, append(frame([]byte("ignored body")), frame([]byte("next"))...), 5)
+
+	reader := NewFrameReader(server, 0)
+	first, size, err := reader.NextFrame()
+	if err != nil || size != len("ignored body") {
+		t.Fatalf("NextFrame = %d bytes, %v", size, err)
+	}
+	if _, err := io.ReadFull(first, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reader.ReadFrame(); err != nil || string(got) != "next" {
+		t.Errorf("ReadFrame = %q, %v, want \"next\"", got, err)
+	}
+}
+
+func TestFrameWriterRoundTrip(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	frames := [][]byte{[]byte("one"), {}, bytes.Repeat([]byte("x"), 5000)}
+	go func() {
+		defer client.Close()
+		writer := NewFrameWriter(client)
+		for _, f := range frames {
+			if err := writer.WriteFrame(f); err != nil {
+				return
+			}
+		}
+	}()
+	reader := NewFrameReader(server, 0)
+	for i, want := range frames {
+		got, err := reader.ReadFrame()
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("frame %d = %d bytes, %v, want %d bytes", i, len(got), err, len(want))
+		}
+	}
+}
+
+// WriteFrameFrom falha se o leitor terminar antes dos size bytes anunciados.
+func TestFrameWriterShortReader(t *testing.T) {
+	var buf bytes.Buffer
+	err := NewFrameWriter(&buf).WriteFrameFrom(bytes.NewReader([]byte("abc")), 5)
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("WriteFrameFrom error = %v, want EOF", err)
+	}
+}
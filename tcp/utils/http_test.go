@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseHTTPRequestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		line   int
+		offset int
+	}{
+		{"empty message", "", 1, 0},
+		{"start line without CRLF", "LOOKUP /x", 1, 9},
+		{"missing path", "LOOKUP\r\n\r\n", 1, 0},
+		{"lowercase method", "lookup /x\r\n\r\n", 1, 0},
+		{"path without slash", "LOOKUP x\r\n\r\n", 1, 7},
+		{"space in path", "LOOKUP /a b\r\n\r\n", 1, 9},
+		{"bad escaping", "LOOKUP /%zz\r\n\r\n", 1, 7},
+		{"bare LF in start line", "LOOKUP /a\nb\r\n\r\n", 1, 9},
+		{"header without colon", "LOOKUP /x\r\nTimeout 5\r\n\r\n", 2, 11},
+		{"space in header name", "LOOKUP /x\r\nTime out: 5\r\n\r\n", 2, 15},
+		{"duplicate header", "LOOKUP /x\r\nTimeout: 1\r\ntimeout: 2\r\n\r\n", 3, 23},
+		{"headers without empty line", "LOOKUP /x\r\nTimeout: 1\r\n", 3, 23},
+		{"non-numeric Content-Length", "LOOKUP /x\r\nContent-Length: abc\r\n\r\n", 2, 26},
+		{"negative Content-Length", "LOOKUP /x\r\nContent-Length: -1\r\n\r\n", 2, 26},
+		{"body shorter than Content-Length", "INSERT /x\r\nContent-Length: 5\r\n\r\nabc", 4, 32},
+		{"body longer than Content-Length", "INSERT /x\r\nContent-Length: 2\r\n\r\nabc", 4, 32},
+		{"body without Content-Length", "INSERT /x\r\n\r\nabc", 3, 13},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseHTTPRequest([]byte(tt.data))
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseHTTPRequest error = %v, want a ParseError", err)
+			}
+			if parseErr.Line != tt.line || parseErr.Offset != tt.offset {
+				t.Errorf("error at line %d, offset %d (%s), want line %d, offset %d",
+					parseErr.Line, parseErr.Offset, parseErr.Msg, tt.line, tt.offset)
+			}
+		})
+	}
+}
+
+func TestParseHTTPResponseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		line   int
+		offset int
+	}{
+		{"empty message", "", 1, 0},
+		{"status line without text", "200\r\n\r\n", 1, 0},
+		{"short status code", "20 OK\r\n\r\n", 1, 0},
+		{"non-numeric status code", "abc OK\r\n\r\n", 1, 0},
+		{"status code below 100", "099 X\r\n\r\n", 1, 0},
+		{"non-numeric Content-Length", "200 OK\r\nContent-Length: x\r\n\r\n", 2, 23},
+		{"body shorter than Content-Length", "200 OK\r\nContent-Length: 10\r\n\r\nshort", 4, 30},
+		{"body without Content-Length", "200 OK\r\n\r\nbody", 3, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseHTTPResponse([]byte(tt.data))
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseHTTPResponse error = %v, want a ParseError", err)
+			}
+			if parseErr.Line != tt.line || parseErr.Offset != tt.offset {
+				t.Errorf("error at line %d, offset %d (%s), want line %d, offset %d",
+					parseErr.Line, parseErr.Offset, parseErr.Msg, tt.line, tt.offset)
+			}
+		})
+	}
+}
+
+func TestHTTPRequestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		request HTTPRequest
+	}{
+		{"no body", HTTPRequest{Method: "LOOKUP", Path: "go", Header: Header{}}},
+		{"escaped term", HTTPRequest{Method: "LOOKUP", Path: "café com leite/2", Header: Header{}}},
+		{"body with CRLF and Body:", HTTPRequest{Method: "INSERT", Path: "x", Header: Header{}, Body: "linha\r\nBody: não é cabeçalho\r\n\r\n"}},
+		{"headers", HTTPRequest{Method: "UPDATE", Path: "x", Header: Header{HeaderRequestID: "7", HeaderIfMatch: `"3"`}, Body: "def"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHTTPRequest(tt.request.Bytes())
+			if err != nil {
+				t.Fatalf("ParseHTTPRequest(%q): %v", tt.request.String(), err)
+			}
+			if got.Method != tt.request.Method || got.Path != tt.request.Path || got.Body != tt.request.Body {
+				t.Errorf("parsed %+v, want %+v", *got, tt.request)
+			}
+			for key, value := range tt.request.Header {
+				if got.Header.Get(key) != value {
+					t.Errorf("header %s = %q, want %q", key, got.Header.Get(key), value)
+				}
+			}
+		})
+	}
+}
+
+// Requisições de clientes antigos levam o corpo num cabeçalho "Body".
+func TestParseHTTPRequestLegacyBody(t *testing.T) {
+	request, err := ParseHTTPRequest([]byte("INSERT /x\r\nBody: definição\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Body != "definição" || request.Header.Get(legacyBodyHeader) != "" {
+		t.Errorf("Body = %q, headers %v", request.Body, request.Header)
+	}
+}
+
+func TestHTTPResponseRoundTrip(t *testing.T) {
+	sent := HTTPResponse{StatusCode: 409, Header: Header{HeaderVersion: "4"}, Body: "conflito\r\n"}
+	got, err := ParseHTTPResponse(sent.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.StatusCode != 409 || got.Body != sent.Body || got.Header.Get(HeaderVersion) != "4" ||
+		got.Header.Get(HeaderContentType) != ContentTypeText {
+		t.Errorf("parsed %+v, want %+v", *got, sent)
+	}
+}
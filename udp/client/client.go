@@ -1,8 +1,11 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 	"udp/utils"
@@ -11,9 +14,6 @@ import (
 	"go.uber.org/zap"
 )
 
-var packetStorage = utils.NewPacketStore()
-var packetStorageMutex sync.Mutex
-
 func StartClient(config *Config) error {
 	logger := utils.GetLogger()
 
@@ -25,26 +25,62 @@ func StartClient(config *Config) error {
 
 	logger.Info("UDP Address resolved!")
 
+	sess, err := connect(serverAddr, config.ARQConfig(), config.Probe, logger)
+	if err != nil {
+		logger.Warn("Error opening session", zap.Error(err))
+		return err
+	}
+	// Só há uma sessão aberta, então o estado dela é o das métricas
+	sess.congestion.ReportMetrics()
+	logger.Info("Connected to server", zap.String("address", config.AddressString()))
+	defer func() {
+		sess.close(logger)
+	}()
+	// Cursor e tamanho da página da última listagem incompleta
+	var nextPage, pageSize string
+	// Termo sugerido num 404 que o usuário escolheu consultar
+	var lookupNext string
+
 	for {
-		prompt := promptui.Select{
-			Label: "Selecione um comando",
-			Items: []string{"LIST", "LOOKUP", "INSERT", "UPDATE"},
-		}
+		// Com uma sugestão escolhida, o LOOKUP dela dispensa o menu
+		result := "LOOKUP"
+		if lookupNext == "" {
+			prompt := promptui.Select{
+				Label: "Selecione um comando",
+				Items: []string{"LIST", "LOOKUP", "SEARCH", "INSERT", "UPDATE", "CAS", "DELETE"},
+			}
 
-		_, result, err := prompt.Run()
-		if err != nil {
-			fmt.Printf("Prompt failed %v\n", err)
-			return err
+			if _, result, err = prompt.Run(); err != nil {
+				fmt.Printf("Prompt failed %v\n", err)
+				return err
+			}
 		}
 
-		var message string
+		var message, sort, cursor string
 
 		switch result {
 		case "LIST":
-			message = "LIST"
+			// O cursor da página anterior já guarda o prefixo e a ordem
+			if nextPage != "" && promptString("Continuar a listagem anterior? (s/N):") == "s" {
+				message, cursor = listCommand("", pageSize), nextPage
+				break
+			}
+			prefix := promptString("Prefixo (vazio para todos):")
+			pageSize = promptString("Termos por página (vazio para todos):")
+			if promptString("Ordem alfabética? (s/N):") == "s" {
+				sort = "alpha"
+			}
+			message = listCommand(prefix, pageSize)
 		case "LOOKUP":
-			term := promptString("Digite o termo para busca:")
+			term := lookupNext
+			if term == "" {
+				term = promptString("Digite o termo para busca:")
+			}
+			lookupNext = ""
 			message = fmt.Sprintf("LOOKUP %s", term)
+		case "SEARCH":
+			query := promptString("Palavras a buscar (termine com * para buscar pelo começo):")
+			message = fmt.Sprintf("SEARCH %s", query)
 		case "INSERT":
 			term := promptString("Termo:")
 			def := promptString("Definição:")
@@ -53,6 +89,14 @@ func StartClient(config *Config) error {
 			term := promptString("Termo:")
 			def := promptString("Nova definição:")
 			message = fmt.Sprintf("UPDATE %s %s", term, def)
+		case "CAS":
+			term := promptString("Termo:")
+			version := promptString("Versão esperada (do LOOKUP):")
+			def := promptString("Nova definição:")
+			message = fmt.Sprintf("CAS %s %s %s", term, version, def)
+		case "DELETE":
+			term := promptString("Termo a remover:")
+			message = fmt.Sprintf("DELETE %s", term)
 		}
 
 		request, err := ParseCommandToHTTPRequest(message)
@@ -61,42 +105,34 @@ func StartClient(config *Config) error {
 			logger.Info("Usage: <METHOD> [term] [definition]")
 			continue
 		}
-		requestPacket := utils.NewPacket(request.Bytes())
-
-		conn, err := net.DialUDP("udp", nil, serverAddr)
-		if err != nil {
-			logger.Warn("Error connecting to server", zap.Error(err))
-			return err
+		if request.Header == nil {
+			request.Header = utils.Header{}
 		}
-
-		logger.Info("Connected to server", zap.String("address", config.AddressString()))
-
-		for i, p := range requestPacket {
-			logger.Info("Sending packet", zap.Int("packet_index", i), zap.ByteString("payload", p.Payload))
-			_, err = conn.Write(p.Bytes())
-			if err != nil {
-				logger.Warn("Error sending data to server", zap.Error(err))
-				conn.Close()
+		request.Header.Set(utils.HeaderTimeout, config.RequestTimeout.String())
+		if sort != "" {
+			request.Header.Set(utils.HeaderSort, sort)
+		}
+		if cursor != "" {
+			request.Header.Set(utils.HeaderCursor, cursor)
+		}
+		responsePayload, err := sess.exchange(request.Bytes(), config.ResponseTimeout, logger)
+		if errors.Is(err, utils.ErrSessionReset) {
+			// O servidor encerrou a sessão (por exemplo, por inatividade): reabre e repete
+			logger.Info("Session reset by server, reconnecting")
+			sess.conn.Close()
+			if sess, err = connect(serverAddr, config.ARQConfig(), config.Probe, logger); err != nil {
+				logger.Warn("Error opening session", zap.Error(err))
 				return err
 			}
-			time.Sleep(10 * time.Millisecond)
+			sess.congestion.ReportMetrics()
+			responsePayload, err = sess.exchange(request.Bytes(), config.ResponseTimeout, logger)
 		}
-
-		buffer := make([]byte, 2048)
-		responsePayload := []byte{}
-		responseFinished := false
-		for !responseFinished {
-			n, remoteAddr, err := conn.ReadFromUDP(buffer)
-			if err != nil {
-				logger.Warn("Error reading from connection", zap.Error(err))
-				continue
-			}
-			data := make([]byte, n)
-			copy(data, buffer[:n])
-			logger.Info("Received data", zap.ByteString("data", data))
-			processResponse(data, &responsePayload, &responseFinished, remoteAddr, logger)
+		utils.GetMetrics().Log(logger)
+		if err != nil {
+			logger.Warn("Error exchanging data with server", zap.Error(err))
+			fmt.Printf("%s ERROR: %v\n", utils.GetEmoji(500), err)
+			continue
 		}
-		conn.Close()
 
 		statusCode, statusText, body := ParseHTTPResponse(string(responsePayload))
 
@@ -109,23 +145,110 @@ func StartClient(config *Config) error {
 		} else {
 			fmt.Printf("%s RESPONSE (%d %s): %s\n", utils.GetEmoji(statusCode), statusCode, statusText, body)
 		}
+		if response, err := utils.ParseHTTPResponse(responsePayload); err == nil {
+			printVersion(response.Header)
+			if statusCode == http.StatusNotFound {
+				lookupNext = chooseSuggestion(response.Header)
+			}
+			if result == "LIST" && statusCode == http.StatusOK {
+				nextPage = response.Header.Get(utils.HeaderNextCursor)
+			}
+			if result == "LIST" || result == "SEARCH" {
+				printPage(response.Header)
+			}
+		}
 	}
 }
 
-func processResponse(data []byte, response *[]byte, responseFinished *bool, remoteAddr *net.UDPAddr, logger *zap.Logger) {
-	defer logger.Info("Finished processing data", zap.String("remote_addr", remoteAddr.String()))
-
-	packet, err := utils.ParsePacket(data)
+// exchange envia a requisição com confirmação por fragmento e aguarda a resposta completa,
+// confirmando cada fragmento recebido.
+func exchange(conn *net.UDPConn, request []byte, arq utils.ARQConfig, congestion *utils.CongestionControl, timeout time.Duration, logger *zap.Logger) ([]byte, error) {
+	send := utils.SimulateLoss(arq.LossRate, func(p utils.Packet) error {
+		_, err := conn.Write(p.Bytes())
+		return err
+	})
+	messageID := utils.NextMessageID()
+	packets, err := utils.NewPacket(messageID, request, arq.FragmentSize)
 	if err != nil {
-		logger.Warn("Error parsing packet", zap.Error(err))
-		return
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
-	logger.Info("Parsed packet", zap.Uint16("control", packet.Control), zap.Uint16("length", packet.Length), zap.ByteString("payload", packet.Payload), zap.Uint16("crc", packet.CRC))
+	sender := utils.NewSender(packets, send, arq)
+	sender.SetCongestionControl(congestion)
+	defer sender.Stop()
 
-	*response, *responseFinished = verifyPacket(packet, packetStorage, &packetStorageMutex, remoteAddr, logger)
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- sender.Run()
+	}()
+
+	packetStorage := utils.NewPacketStore(utils.DefaultStoreConfig())
+	var packetStorageMutex sync.Mutex
+	deadline := time.Now().Add(timeout)
+	buffer := make([]byte, utils.DatagramSize(arq.FragmentSize))
+	for {
+		select {
+		case err := <-sendErr:
+			if err != nil {
+				return nil, fmt.Errorf("error sending request: %w", err)
+			}
+			sendErr = nil
+		default:
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("no response from server within %s", timeout)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(arq.Timeout))
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			return nil, fmt.Errorf("error reading from connection: %w", err)
+		}
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+		logger.Info("Received data", zap.ByteString("data", data))
+
+		packet, err := utils.ParsePacket(data)
+		if err != nil {
+			logger.Warn("Error parsing packet", zap.Error(err))
+			continue
+		}
+		logger.Info("Parsed packet", zap.Uint32("message_id", packet.MessageID), zap.Uint16("index", packet.Index), zap.Uint16("total", packet.Total), zap.Uint8("version", packet.Version), zap.Uint8("flags", packet.Flags), zap.ByteString("payload", packet.Payload), zap.Stringer("checksum", packet.Checksum), zap.Uint32("crc", packet.CRC))
+
+		if packet.Has(utils.FlagRST) && packet.MessageID == messageID {
+			return nil, utils.ErrSessionReset
+		}
+
+		// Pacotes de outras trocas (por exemplo, respostas atrasadas) são ignorados;
+		// fragmentos de respostas anteriores são confirmados de novo para que o
+		// servidor pare de retransmiti-los
+		if packet.MessageID != messageID {
+			logger.Info("Packet of another message ignored", zap.Uint32("message_id", packet.MessageID), zap.Uint32("expected", messageID))
+			if packet.IsData() && utils.NewCRC().ValidatePacket(packet) {
+				send(utils.NewAckPacket(packet.MessageID, packet.Index, arq.Window, packet.Checksum))
+			}
+			continue
+		}
+
+		if !packet.IsData() {
+			if utils.NewCRC().ValidatePacket(packet) {
+				sender.HandlePacket(packet)
+			}
+			continue
+		}
+
+		// O servidor só responde depois de receber a requisição inteira
+		sender.Stop()
+		payload, complete := verifyPacket(packet, packetStorage, &packetStorageMutex, remoteAddr, send, arq.Window, logger)
+		if complete {
+			return payload, nil
+		}
+	}
 }
 
-func verifyPacket(packet utils.Packet, ps *utils.PacketStore, mux *sync.Mutex, remoteAddr *net.UDPAddr, logger *zap.Logger) ([]byte, bool) {
+func verifyPacket(packet utils.Packet, ps *utils.PacketStore, mux *sync.Mutex, remoteAddr *net.UDPAddr, send func(utils.Packet) error, window uint16, logger *zap.Logger) ([]byte, bool) {
 	defer logger.Info("Finished processing data", zap.String("remote_addr", remoteAddr.String()))
 
 	crc := utils.NewCRC()
@@ -133,25 +256,42 @@ func verifyPacket(packet utils.Packet, ps *utils.PacketStore, mux *sync.Mutex, r
 		logger.Info("Packet CRC not valid", zap.String("remote_addr", remoteAddr.String()))
 		return []byte{}, false
 	}
-	payload := packet.Payload
-
-	if packet.Length > 0 {
-		mux.Lock()
-		ps.AddPacket(remoteAddr.String(), packet)
-		if ps.IsComplete(remoteAddr.String()) {
-			logger.Info("Packet complete", zap.String("remote_addr", remoteAddr.String()))
-			packets := ps.Packets[remoteAddr.String()]
-			payload = utils.GetCompletePayload(packets)
-			logger.Info("Complete payload received", zap.ByteString("payload", payload))
-			delete(ps.Packets, remoteAddr.String())
-			mux.Unlock()
-		} else {
-			mux.Unlock()
-			return []byte{}, false
+	utils.GetMetrics().AddReceived()
+	if packet.Total == 0 || packet.Index >= packet.Total {
+		logger.Info("Packet index out of range", zap.String("remote_addr", remoteAddr.String()), zap.Uint16("index", packet.Index), zap.Uint16("total", packet.Total))
+		return []byte{}, false
+	}
+	origin := remoteAddr.String()
+	id := packet.MessageID
+
+	mux.Lock()
+	if base := ps.Base(origin, id); int(packet.Index) >= int(base)+int(window) {
+		mux.Unlock()
+		logger.Info("Packet outside receive window", zap.String("remote_addr", origin), zap.Uint32("message_id", id), zap.Uint16("index", packet.Index), zap.Uint16("base", base))
+		return []byte{}, false
+	}
+	added, gaps := ps.AddPacket(origin, packet)
+	complete := added && ps.IsComplete(origin, id)
+	payload := []byte{}
+	if complete {
+		logger.Info("Packet complete", zap.String("remote_addr", origin), zap.Uint32("message_id", id))
+		payload = ps.AssemblePayload(origin, id)
+		logger.Info("Complete payload received", zap.ByteString("payload", payload))
+		ps.MarkCompleted(origin, id)
+	}
+	mux.Unlock()
+
+	if err := send(utils.NewAckPacket(id, packet.Index, window, packet.Checksum)); err != nil {
+		logger.Warn("Error sending ACK", zap.Error(err))
+	}
+	for _, gap := range gaps {
+		logger.Info("Requesting missing packet", zap.String("remote_addr", origin), zap.Uint32("message_id", id), zap.Uint16("index", gap))
+		if err := send(utils.NewNackPacket(id, gap, window, packet.Checksum)); err != nil {
+			logger.Warn("Error sending NACK", zap.Error(err))
 		}
 	}
 
-	return payload, true
+	return payload, complete
 }
 
 func promptString(label string) string {
@@ -165,3 +305,23 @@ func promptString(label string) string {
 	}
 	return result
 }
+
+// chooseSuggestion mostra os termos sugeridos num 404, no cabeçalho
+// Suggestions, e pergunta se o usuário quer consultar um deles. Retorna o termo
+// escolhido, ou "" se não há sugestões ou o usuário não quer nenhuma.
+func chooseSuggestion(header utils.Header) string {
+	suggestions := utils.ParseSuggestions(header.Get(utils.HeaderSuggestions))
+	if len(suggestions) == 0 {
+		return ""
+	}
+	fmt.Printf("   Você quis dizer: %s?\n", strings.Join(suggestions, ", "))
+	prompt := promptui.Select{
+		Label: "Consultar um dos termos sugeridos?",
+		Items: append(suggestions, "Não"),
+	}
+	i, _, err := prompt.Run()
+	if err != nil || i == len(suggestions) {
+		return ""
+	}
+	return suggestions[i]
+}
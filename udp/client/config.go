@@ -3,14 +3,31 @@ package client
 import (
 	"strconv"
 	"sync"
+	"time"
 	"udp/utils"
 
 	"go.uber.org/zap"
 )
 
+// Tempo extra de espera pela resposta depois do prazo da requisição
+const responseGrace = 5 * time.Second
+
 type Config struct {
-	Address        string
-	Port           int
+	Address         string
+	Port            int
+	RetryTimeout    time.Duration
+	MaxRetries      int
+	Window          uint16
+	LossRate        float64
+	ResponseTimeout time.Duration // Espera pela resposta completa
+	RequestTimeout  time.Duration // Prazo enviado ao servidor no cabeçalho Timeout
+	Checksum        utils.ChecksumType
+	FragmentSize    int  // Tamanho de fragmento proposto ao servidor
+	Probe           bool // Descobre o tamanho de fragmento por sondagem
+	// Cliente de teste
+	Workers        int           // Sessões enviando comandos ao mesmo tempo
+	WriteRatio     float64       // Fração dos comandos que são UPDATE (0-1)
+	Duration       time.Duration // Tempo até encerrar (0 espera Ctrl+C)
 	partialPackets map[string][]utils.Packet
 	mux            sync.Mutex
 }
@@ -20,10 +37,19 @@ func NewConfig() *Config {
 }
 
 func DefaultConfig() *Config {
+	arq := utils.DefaultARQConfig()
 	return &Config{
-		Address:        "localhost",
-		Port:           8080,
-		partialPackets: make(map[string][]utils.Packet),
+		Address:         "localhost",
+		Port:            8080,
+		RetryTimeout:    arq.Timeout,
+		MaxRetries:      arq.MaxRetries,
+		Window:          arq.Window,
+		ResponseTimeout: 30 * time.Second,
+		RequestTimeout:  30*time.Second - responseGrace,
+		Checksum:        arq.Checksum,
+		FragmentSize:    arq.FragmentSize,
+		Workers:         1,
+		partialPackets:  make(map[string][]utils.Packet),
 	}
 }
 
@@ -35,10 +61,75 @@ func (c *Config) SetPort(port int) {
 	c.Port = port
 }
 
+func (c *Config) SetRetryTimeout(timeout time.Duration) {
+	c.RetryTimeout = timeout
+}
+
+func (c *Config) SetMaxRetries(retries int) {
+	c.MaxRetries = retries
+}
+
+func (c *Config) SetWindow(window uint16) {
+	c.Window = window
+}
+
+func (c *Config) SetLossRate(rate float64) {
+	c.LossRate = rate
+}
+
 func (c *Config) AddressString() string {
 	return c.Address + ":" + strconv.Itoa(c.Port)
 }
 
+func (c *Config) SetChecksum(checksum utils.ChecksumType) {
+	c.Checksum = checksum
+}
+
+func (c *Config) SetFragmentSize(size int) {
+	c.FragmentSize = size
+}
+
+func (c *Config) SetProbe(probe bool) {
+	c.Probe = probe
+}
+
+// SetRequestTimeout define o prazo pedido ao servidor. A espera pela resposta
+// passa a ser um pouco maior, para que a resposta 408 do servidor ainda chegue.
+func (c *Config) SetRequestTimeout(timeout time.Duration) {
+	c.RequestTimeout = timeout
+	c.ResponseTimeout = timeout + responseGrace
+}
+
+func (c *Config) SetWorkers(n int) {
+	c.Workers = n
+}
+
+func (c *Config) SetWriteRatio(ratio float64) {
+	c.WriteRatio = ratio
+}
+
+func (c *Config) SetDuration(d time.Duration) {
+	c.Duration = d
+}
+
+func (c *Config) ARQConfig() utils.ARQConfig {
+	arq := utils.DefaultARQConfig()
+	arq.Timeout = c.RetryTimeout
+	arq.MaxRetries = c.MaxRetries
+	if c.Window > 0 {
+		arq.Window = c.Window
+	}
+	arq.LossRate = c.LossRate
+	arq.Checksum = c.Checksum
+	if c.FragmentSize > 0 {
+		arq.FragmentSize = c.FragmentSize
+	}
+	if arq.MaxTimeout < arq.Timeout {
+		arq.MaxTimeout = arq.Timeout
+	}
+	return arq
+}
+
 func (c *Config) AddPartialPacket(key string, packet utils.Packet) {
 	c.mux.Lock()
 	defer func() {
@@ -46,8 +137,8 @@ func (c *Config) AddPartialPacket(key string, packet utils.Packet) {
 		logger.Info(
 			"Added partial packet",
 			zap.String("key", key),
-			zap.Uint16("current_count", packet.Control),
-			zap.Uint16("expected_length", packet.Length),
+			zap.Uint16("current_count", packet.Index),
+			zap.Uint16("expected_length", packet.Total),
 		)
 		c.mux.Unlock()
 	}()
@@ -61,7 +152,7 @@ func (c *Config) IsPacketComplete(key string) bool {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 	if packets, exists := c.partialPackets[key]; exists {
-		return uint16(len(packets)) == packets[0].Length+1
+		return uint16(len(packets)) == packets[0].Total
 	}
 	return false
 }
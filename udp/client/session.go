@@ -0,0 +1,126 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"udp/utils"
+
+	"go.uber.org/zap"
+)
+
+// session é a conexão do cliente com o servidor, aberta pelo handshake
+// SYN / SYN+ACK / ACK e encerrada com FIN. As requisições da sessão
+// compartilham o controle de congestionamento, como as respostas do servidor.
+type session struct {
+	conn       *net.UDPConn
+	params     utils.SessionParams
+	arq        utils.ARQConfig
+	congestion *utils.CongestionControl
+}
+
+// connect abre a sessão propondo arq.FragmentSize como tamanho máximo de
+// fragmento ou, com probe, o maior tamanho que chega ao servidor.
+func connect(serverAddr *net.UDPAddr, arq utils.ARQConfig, probe bool, logger *zap.Logger) (*session, error) {
+	conn, err := net.DialUDP("udp", nil, serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to server: %w", err)
+	}
+	if probe {
+		arq.FragmentSize = utils.ProbeFragmentSize(conn, arq)
+	}
+	send := utils.SimulateLoss(arq.LossRate, func(p utils.Packet) error {
+		_, err := conn.Write(p.Bytes())
+		return err
+	})
+
+	syn := utils.NewSynPacket(utils.SessionParams{
+		MaxFragment: uint16(arq.FragmentSize),
+		Window:      arq.Window,
+		Checksum:    arq.Checksum,
+	})
+	reply, err := request(conn, syn, send, arq, func(p utils.Packet) bool {
+		return p.Has(utils.FlagSYN) && p.Has(utils.FlagACK)
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake with %s: %w", serverAddr, err)
+	}
+	params, err := utils.ParseSessionParams(reply)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake with %s: %w", serverAddr, err)
+	}
+	// Se o ACK se perder, o primeiro fragmento de dados também confirma a sessão
+	if err := send(utils.NewAckPacket(params.SessionID, 0, arq.Window, params.Checksum)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake with %s: %w", serverAddr, err)
+	}
+	arq.Checksum = params.Checksum
+	arq.FragmentSize = int(params.MaxFragment)
+	logger.Info("Session established",
+		zap.Uint32("session_id", params.SessionID),
+		zap.String("server", serverAddr.String()),
+		zap.Uint16("max_fragment", params.MaxFragment),
+		zap.Uint16("server_window", params.Window),
+		zap.Stringer("checksum", params.Checksum))
+	return &session{conn: conn, params: params, arq: arq, congestion: utils.NewCongestionControl(arq)}, nil
+}
+
+func (s *session) exchange(req []byte, timeout time.Duration, logger *zap.Logger) ([]byte, error) {
+	return exchange(s.conn, req, s.arq, s.congestion, timeout, logger)
+}
+
+// close envia FIN e aguarda o FIN+ACK; sem resposta, o servidor encerra a
+// sessão por inatividade.
+func (s *session) close(logger *zap.Logger) {
+	defer s.conn.Close()
+	send := utils.SimulateLoss(s.arq.LossRate, func(p utils.Packet) error {
+		_, err := s.conn.Write(p.Bytes())
+		return err
+	})
+	fin := utils.NewFinPacket(s.params.SessionID, s.params.Checksum)
+	_, err := request(s.conn, fin, send, s.arq, func(p utils.Packet) bool {
+		return p.Has(utils.FlagFIN) && p.Has(utils.FlagACK) && p.MessageID == s.params.SessionID
+	})
+	if err != nil {
+		logger.Warn("Session not closed cleanly", zap.Uint32("session_id", s.params.SessionID), zap.Error(err))
+		return
+	}
+	logger.Info("Session closed", zap.Uint32("session_id", s.params.SessionID))
+}
+
+// request envia um pacote de controle e o retransmite, com backoff, até
+// receber a resposta esperada.
+func request(conn *net.UDPConn, packet utils.Packet, send func(utils.Packet) error, arq utils.ARQConfig, expected func(utils.Packet) bool) (utils.Packet, error) {
+	buffer := make([]byte, utils.DatagramSize(arq.FragmentSize))
+	timeout := arq.Timeout
+	for attempt := 0; attempt <= arq.MaxRetries; attempt++ {
+		if err := send(packet); err != nil {
+			return utils.Packet{}, err
+		}
+		deadline := time.Now().Add(timeout)
+		for {
+			conn.SetReadDeadline(deadline)
+			n, err := conn.Read(buffer)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return utils.Packet{}, err
+			}
+			reply, err := utils.ParsePacket(buffer[:n])
+			if err != nil || !utils.NewCRC().ValidatePacket(reply) {
+				continue
+			}
+			if expected(reply) {
+				return reply, nil
+			}
+		}
+		timeout = min(2*timeout, arq.MaxTimeout)
+	}
+	return utils.Packet{}, utils.ErrMaxRetries
+}
@@ -6,7 +6,9 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"udp/utils"
@@ -14,116 +16,255 @@ import (
 	"go.uber.org/zap"
 )
 
-// RunTestClient executa um cliente de teste que envia comandos LIST e LOOKUP a cada 1 segundo
-func RunTestClient(address string, port int, interval time.Duration) error {
+// RunTestClient executa um cliente de teste que envia comandos LIST e LOOKUP
+// alternados a cada interval. Com config.Workers, várias sessões enviam ao
+// mesmo tempo; com config.WriteRatio, essa fração dos comandos é um UPDATE de
+// um termo sorteado. Com interval 0 os comandos são enviados sem pausa e sem
+// registrar cada resposta, para medir a vazão e a contenção no servidor. Ao
+// encerrar (Ctrl+C ou depois de config.Duration) registra a latência por
+// comando.
+func RunTestClient(config *Config, interval time.Duration) error {
 	logger := utils.GetLogger()
 
-	serverAddrStr := fmt.Sprintf("%s:%d", address, port)
+	serverAddrStr := config.AddressString()
+	workers := max(config.Workers, 1)
 	logger.Info("Test Client Configuration",
 		zap.String("server_address", serverAddrStr),
 		zap.Duration("interval", interval),
+		zap.Int("workers", workers),
+		zap.Float64("write_ratio", config.WriteRatio),
+		zap.Duration("duration", config.Duration),
 	)
 
+	serverAddr, err := net.ResolveUDPAddr("udp", serverAddrStr)
+	if err != nil {
+		return fmt.Errorf("error resolving address: %w", err)
+	}
+
 	// Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	nextCommand := "LIST"
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	var deadline <-chan time.Time
+	if config.Duration > 0 {
+		deadline = time.After(config.Duration)
+	}
+	stop := make(chan struct{})
 
 	logger.Info("Starting test client - Press Ctrl+C to stop")
 
-	for {
+	stats := newLoadStats()
+	start := time.Now()
+	wg := &sync.WaitGroup{}
+	for id := 0; id < workers; id++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runTestWorker(id, config, serverAddr, interval, stats, stop, logger)
+		}()
+	}
+
+	metricsTicker := time.NewTicker(10 * time.Second)
+	defer metricsTicker.Stop()
+	for running := true; running; {
 		select {
 		case <-sigChan:
 			logger.Info("Shutdown signal received")
-			return nil
-		case <-ticker.C:
-			command := nextCommand
+			running = false
+		case <-deadline:
+			running = false
+		case <-metricsTicker.C:
+			utils.GetMetrics().Log(logger)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	utils.GetMetrics().Log(logger)
+	stats.log(logger, time.Since(start))
+	return nil
+}
+
+// runTestWorker envia comandos por uma sessão própria até stop ser fechado.
+func runTestWorker(id int, config *Config, serverAddr *net.UDPAddr, interval time.Duration, stats *loadStats, stop <-chan struct{}, logger *zap.Logger) {
+	verbose := interval > 0
+	var ticker *time.Ticker
+	if verbose {
+		ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+	}
+
+	// A sessão é aberta sob demanda e reaberta após erros
+	var sess *session
+	defer func() {
+		if sess != nil {
+			sess.close(logger)
+		}
+	}()
+
+	// Com escritas, cada worker garante um termo próprio para que haja o que
+	// consultar e alterar mesmo com o dicionário vazio
+	nextCommand := "LIST"
+	if config.WriteRatio > 0 {
+		nextCommand = fmt.Sprintf("INSERT teste-%d definição inicial", id)
+	}
+	var terms []string
+	writes := 0
+
+	for {
+		if verbose {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		} else {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
 
-			response, err := sendTestCommand(logger, serverAddrStr, command)
-			if err != nil {
-				logger.Warn("Error sending command", zap.String("command", command), zap.Error(err))
+		command := nextCommand
+		if strings.HasPrefix(command, "LOOKUP") && len(terms) > 0 && rand.Float64() < config.WriteRatio {
+			writes++
+			command = fmt.Sprintf("UPDATE %s definição %d do worker %d", terms[rand.Intn(len(terms))], writes, id)
+		}
+
+		if sess == nil {
+			var err error
+			if sess, err = connect(serverAddr, config.ARQConfig(), config.Probe, logger); err != nil {
+				logger.Warn("Error opening session", zap.Error(err))
+				stats.record(command, 0, 0, err)
+				if !verbose {
+					// Sem o ticker, espera antes de tentar de novo
+					select {
+					case <-stop:
+						return
+					case <-time.After(time.Second):
+					}
+				}
+				continue
 			}
+		}
 
-			status_code, status_text, message := ParseHTTPResponse(string(response))
+		sent := time.Now()
+		response, err := sendTestCommand(logger, sess, command, verbose)
+		if err != nil {
+			logger.Warn("Error sending command", zap.String("command", command), zap.Error(err))
+			stats.record(command, 0, time.Since(sent), err)
+			sess.close(logger)
+			sess = nil
+			nextCommand = "LIST"
+			continue
+		}
+
+		status_code, status_text, message := ParseHTTPResponse(string(response))
+		stats.record(command, status_code, time.Since(sent), nil)
+		if verbose {
 			logger.Info(
 				"Mensage received:",
 				zap.Int("status_code", status_code),
 				zap.String("status_text", status_text),
 				zap.String("message", message),
 			)
-			if command == "LIST" {
+		}
+		if command == "LIST" {
+			if verbose {
 				logger.Info("Dictionary contents", zap.String("dictionary", message))
-				dict := DictionaryFromString(message)
-				nextCommand = "LOOKUP " + dict.keys[rand.Intn(len(dict.keys))]
-			} else {
-				nextCommand = "LIST"
 			}
+			terms = DictionaryFromString(message).keys
+			if len(terms) > 0 {
+				nextCommand = "LOOKUP " + terms[rand.Intn(len(terms))]
+			}
+		} else {
+			nextCommand = "LIST"
 		}
 	}
 }
 
-func sendTestCommand(logger *zap.Logger, serverAddr string, command string) ([]byte, error) {
-	logger.Info("Sending command", zap.String("command", command))
-
-	// Resolve UDP address
-	serverUDPAddr, err := net.ResolveUDPAddr("udp", serverAddr)
-	if err != nil {
-		return nil, fmt.Errorf("error resolving address: %w", err)
+func sendTestCommand(logger *zap.Logger, sess *session, command string, verbose bool) ([]byte, error) {
+	if verbose {
+		logger.Info("Sending command", zap.String("command", command))
 	}
 
-	// Create UDP connection
-	conn, err := net.DialUDP("udp", nil, serverUDPAddr)
+	// Create request
+	request, err := ParseCommandToHTTPRequest(command)
 	if err != nil {
-		return nil, fmt.Errorf("error connecting to server: %w", err)
+		return nil, fmt.Errorf("error parsing command: %w", err)
 	}
-	defer conn.Close()
 
-	// Set read deadline (5 seconds)
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// Send request fragments and wait for the complete response (5 seconds);
+	// the server gives up 1 second earlier so its 408 still arrives
+	request.Header = utils.Header{}
+	request.Header.Set(utils.HeaderTimeout, "4s")
+	return sess.exchange(request.Bytes(), 5*time.Second, logger)
+}
 
-	// Create request packet
-	request, err := ParseCommandToHTTPRequest(command)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing command: %w", err)
+// loadStats acumula as latências e os códigos de status por comando.
+type loadStats struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	statuses  map[int]int
+	errors    int
+}
+
+func newLoadStats() *loadStats {
+	return &loadStats{
+		latencies: make(map[string][]time.Duration),
+		statuses:  make(map[int]int),
 	}
-	packet := utils.NewPacket(request.Bytes())
+}
 
-	// Send all packet fragments
-	for i, p := range packet {
-		logger.Debug("Sending packet fragment",
-			zap.Int("fragment_index", i),
-			zap.ByteString("payload", p.Payload),
-		)
+func (s *loadStats) record(command string, statusCode int, latency time.Duration, err error) {
+	method, _, _ := strings.Cut(command, " ")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.errors++
+		return
+	}
+	s.latencies[method] = append(s.latencies[method], latency)
+	s.statuses[statusCode]++
+}
 
-		_, err = conn.Write(p.Bytes())
-		if err != nil {
-			return nil, fmt.Errorf("error sending packet: %w", err)
-		}
+func (s *loadStats) log(logger *zap.Logger, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		// Small delay between fragments
-		time.Sleep(10 * time.Millisecond)
+	methods := make([]string, 0, len(s.latencies))
+	total := 0
+	for method, latencies := range s.latencies {
+		methods = append(methods, method)
+		total += len(latencies)
+	}
+	sort.Strings(methods)
+	statuses := make(map[string]int, len(s.statuses))
+	for code, count := range s.statuses {
+		statuses[fmt.Sprint(code)] = count
 	}
+	logger.Info("Load test results",
+		zap.Duration("elapsed", elapsed),
+		zap.Int("requests", total),
+		zap.Float64("requests_per_second", float64(total)/elapsed.Seconds()),
+		zap.Int("errors", s.errors),
+		zap.Any("status_codes", statuses))
 
-	buffer := make([]byte, 2048)
-	responsePayload := []byte{}
-	responseFinished := false
-	for !responseFinished {
-		n, remoteAddr, err := conn.ReadFromUDP(buffer)
-		if err != nil {
-			logger.Warn("Error reading from connection", zap.Error(err))
-			continue
+	for _, method := range methods {
+		latencies := s.latencies[method]
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		percentile := func(p float64) time.Duration {
+			return latencies[int(p*float64(len(latencies)-1))]
 		}
-		data := make([]byte, n)
-		copy(data, buffer[:n])
-		logger.Info("Received data", zap.ByteString("data", data))
-		processResponse(data, &responsePayload, &responseFinished, remoteAddr, logger)
+		logger.Info("Load test latency",
+			zap.String("method", method),
+			zap.Int("requests", len(latencies)),
+			zap.Float64("requests_per_second", float64(len(latencies))/elapsed.Seconds()),
+			zap.Duration("p50", percentile(0.50)),
+			zap.Duration("p95", percentile(0.95)),
+			zap.Duration("p99", percentile(0.99)),
+			zap.Duration("max", latencies[len(latencies)-1]))
 	}
-	conn.Close()
-
-	return responsePayload, nil
 }
 
 func DictionaryFromString(data string) *Dictionary {
@@ -131,6 +272,9 @@ func DictionaryFromString(data string) *Dictionary {
 		terms: make(map[string]string),
 		keys:  []string{},
 	}
+	if len(data) < 2 {
+		return dict
+	}
 	trimmed := data[1 : len(data)-1]
 	if len(trimmed) == 0 {
 		return dict
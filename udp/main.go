@@ -35,16 +35,47 @@ func main() {
 	}
 
 	// Define flags
-	mode := flag.String("mode", "", "Mode to run: 'server' or 'client'")
+	mode := flag.String("mode", "", "Mode to run: 'server', 'client', 'teste', 'import' or 'export'")
 	address := flag.String("address", addrDefault, "Address to bind/connect to")
 	port := flag.Int("port", portDefault, "Port to bind/connect to")
+	store := flag.String("store", "", "Dictionary store (server): 'memory', 'file' (write-ahead log and snapshots) or 'kv' (key-value file); defaults to 'file' with -data-dir and 'memory' without")
+	dataDir := flag.String("data-dir", "", "Directory for the dictionary files (server); empty keeps it in memory only")
+	snapshotEvery := flag.Int("snapshot-every", server.DefaultSnapshotEvery, "Logged operations between dictionary snapshots (server)")
+	retries := flag.Int("retries", utils.DefaultARQConfig().MaxRetries, "Maximum retransmissions per fragment")
+	timeout := flag.Duration("timeout", utils.DefaultARQConfig().Timeout, "Initial retransmission timeout")
+	loss := flag.Float64("loss", 0, "Probability (0-1) of dropping outgoing packets, to simulate a lossy link")
+	reassemblyTimeout := flag.Duration("reassembly-timeout", utils.DefaultStoreConfig().ReassemblyTimeout, "Time without new fragments before an incomplete message is discarded (server)")
+	maxBuffer := flag.Int("max-buffer", utils.DefaultStoreConfig().MaxBufferedBytes, "Maximum bytes buffered for incomplete messages (server)")
+	maxPartial := flag.Int("max-partial", utils.DefaultStoreConfig().MaxPartialPerOrigin, "Maximum incomplete messages per client address (server)")
+	window := flag.Uint("window", uint(utils.DefaultARQConfig().Window), "Receive window in fragments (also caps fragments in flight)")
+
+	maxFragment := flag.Uint("max-fragment", utils.MaxPayload, "Largest fragment payload the server accepts when negotiating a session")
+	requestTimeout := flag.Duration("request-timeout", server.DefaultRequestTimeout, "Time limit for each request, including waiting for term locks; the client sends it in the Timeout header")
+	sessionIdle := flag.Duration("session-idle", server.DefaultConfig().SessionIdleTimeout, "Idle time before the server closes a session")
+	admin := flag.String("admin", "", "Address for the server admin view (GET /sessions), e.g. localhost:8081; empty disables it")
+	fragment := flag.Int("fragment", utils.DefaultFragmentSize, "Fragment payload size the client proposes to the server")
+	probe := flag.Bool("probe", false, "Discover the largest fragment that reaches the server before opening the session (client)")
+	workers := flag.Int("workers", 1, "Concurrent sessions sending commands (teste)")
+	writeRatio := flag.Float64("write-ratio", 0, "Fraction (0-1) of commands that are UPDATEs of a random term (teste)")
+	interval := flag.Duration("interval", 100*time.Millisecond, "Pause between commands of each session; 0 sends them back to back without logging each response (teste)")
+	duration := flag.Duration("duration", 0, "Stop after this long and log the results; 0 runs until Ctrl+C (teste)")
+	file := flag.String("file", "", "File to import from or export to (import/export); export writes to stdout without it")
+	format := flag.String("format", "", "File format (import/export): 'json', 'csv' or 'jsonl'; defaults to the file extension")
+	conflict := flag.String("conflict", "", "What import does with terms that already exist: 'skip' (default), 'overwrite' or 'fail'")
+	checksumName := flag.String("checksum", utils.DefaultChecksum.String(), "Checksum for packets sent by the client: crc16, crc32 or crc32c (the server replies with the client's choice)")
 
 	flag.Parse()
 
+	checksum, err := utils.ParseChecksumType(*checksumName)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
 	// Validate mode
 	if *mode == "" {
 		fmt.Println("Error: mode flag is required")
-		fmt.Println("Usage: go run main.go -mode=<server|client|teste> [-address=<address>] [-port=<port>]")
+		fmt.Println("Usage: go run main.go -mode=<server|client|teste|import|export> [-address=<address>] [-port=<port>] [-store=<memory|file|kv>] [-data-dir=<dir>] [-retries=<n>] [-timeout=<duration>] [-window=<n>] [-loss=<rate>] [-reassembly-timeout=<duration>] [-max-buffer=<bytes>] [-max-partial=<n>] [-checksum=<crc16|crc32|crc32c>] [-max-fragment=<bytes>] [-session-idle=<duration>] [-request-timeout=<duration>] [-admin=<address>] [-fragment=<bytes>] [-probe] [-workers=<n>] [-write-ratio=<0-1>] [-interval=<duration>] [-duration=<duration>] [-file=<file>] [-format=<json|csv|jsonl>] [-conflict=<skip|overwrite|fail>]")
 		os.Exit(1)
 	}
 
@@ -53,6 +84,20 @@ func main() {
 		config := server.NewConfig()
 		config.SetAddress(*address)
 		config.SetPort(*port)
+		config.SetStore(*store)
+		config.SetDataDir(*dataDir)
+		config.SetSnapshotEvery(*snapshotEvery)
+		config.SetMaxRetries(*retries)
+		config.SetRetryTimeout(*timeout)
+		config.SetWindow(uint16(*window))
+		config.SetLossRate(*loss)
+		config.SetReassemblyTimeout(*reassemblyTimeout)
+		config.SetMaxBufferedBytes(*maxBuffer)
+		config.SetMaxPartialPerOrigin(*maxPartial)
+		config.SetMaxFragment(uint16(*maxFragment))
+		config.SetSessionIdleTimeout(*sessionIdle)
+		config.SetRequestTimeout(*requestTimeout)
+		config.SetAdminAddress(*admin)
 
 		logger.Info("Starting UDP server", zap.String("address", config.AddressString()))
 		if err := server.StartServer(config); err != nil {
@@ -63,19 +108,67 @@ func main() {
 		config := client.NewConfig()
 		config.SetAddress(*address)
 		config.SetPort(*port)
+		config.SetMaxRetries(*retries)
+		config.SetRetryTimeout(*timeout)
+		config.SetWindow(uint16(*window))
+		config.SetLossRate(*loss)
+		config.SetChecksum(checksum)
+		config.SetFragmentSize(*fragment)
+		config.SetProbe(*probe)
+		config.SetRequestTimeout(*requestTimeout)
 
 		logger.Info("Starting UDP client", zap.String("address", config.AddressString()))
 		client.StartClient(config)
 
 	case "teste":
-		logger.Info("Starting test client", zap.String("address", *address), zap.Int("port", *port))
-		if err := client.RunTestClient(*address, *port, 100*time.Millisecond); err != nil {
+		config := client.NewConfig()
+		config.SetAddress(*address)
+		config.SetPort(*port)
+		config.SetMaxRetries(*retries)
+		config.SetRetryTimeout(*timeout)
+		config.SetWindow(uint16(*window))
+		config.SetLossRate(*loss)
+		config.SetChecksum(checksum)
+		config.SetFragmentSize(*fragment)
+		config.SetProbe(*probe)
+		config.SetWorkers(*workers)
+		config.SetWriteRatio(*writeRatio)
+		config.SetDuration(*duration)
+
+		logger.Info("Starting test client", zap.String("address", config.AddressString()))
+		if err := client.RunTestClient(config, *interval); err != nil {
 			logger.Fatal("Failed to run test client", zap.Error(err))
 		}
 
+	case "import", "export":
+		config := client.NewConfig()
+		config.SetAddress(*address)
+		config.SetPort(*port)
+		config.SetMaxRetries(*retries)
+		config.SetRetryTimeout(*timeout)
+		config.SetWindow(uint16(*window))
+		config.SetLossRate(*loss)
+		config.SetChecksum(checksum)
+		config.SetFragmentSize(*fragment)
+		config.SetProbe(*probe)
+		config.SetRequestTimeout(*requestTimeout)
+
+		if *mode == "import" {
+			if *file == "" {
+				fmt.Println("Error: import requires -file")
+				os.Exit(1)
+			}
+			err = client.ImportFile(config, *file, *format, *conflict)
+		} else {
+			err = client.ExportFile(config, *file, *format)
+		}
+		if err != nil {
+			logger.Fatal("Failed to "+*mode+" dictionary", zap.Error(err))
+		}
+
 	default:
 		fmt.Printf("Error: invalid mode '%s'\n", *mode)
-		fmt.Println("Mode must be either 'server', 'client', or 'teste'")
+		fmt.Println("Mode must be one of 'server', 'client', 'teste', 'import' or 'export'")
 		os.Exit(1)
 	}
 }
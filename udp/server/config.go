@@ -2,11 +2,32 @@ package server
 
 import (
 	"strconv"
+	"time"
+
+	"udp/utils"
 )
 
 type Config struct {
-	Address string
-	Port    int
+	Address         string
+	Port            int
+	RetryTimeout    time.Duration
+	MaxRetries      int
+	Window          uint16
+	LossRate        float64
+	MetricsInterval time.Duration
+	// Limites da remontagem de mensagens fragmentadas
+	ReassemblyTimeout   time.Duration
+	MaxBufferedBytes    int
+	MaxPartialPerOrigin int
+	// Sessões
+	MaxFragment        uint16        // Maior fragmento aceito na negociação
+	SessionIdleTimeout time.Duration // Sessões sem atividade são encerradas
+	RequestTimeout     time.Duration // Prazo de cada requisição, incluindo a espera pelos locks
+	AdminAddress       string        // Endereço HTTP da visão administrativa (vazio desativa)
+	// Persistência
+	Store         string // Tipo de armazenamento: memory, file ou kv (vazio escolhe pelo DataDir)
+	DataDir       string // Diretório dos arquivos do dicionário (vazio mantém o dicionário só na memória)
+	SnapshotEvery int    // Operações no log entre dois snapshots
 }
 
 func NewConfig() *Config {
@@ -14,9 +35,23 @@ func NewConfig() *Config {
 }
 
 func DefaultConfig() *Config {
+	arq := utils.DefaultARQConfig()
+	store := utils.DefaultStoreConfig()
 	return &Config{
-		Address: "localhost",
-		Port:    8080,
+		Address:         "localhost",
+		Port:            8080,
+		RetryTimeout:    arq.Timeout,
+		MaxRetries:      arq.MaxRetries,
+		Window:          arq.Window,
+		MetricsInterval: 30 * time.Second,
+
+		ReassemblyTimeout:   store.ReassemblyTimeout,
+		MaxBufferedBytes:    store.MaxBufferedBytes,
+		MaxPartialPerOrigin: store.MaxPartialPerOrigin,
+
+		MaxFragment:        utils.MaxPayload,
+		SessionIdleTimeout: 60 * time.Second,
+		RequestTimeout:     DefaultRequestTimeout,
 	}
 }
 
@@ -28,6 +63,84 @@ func (c *Config) SetPort(port int) {
 	c.Port = port
 }
 
+func (c *Config) SetStore(store string) {
+	c.Store = store
+}
+
+func (c *Config) SetDataDir(dir string) {
+	c.DataDir = dir
+}
+
+func (c *Config) SetSnapshotEvery(n int) {
+	c.SnapshotEvery = n
+}
+
 func (c *Config) AddressString() string {
 	return c.Address + ":" + strconv.Itoa(c.Port)
 }
+
+func (c *Config) SetRetryTimeout(timeout time.Duration) {
+	c.RetryTimeout = timeout
+}
+
+func (c *Config) SetMaxRetries(retries int) {
+	c.MaxRetries = retries
+}
+
+func (c *Config) SetWindow(window uint16) {
+	c.Window = window
+}
+
+func (c *Config) SetLossRate(rate float64) {
+	c.LossRate = rate
+}
+
+func (c *Config) ARQConfig() utils.ARQConfig {
+	arq := utils.DefaultARQConfig()
+	arq.Timeout = c.RetryTimeout
+	arq.MaxRetries = c.MaxRetries
+	if c.Window > 0 {
+		arq.Window = c.Window
+	}
+	arq.LossRate = c.LossRate
+	if arq.MaxTimeout < arq.Timeout {
+		arq.MaxTimeout = arq.Timeout
+	}
+	return arq
+}
+
+func (c *Config) SetReassemblyTimeout(timeout time.Duration) {
+	c.ReassemblyTimeout = timeout
+}
+
+func (c *Config) SetMaxBufferedBytes(bytes int) {
+	c.MaxBufferedBytes = bytes
+}
+
+func (c *Config) SetMaxPartialPerOrigin(messages int) {
+	c.MaxPartialPerOrigin = messages
+}
+
+func (c *Config) StoreConfig() utils.StoreConfig {
+	return utils.StoreConfig{
+		ReassemblyTimeout:   c.ReassemblyTimeout,
+		MaxBufferedBytes:    c.MaxBufferedBytes,
+		MaxPartialPerOrigin: c.MaxPartialPerOrigin,
+	}
+}
+
+func (c *Config) SetMaxFragment(size uint16) {
+	c.MaxFragment = size
+}
+
+func (c *Config) SetRequestTimeout(timeout time.Duration) {
+	c.RequestTimeout = timeout
+}
+
+func (c *Config) SetSessionIdleTimeout(timeout time.Duration) {
+	c.SessionIdleTimeout = timeout
+}
+
+func (c *Config) SetAdminAddress(address string) {
+	c.AdminAddress = address
+}
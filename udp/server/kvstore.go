@@ -0,0 +1,658 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Armazenamento chave-valor em um único arquivo, no estilo de bancos embutidos
+// como o BoltDB: o servidor abre o arquivo e o consulta diretamente, sem um
+// processo à parte. Só o índice (termo → posição do registro) fica na memória;
+// as definições são lidas do disco a cada consulta.
+//
+// O arquivo começa com a assinatura "DICTKV2\n" e a revisão (8 bytes), seguidas
+// dos registros:
+//
+//	crc (4) | op (1) | versão (8) | tamanho do termo (4) | tamanho da definição (4) | termo | definição
+//
+// Os números são big-endian e o CRC-32 (Castagnoli) cobre o registro a partir
+// de op. Cada alteração acrescenta um registro, sincronizado com fsync antes de
+// a operação ser confirmada, junto com os que chegarem durante o fsync anterior
+// (ver commit.go); a remoção grava um registro sem definição. Um lote
+// de Store.Apply é um único registro cuja definição são os registros das
+// alterações, de modo que o CRC cobre o lote inteiro. Quando
+// os registros substituídos passam da metade do arquivo, ele é reescrito só com
+// os termos atuais. A revisão do cabeçalho é a maior versão já atribuída quando
+// o arquivo foi reescrito, para que as remoções descartadas não deixem a versão
+// de um termo reinserido voltar atrás.
+//
+// Arquivos no formato anterior ("DICTKV1\n", sem revisão nem versões) são
+// convertidos na abertura.
+
+const (
+	kvMagic       = "DICTKV2\n"
+	kvLegacyMagic = "DICTKV1\n"
+)
+
+// Assinatura e revisão
+const kvFileHeaderSize = len(kvMagic) + 8
+
+// Cabeçalho dos registros no formato atual e no anterior
+const (
+	kvHeaderSize       = 21
+	kvLegacyHeaderSize = 13
+)
+
+const (
+	kvPut    byte = 1
+	kvDelete byte = 2
+	kvBatch  byte = 3
+)
+
+// Bytes substituídos a partir dos quais o arquivo pode ser compactado
+const kvCompactMinBytes = 1 << 20
+
+var kvTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errKVChecksum = errors.New("checksum mismatch")
+
+// kvLocation é a posição de um registro no arquivo, a versão do termo e a
+// sequência da sua inserção (ver ListEntry).
+type kvLocation struct {
+	offset  int64
+	length  int
+	version uint64
+	seq     uint64
+}
+
+type kvRecord struct {
+	op         byte
+	version    uint64 // 0 nos registros do formato anterior
+	term       string
+	definition string
+	batch      []kvRecord // Alterações de um kvBatch, que não tem termo nem definição
+}
+
+type KVStore struct {
+	mu           sync.RWMutex // Protege index, keys, search, inserted, file e recordHeader
+	writeMu      sync.Mutex   // Serializa a escrita das alterações; protege os campos abaixo de search
+	path         string
+	file         *os.File
+	index        map[string]kvLocation
+	keys         []string
+	search       *searchIndex
+	inserted     uint64      // Última sequência de inserção
+	recordHeader int         // kvHeaderSize, ou kvLegacyHeaderSize até a conversão
+	size         int64       // Fim do último registro confirmado pelo fsync
+	written      int64       // Fim do último registro escrito, confirmado ou não
+	pending      []kvPending // Registros escritos e ainda não confirmados, em ordem
+	group        *groupCommit
+	dead         int64  // Bytes de registros substituídos ou removidos
+	revision     uint64 // Maior versão já atribuída
+	failed       error  // Impede novas gravações se o arquivo não pôde ser restaurado
+}
+
+type kvPending struct {
+	record kvRecord
+	data   []byte
+}
+
+// OpenKVStore abre o arquivo em path, criando-o se necessário, e reconstrói o
+// índice. Um último registro incompleto (queda durante a escrita) é descartado.
+func OpenKVStore(path string) (*KVStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating data dir: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening key-value store: %w", err)
+	}
+	s := &KVStore{
+		path:         path,
+		file:         file,
+		index:        make(map[string]kvLocation),
+		keys:         []string{},
+		search:       newSearchIndex(),
+		recordHeader: kvHeaderSize,
+	}
+	s.group = newGroupCommit(&s.writeMu)
+	if err := s.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.written = s.size
+	if s.recordHeader == kvLegacyHeaderSize {
+		if err := s.compact(); err != nil {
+			s.file.Close()
+			return nil, fmt.Errorf("converting key-value store to the current format: %w", err)
+		}
+	}
+	logger.Info("Key-value store opened",
+		zap.String("path", path),
+		zap.Int("terms", len(s.keys)),
+		zap.Int64("bytes", s.size),
+		zap.Int64("dead_bytes", s.dead),
+		zap.Uint64("revision", s.revision))
+	return s, nil
+}
+
+func (s *KVStore) load() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		if _, err := s.file.WriteAt(encodeKVFileHeader(0), 0); err != nil {
+			return fmt.Errorf("initializing key-value store: %w", err)
+		}
+		if err := s.file.Sync(); err != nil {
+			return fmt.Errorf("syncing key-value store: %w", err)
+		}
+		s.size = int64(kvFileHeaderSize)
+		return nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(s.file, 0, info.Size()))
+	magic := make([]byte, len(kvMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return fmt.Errorf("%s is not a dictionary key-value file", s.path)
+	}
+	switch string(magic) {
+	case kvMagic:
+		revision := make([]byte, 8)
+		if _, err := io.ReadFull(reader, revision); err != nil {
+			return fmt.Errorf("%s: truncated key-value file header", s.path)
+		}
+		s.revision = binary.BigEndian.Uint64(revision)
+		s.size = int64(kvFileHeaderSize)
+	case kvLegacyMagic:
+		s.recordHeader = kvLegacyHeaderSize
+		s.size = int64(len(kvLegacyMagic))
+	default:
+		return fmt.Errorf("%s is not a dictionary key-value file", s.path)
+	}
+	for {
+		data, err := readKVRecord(reader, info.Size()-s.size, s.recordHeader)
+		if err == io.EOF {
+			return nil
+		}
+		var record kvRecord
+		if err == nil {
+			record, err = decodeKVRecord(data, s.recordHeader)
+		}
+		last := err != nil && (errors.Is(err, io.ErrUnexpectedEOF) || s.size+int64(len(data)) == info.Size())
+		if last {
+			logger.Warn("Discarding incomplete key-value record",
+				zap.Int64("offset", s.size),
+				zap.Int64("bytes", info.Size()-s.size),
+				zap.Error(err))
+			if err := s.file.Truncate(s.size); err != nil {
+				return fmt.Errorf("truncating key-value store: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("key-value record at offset %d: %w", s.size, err)
+		}
+		s.apply(record, kvLocation{offset: s.size, length: len(data)})
+		s.size += int64(len(data))
+	}
+}
+
+func encodeKVFileHeader(revision uint64) []byte {
+	header := make([]byte, kvFileHeaderSize)
+	copy(header, kvMagic)
+	binary.BigEndian.PutUint64(header[len(kvMagic):], revision)
+	return header
+}
+
+// readKVRecord lê o próximo registro inteiro, cujo cabeçalho tem headerSize
+// bytes. Tamanhos que passam de remaining indicam um registro cortado.
+func readKVRecord(reader io.Reader, remaining int64, headerSize int) ([]byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	termSize, definitionSize := kvSizes(header, headerSize)
+	length := int64(headerSize) + int64(termSize) + int64(definitionSize)
+	if length > remaining {
+		return header, io.ErrUnexpectedEOF
+	}
+	record := make([]byte, length)
+	copy(record, header)
+	if _, err := io.ReadFull(reader, record[headerSize:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return record, nil
+}
+
+// kvSizes lê os tamanhos do termo e da definição, os últimos campos do
+// cabeçalho nos dois formatos.
+func kvSizes(record []byte, headerSize int) (uint32, uint32) {
+	return binary.BigEndian.Uint32(record[headerSize-8 : headerSize-4]), binary.BigEndian.Uint32(record[headerSize-4 : headerSize])
+}
+
+func encodeKVRecord(record kvRecord) []byte {
+	definition := record.definition
+	if record.op == kvBatch {
+		var payload []byte
+		for _, change := range record.batch {
+			payload = append(payload, encodeKVRecord(change)...)
+		}
+		definition = string(payload)
+	}
+	data := make([]byte, kvHeaderSize+len(record.term)+len(definition))
+	data[4] = record.op
+	binary.BigEndian.PutUint64(data[5:13], record.version)
+	binary.BigEndian.PutUint32(data[13:17], uint32(len(record.term)))
+	binary.BigEndian.PutUint32(data[17:21], uint32(len(definition)))
+	copy(data[kvHeaderSize:], record.term)
+	copy(data[kvHeaderSize+len(record.term):], definition)
+	binary.BigEndian.PutUint32(data[0:4], crc32.Checksum(data[4:], kvTable))
+	return data
+}
+
+func decodeKVRecord(data []byte, headerSize int) (kvRecord, error) {
+	if crc32.Checksum(data[4:], kvTable) != binary.BigEndian.Uint32(data[0:4]) {
+		return kvRecord{}, errKVChecksum
+	}
+	record := kvRecord{op: data[4]}
+	if record.op != kvPut && record.op != kvDelete && (record.op != kvBatch || headerSize != kvHeaderSize) {
+		return kvRecord{}, fmt.Errorf("unknown operation %d", record.op)
+	}
+	if headerSize == kvHeaderSize {
+		record.version = binary.BigEndian.Uint64(data[5:13])
+	}
+	termSize, _ := kvSizes(data, headerSize)
+	record.term = string(data[headerSize : headerSize+int(termSize)])
+	if record.op != kvBatch {
+		record.definition = string(data[headerSize+int(termSize):])
+		return record, nil
+	}
+
+	payload := data[headerSize+int(termSize):]
+	for len(payload) > 0 {
+		if len(payload) < kvHeaderSize {
+			return kvRecord{}, errors.New("truncated batch")
+		}
+		termSize, definitionSize := kvSizes(payload, kvHeaderSize)
+		length := int64(kvHeaderSize) + int64(termSize) + int64(definitionSize)
+		if length > int64(len(payload)) {
+			return kvRecord{}, errors.New("truncated batch")
+		}
+		change, err := decodeKVRecord(payload[:length], kvHeaderSize)
+		if err != nil {
+			return kvRecord{}, fmt.Errorf("batch: %w", err)
+		}
+		if change.op == kvBatch {
+			return kvRecord{}, errors.New("nested batch")
+		}
+		record.batch = append(record.batch, change)
+		payload = payload[length:]
+	}
+	return record, nil
+}
+
+// apply atualiza o índice com um registro já gravado. Registros do formato
+// anterior, sem versão, recebem a próxima.
+func (s *KVStore) apply(record kvRecord, location kvLocation) {
+	if record.op == kvBatch {
+		// Cada alteração é um registro completo dentro do lote e é indexada na
+		// própria posição; o cabeçalho do lote não sobrevive à compactação
+		s.dead += int64(kvHeaderSize + len(record.term))
+		offset := location.offset + int64(kvHeaderSize+len(record.term))
+		for _, change := range record.batch {
+			length := kvHeaderSize + len(change.term) + len(change.definition)
+			s.apply(change, kvLocation{offset: offset, length: length})
+			offset += int64(length)
+		}
+		return
+	}
+	if record.version == 0 {
+		record.version = s.revision + 1
+	}
+	s.revision = max(s.revision, record.version)
+	location.version = record.version
+
+	term := record.term
+	previous, exists := s.index[term]
+	if exists {
+		s.dead += int64(previous.length)
+	}
+	switch record.op {
+	case kvPut:
+		location.seq = previous.seq
+		if !exists {
+			s.keys = append(s.keys, term)
+			s.inserted++
+			location.seq = s.inserted
+		}
+		s.index[term] = location
+		s.search.add(term, record.definition)
+	case kvDelete:
+		// O próprio registro de remoção não é necessário depois da compactação
+		s.dead += int64(location.length)
+		if !exists {
+			return
+		}
+		delete(s.index, term)
+		s.search.remove(term)
+		s.keys = slices.DeleteFunc(s.keys, func(key string) bool { return key == term })
+	}
+}
+
+func (s *KVStore) write(op byte, term, definition string) (uint64, error) {
+	s.writeMu.Lock()
+	return s.append(kvRecord{op: op, version: s.revision + 1, term: term, definition: definition})
+}
+
+// append acrescenta o registro ao arquivo e espera o fsync que o confirma e o
+// aplica no índice, e retorna a versão do registro. Se a escrita ou o fsync
+// falhar, o arquivo e o índice não mudam; se o arquivo não puder ser
+// restaurado, as próximas gravações são recusadas. As consultas só esperam
+// pela atualização do índice, não pelo fsync. Roda com writeMu, que é
+// liberado antes de retornar.
+func (s *KVStore) append(record kvRecord) (uint64, error) {
+	if s.failed != nil {
+		s.writeMu.Unlock()
+		return 0, s.failed
+	}
+	data := encodeKVRecord(record)
+	if _, err := s.file.WriteAt(data, s.written); err != nil {
+		s.discard(s.written)
+		s.writeMu.Unlock()
+		return 0, fmt.Errorf("writing key-value store: %w", err)
+	}
+	s.written += int64(len(data))
+	s.revision = record.version
+	s.pending = append(s.pending, kvPending{record: record, data: data})
+	if err := s.group.wait(s); err != nil {
+		return 0, err
+	}
+	return record.version, nil
+}
+
+func (s *KVStore) sync() error {
+	if err := syncFile(s.file); err != nil {
+		return fmt.Errorf("syncing key-value store: %w", err)
+	}
+	return nil
+}
+
+// commit aplica no índice os registros confirmados, que estão em sequência a
+// partir de size, e compacta o arquivo se necessário.
+func (s *KVStore) commit(n int) error {
+	s.mu.Lock()
+	for _, p := range s.pending[:n] {
+		s.apply(p.record, kvLocation{offset: s.size, length: len(p.data)})
+		s.size += int64(len(p.data))
+	}
+	s.mu.Unlock()
+	s.pending = s.pending[n:]
+
+	if s.dead >= kvCompactMinBytes && s.dead*2 >= s.size {
+		if err := s.compact(); err != nil {
+			logger.Warn("Error compacting key-value store", zap.String("path", s.path), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// abort descarta os registros pendentes depois de uma falha no fsync e
+// devolve suas versões. Os registros já estão no arquivo e seriam lidos na
+// próxima abertura.
+func (s *KVStore) abort() {
+	s.revision = s.pending[0].record.version - 1
+	s.pending = nil
+	s.discard(s.size)
+}
+
+// discard remove do arquivo o que foi escrito depois de size. Roda com
+// writeMu.
+func (s *KVStore) discard(size int64) {
+	err := s.file.Truncate(size)
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		s.failed = fmt.Errorf("key-value store could not be restored after a failed write, refusing further writes: %w", err)
+		logger.Error("Error restoring key-value store", zap.String("path", s.path), zap.Error(err))
+	}
+	s.written = size
+}
+
+// compact reescreve o arquivo só com os registros atuais, na ordem de List,
+// no formato atual, seguidos dos registros pendentes, que ainda não estão no
+// índice. O novo arquivo é escrito ao lado e renomeado, como o snapshot em
+// storage.go. Roda com writeMu, então index e keys não mudam durante a cópia.
+func (s *KVStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	writer := bufio.NewWriter(f)
+	writer.Write(encodeKVFileHeader(s.revision))
+	index := make(map[string]kvLocation, len(s.index))
+	offset := int64(kvFileHeaderSize)
+	for _, term := range s.keys {
+		location := s.index[term]
+		record, err := s.readRecord(location)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		data := encodeKVRecord(record)
+		if _, err := writer.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		index[term] = kvLocation{offset: offset, length: len(data), version: location.version, seq: location.seq}
+		offset += int64(len(data))
+	}
+	written := offset
+	for _, p := range s.pending {
+		if _, err := writer.Write(p.data); err != nil {
+			f.Close()
+			return err
+		}
+		written += int64(len(p.data))
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.file.Close()
+	s.file = file
+	s.index = index
+	s.recordHeader = kvHeaderSize
+	s.mu.Unlock()
+	logger.Info("Key-value store compacted",
+		zap.String("path", s.path),
+		zap.Int("terms", len(s.keys)),
+		zap.Int64("old_bytes", s.size),
+		zap.Int64("bytes", offset))
+	s.size = offset
+	s.written = written
+	s.dead = 0
+	return nil
+}
+
+// readRecord lê e confere o registro em location. Registros do formato
+// anterior saem com a versão do índice.
+func (s *KVStore) readRecord(location kvLocation) (kvRecord, error) {
+	data := make([]byte, location.length)
+	if _, err := s.file.ReadAt(data, location.offset); err != nil {
+		return kvRecord{}, fmt.Errorf("reading key-value store: %w", err)
+	}
+	record, err := decodeKVRecord(data, s.recordHeader)
+	if err != nil {
+		return kvRecord{}, fmt.Errorf("key-value record at offset %d: %w", location.offset, err)
+	}
+	record.version = location.version
+	return record, nil
+}
+
+// List retorna uma cópia de keys, que continua sendo alterada depois que o
+// lock é liberado.
+func (s *KVStore) List() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.keys)
+}
+
+func (s *KVStore) ListEntries() []ListEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]ListEntry, len(s.keys))
+	for i, term := range s.keys {
+		entries[i] = ListEntry{Term: term, Seq: s.index[term].seq}
+	}
+	return entries
+}
+
+func (s *KVStore) exists(term string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists := s.index[term]
+	return exists
+}
+
+func (s *KVStore) LookUp(term string) (string, uint64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	location, exists := s.index[term]
+	if !exists {
+		return "", 0, false, nil
+	}
+	record, err := s.readRecord(location)
+	if err != nil {
+		return "", 0, false, err
+	}
+	return record.definition, record.version, true, nil
+}
+
+func (s *KVStore) Insert(term, definition string) (uint64, bool, error) {
+	if s.exists(term) {
+		return 0, false, nil
+	}
+	version, err := s.write(kvPut, term, definition)
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
+}
+
+func (s *KVStore) Update(term, newDefinition string) (uint64, bool, error) {
+	if !s.exists(term) {
+		return 0, false, nil
+	}
+	version, err := s.write(kvPut, term, newDefinition)
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
+}
+
+func (s *KVStore) Delete(term string) (bool, error) {
+	if !s.exists(term) {
+		return false, nil
+	}
+	if _, err := s.write(kvDelete, term, ""); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Apply grava as alterações como um único registro kvBatch.
+func (s *KVStore) Apply(changes []Change) (uint64, error) {
+	s.writeMu.Lock()
+	batch := kvRecord{op: kvBatch, version: s.revision + 1}
+	for _, change := range changes {
+		record := kvRecord{op: kvPut, version: batch.version, term: change.Term, definition: change.Definition}
+		if change.Delete {
+			record.op, record.definition = kvDelete, ""
+		}
+		batch.batch = append(batch.batch, record)
+	}
+	return s.append(batch)
+}
+
+// Search é como Dictionary.Search. O índice da busca fica na memória, e só
+// as definições dos resultados devolvidos são lidas do disco, para os trechos.
+func (s *KVStore) Search(query string, limit int) ([]SearchResult, int, error) {
+	words := parseSearchQuery(query)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := s.search.search(words)
+	total := len(results)
+	if limit > 0 && limit < total {
+		results = results[:limit]
+	}
+	for i := range results {
+		record, err := s.readRecord(s.index[results[i].Term])
+		if err != nil {
+			return nil, 0, err
+		}
+		results[i].Snippet = snippet(record.definition, words)
+	}
+	return results, total, nil
+}
+
+// Range percorre os termos existentes quando foi chamado; os removidos
+// durante a iteração são pulados.
+func (s *KVStore) Range(fn func(term, definition string) bool) error {
+	for _, term := range s.List() {
+		definition, _, exists, err := s.LookUp(term)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if !fn(term, definition) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *KVStore) Close() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.group.idle()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
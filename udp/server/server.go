@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"net"
+	"net/http"
 	"sync"
 	"time"
 
@@ -10,16 +12,48 @@ import (
 	"go.uber.org/zap"
 )
 
-var dict = NewDictionary()
-var dictMutex sync.Mutex
+var dict Store = NewDictionary()
+var dictLocks = NewTermLocks(lockStripes)
 
-var packetStorage = utils.NewPacketStore()
+var packetStorage = utils.NewPacketStore(utils.DefaultStoreConfig())
 var packetStorageMutex sync.Mutex
 
+// Mensagens de resposta aguardando confirmação, indexadas pelo endereço do
+// cliente e pelo ID da mensagem
+type senderKey struct {
+	origin    string
+	messageID uint32
+}
+
+var senders = make(map[senderKey]*utils.Sender)
+var sendersMutex sync.Mutex
+
+// Sessões abertas por handshake, indexadas pelo endereço do cliente
+var sessions = NewSessionTable()
+
+// Maior fragmento aceito na negociação das sessões
+var maxFragment uint16 = utils.MaxPayload
+
+// Prazo de cada requisição, incluindo a espera pelos locks. Sem conexão, não
+// há o que cancelar antes disso; o cliente pode pedir um prazo menor no
+// cabeçalho Timeout.
+var requestTimeout = DefaultRequestTimeout
+
+// Intervalo entre fragmentos de respostas v1, como no protocolo original
+const legacyPacketInterval = 10 * time.Millisecond
+
 func StartServer(config *Config) error {
 	logger := utils.GetLogger()
 	wg := &sync.WaitGroup{}
 
+	store, err := OpenStore(config.Store, config.DataDir, config.SnapshotEvery)
+	if err != nil {
+		logger.Warn("Error loading dictionary", zap.Error(err))
+		return err
+	}
+	defer store.Close()
+	dict = store
+
 	addr, err := net.ResolveUDPAddr("udp", config.AddressString())
 	if err != nil {
 		logger.Warn("Error resolving address", zap.Error(err))
@@ -33,20 +67,64 @@ func StartServer(config *Config) error {
 	}
 	defer conn.Close()
 	logger.Info("Listening on: ", zap.String("address", config.AddressString()))
+	packetStorageMutex.Lock()
+	packetStorage = utils.NewPacketStore(config.StoreConfig())
+	packetStorageMutex.Unlock()
+	if config.MaxFragment > 0 && config.MaxFragment < utils.MaxPayload {
+		maxFragment = config.MaxFragment
+	}
+	if config.RequestTimeout > 0 {
+		requestTimeout = config.RequestTimeout
+	}
+	logger.Info("Fragment size limit", zap.Uint16("max_fragment", maxFragment), zap.Int("buffer_size", utils.DatagramSize(int(maxFragment))))
+	go logMetrics(config.MetricsInterval, logger)
+	go expireMessages(config.ReassemblyTimeout, logger)
+	go reapSessions(config.SessionIdleTimeout, logger)
+	go serveAdmin(config.AdminAddress, logger)
 	wg.Add(1)
-	go handleConnection(*conn, logger, wg)
+	go handleConnection(*conn, config.ARQConfig(), logger, wg)
 	wg.Wait()
 
 	return nil
 }
 
-func handleConnection(conn net.UDPConn, logger *zap.Logger, wg *sync.WaitGroup) {
+func logMetrics(interval time.Duration, logger *zap.Logger) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		utils.GetMetrics().Log(logger)
+	}
+}
+
+// expireMessages descarta periodicamente mensagens incompletas abandonadas,
+// mesmo que nenhum outro pacote chegue ao servidor.
+func expireMessages(timeout time.Duration, logger *zap.Logger) {
+	if timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		packetStorageMutex.Lock()
+		expired := packetStorage.Expire()
+		packetStorageMutex.Unlock()
+		if expired > 0 {
+			logger.Info("Expired incomplete messages", zap.Int("count", expired))
+		}
+	}
+}
+
+func handleConnection(conn net.UDPConn, arq utils.ARQConfig, logger *zap.Logger, wg *sync.WaitGroup) {
 	defer func() {
-		logger.Info("Client disconnected", zap.String("remote_addr", conn.RemoteAddr().String()))
+		logger.Info("Stopped listening", zap.String("address", conn.LocalAddr().String()))
 		conn.Close()
 		wg.Done()
 	}()
-	buffer := make([]byte, 2048)
+	// O buffer comporta o maior fragmento que uma sessão pode negociar
+	buffer := make([]byte, utils.DatagramSize(int(maxFragment)))
 	for {
 		n, remoteAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
@@ -56,14 +134,14 @@ func handleConnection(conn net.UDPConn, logger *zap.Logger, wg *sync.WaitGroup)
 		data := make([]byte, n)
 		copy(data, buffer[:n])
 		logger.Info("Received data", zap.ByteString("data", data))
-		wg.Add(1)
-		go processPacket(data, &conn, remoteAddr, logger, wg)
+		// Fragmentos são armazenados na ordem de chegada; apenas o
+		// processamento da mensagem completa ocorre em paralelo
+		processPacket(data, &conn, remoteAddr, arq, logger, wg)
 	}
 
 }
 
-func processPacket(data []byte, conn *net.UDPConn, remoteAddr *net.UDPAddr, logger *zap.Logger, wg *sync.WaitGroup) {
-	defer wg.Done()
+func processPacket(data []byte, conn *net.UDPConn, remoteAddr *net.UDPAddr, arq utils.ARQConfig, logger *zap.Logger, wg *sync.WaitGroup) {
 	defer logger.Info("Finished processing data", zap.String("remote_addr", remoteAddr.String()))
 
 	packet, err := utils.ParsePacket(data)
@@ -71,67 +149,269 @@ func processPacket(data []byte, conn *net.UDPConn, remoteAddr *net.UDPAddr, logg
 		logger.Warn("Error parsing packet", zap.Error(err))
 		return
 	}
-	logger.Info("Parsed packet", zap.Uint16("control", packet.Control), zap.Uint16("length", packet.Length), zap.ByteString("payload", packet.Payload), zap.Uint16("crc", packet.CRC))
+	logger.Info("Parsed packet", zap.Uint32("message_id", packet.MessageID), zap.Uint16("index", packet.Index), zap.Uint16("total", packet.Total), zap.Uint8("version", packet.Version), zap.Uint8("flags", packet.Flags), zap.ByteString("payload", packet.Payload), zap.Stringer("checksum", packet.Checksum), zap.Uint32("crc", packet.CRC))
 
-	payload, complete := verifyPacket(packet, packetStorage, &packetStorageMutex, remoteAddr, logger)
+	send := utils.SimulateLoss(arq.LossRate, func(p utils.Packet) error {
+		_, err := conn.WriteToUDP(p.Bytes(), remoteAddr)
+		return err
+	})
+
+	if packet.IsLegacy() {
+		payload, complete := verifyLegacyPacket(packet, packetStorage, &packetStorageMutex, remoteAddr, logger)
+		if complete {
+			wg.Add(1)
+			go respondLegacy(payload, send, remoteAddr, logger, wg)
+		}
+		return
+	}
+
+	if !utils.NewCRC().ValidatePacket(packet) {
+		logger.Info("Packet CRC not valid", zap.String("remote_addr", remoteAddr.String()))
+		return
+	}
+
+	origin := remoteAddr.String()
+	switch {
+	case packet.Has(utils.FlagProbe):
+		// Sondagens não dependem de sessão: o cliente as envia antes do SYN
+		if int(maxFragment) >= len(packet.Payload) {
+			if err := send(utils.NewProbeAckPacket(packet)); err != nil {
+				logger.Warn("Error sending probe ACK", zap.Error(err))
+			}
+		}
+		return
+	case packet.Has(utils.FlagSYN):
+		handleSyn(packet, origin, arq, send, logger)
+		return
+	case packet.Has(utils.FlagFIN):
+		if s, closed := sessions.Close(origin, packet.MessageID); closed {
+			logger.Info("Session closed", zap.Uint32("session_id", s.ID), zap.String("remote_addr", origin))
+		}
+		// Responde mesmo a FINs repetidos, caso o FIN+ACK anterior tenha se perdido
+		if err := send(utils.NewFinAckPacket(packet.MessageID, packet.Checksum)); err != nil {
+			logger.Warn("Error sending FIN+ACK", zap.Error(err))
+		}
+		return
+	case packet.Has(utils.FlagRST):
+		if s, closed := sessions.Close(origin, packet.MessageID); closed {
+			logger.Info("Session reset by client", zap.Uint32("session_id", s.ID), zap.String("remote_addr", origin))
+		}
+		return
+	case packet.Has(utils.FlagACK) && sessions.Establish(origin, packet.MessageID):
+		logger.Info("Session established", zap.Uint32("session_id", packet.MessageID), zap.String("remote_addr", origin))
+		return
+	}
+
+	session, exists := sessions.Touch(origin)
+	if !exists {
+		logger.Info("Packet without session, sending RST", zap.String("remote_addr", origin), zap.Uint32("message_id", packet.MessageID))
+		if err := send(utils.NewRstPacket(packet.MessageID, packet.Checksum)); err != nil {
+			logger.Warn("Error sending RST", zap.Error(err))
+		}
+		return
+	}
+
+	if !packet.IsData() {
+		dispatchAck(packet, remoteAddr, logger)
+		return
+	}
+
+	payload, complete := verifyPacket(packet, packetStorage, &packetStorageMutex, remoteAddr, send, arq.Window, logger)
 	if !complete {
 		return
 	}
 
-	responseData, err := processData(payload, logger)
+	// A resposta usa o checksum e o tamanho de fragmento negociados na sessão
+	arq.Checksum = session.Checksum
+	arq.FragmentSize = int(session.MaxFragment)
+	wg.Add(1)
+	go respond(payload, packet.MessageID, packet.Window, session.congestion, send, remoteAddr, arq, logger, wg)
+}
+
+func respond(payload []byte, messageID uint32, peerWindow uint16, congestion *utils.CongestionControl, send func(utils.Packet) error, remoteAddr *net.UDPAddr, arq utils.ARQConfig, logger *zap.Logger, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	response, err := processData(payload, logger)
 	if err != nil {
 		logger.Warn("Error processing data", zap.Error(err))
 	}
-	responsePacket := utils.NewPacket(responseData)
-	for i := range responsePacket {
-		_, err = conn.WriteToUDP(responsePacket[i].Bytes(), remoteAddr)
-		if err != nil {
-			logger.Warn("Error writing to UDP connection", zap.Error(err))
+
+	// A resposta reutiliza o ID da requisição para que o cliente possa associá-las
+	packets, err := utils.NewPacket(messageID, response.Bytes(), arq.FragmentSize)
+	if err != nil {
+		logger.Warn("Response too large", zap.String("remote_addr", remoteAddr.String()), zap.Uint32("message_id", messageID), zap.Error(err))
+		response = utils.HTTPResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       "Response too large to send: " + err.Error(),
+		}
+		if packets, err = utils.NewPacket(messageID, response.Bytes(), arq.FragmentSize); err != nil {
+			return
+		}
+	}
+	sender := utils.NewSender(packets, send, arq)
+	sender.SetPeerWindow(peerWindow)
+	sender.SetCongestionControl(congestion)
+	key := senderKey{origin: remoteAddr.String(), messageID: messageID}
+	sendersMutex.Lock()
+	senders[key] = sender
+	sendersMutex.Unlock()
+	defer func() {
+		sendersMutex.Lock()
+		if senders[key] == sender {
+			delete(senders, key)
+		}
+		sendersMutex.Unlock()
+	}()
+
+	if err := sender.Run(); err != nil {
+		logger.Warn("Error delivering response", zap.String("remote_addr", remoteAddr.String()), zap.Uint32("message_id", messageID), zap.Error(err))
+	}
+}
+
+// respondLegacy responde a clientes v1, que não confirmam fragmentos: a
+// resposta é enviada uma única vez, com um pequeno intervalo entre fragmentos.
+func respondLegacy(payload []byte, send func(utils.Packet) error, remoteAddr *net.UDPAddr, logger *zap.Logger, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	response, err := processData(payload, logger)
+	if err != nil {
+		logger.Warn("Error processing data", zap.Error(err))
+	}
+	// Clientes v1 esperam o formato antigo, sem cabeçalhos
+	packets, err := utils.NewLegacyPacket([]byte(response.LegacyString()))
+	if err != nil {
+		logger.Warn("Response too large", zap.String("remote_addr", remoteAddr.String()), zap.Error(err))
+		return
+	}
+	for i, p := range packets {
+		if err := send(p); err != nil {
+			logger.Warn("Error writing to UDP connection", zap.String("remote_addr", remoteAddr.String()), zap.Int("packet_index", i), zap.Error(err))
 		}
-		time.Sleep(10 * time.Millisecond) // Small delay to avoid packet loss
+		time.Sleep(legacyPacketInterval)
+	}
+}
+
+// handleSyn abre (ou reabre) a sessão do cliente e responde com SYN+ACK.
+func handleSyn(packet utils.Packet, origin string, arq utils.ARQConfig, send func(utils.Packet) error, logger *zap.Logger) {
+	proposed, err := utils.ParseSessionParams(packet)
+	if err != nil {
+		logger.Info("Invalid SYN", zap.String("remote_addr", origin), zap.Error(err))
+		return
 	}
+	if proposed.MaxFragment == 0 || proposed.MaxFragment > maxFragment {
+		proposed.MaxFragment = maxFragment
+	}
+	session, created := sessions.Open(origin, proposed, arq)
+	if created {
+		logger.Info("Session opened",
+			zap.Uint32("session_id", session.ID),
+			zap.String("remote_addr", origin),
+			zap.Uint16("max_fragment", session.MaxFragment),
+			zap.Uint16("window", session.Window),
+			zap.Stringer("checksum", session.Checksum))
+	}
+	reply := session.Params()
+	reply.Window = arq.Window
+	if err := send(utils.NewSynAckPacket(reply)); err != nil {
+		logger.Warn("Error sending SYN+ACK", zap.Error(err))
+	}
+}
+
+func dispatchAck(packet utils.Packet, remoteAddr *net.UDPAddr, logger *zap.Logger) {
+	sendersMutex.Lock()
+	sender, exists := senders[senderKey{origin: remoteAddr.String(), messageID: packet.MessageID}]
+	sendersMutex.Unlock()
+	if !exists {
+		logger.Info("No pending response for acknowledgement", zap.String("remote_addr", remoteAddr.String()), zap.Uint32("message_id", packet.MessageID))
+		return
+	}
+	sender.HandlePacket(packet)
 }
 
-func verifyPacket(packet utils.Packet, ps *utils.PacketStore, mux *sync.Mutex, remoteAddr *net.UDPAddr, logger *zap.Logger) ([]byte, bool) {
+// verifyPacket guarda um fragmento de dados já validado pelo checksum.
+func verifyPacket(packet utils.Packet, ps *utils.PacketStore, mux *sync.Mutex, remoteAddr *net.UDPAddr, send func(utils.Packet) error, window uint16, logger *zap.Logger) ([]byte, bool) {
 	defer logger.Info("Finished processing data", zap.String("remote_addr", remoteAddr.String()))
 
+	utils.GetMetrics().AddReceived()
+	if packet.Total == 0 || packet.Index >= packet.Total {
+		logger.Info("Packet index out of range", zap.String("remote_addr", remoteAddr.String()), zap.Uint16("index", packet.Index), zap.Uint16("total", packet.Total))
+		return []byte{}, false
+	}
+	origin := remoteAddr.String()
+	id := packet.MessageID
+
+	mux.Lock()
+	if ps.IsCompleted(origin, id) {
+		mux.Unlock()
+		logger.Info("Packet of already delivered message", zap.String("remote_addr", origin), zap.Uint32("message_id", id), zap.Uint16("index", packet.Index))
+		if err := send(utils.NewAckPacket(id, packet.Index, window, packet.Checksum)); err != nil {
+			logger.Warn("Error sending ACK", zap.Error(err))
+		}
+		return []byte{}, false
+	}
+	if base := ps.Base(origin, id); int(packet.Index) >= int(base)+int(window) {
+		mux.Unlock()
+		logger.Info("Packet outside receive window", zap.String("remote_addr", origin), zap.Uint32("message_id", id), zap.Uint16("index", packet.Index), zap.Uint16("base", base))
+		return []byte{}, false
+	}
+	added, gaps := ps.AddPacket(origin, packet)
+	complete := added && ps.IsComplete(origin, id)
+	payload := []byte{}
+	if complete {
+		logger.Info("Packet complete", zap.String("remote_addr", origin), zap.Uint32("message_id", id))
+		payload = ps.AssemblePayload(origin, id)
+		logger.Info("Complete payload received", zap.ByteString("payload", payload))
+		ps.MarkCompleted(origin, id)
+	}
+	mux.Unlock()
+
+	if err := send(utils.NewAckPacket(id, packet.Index, window, packet.Checksum)); err != nil {
+		logger.Warn("Error sending ACK", zap.Error(err))
+	}
+	for _, gap := range gaps {
+		logger.Info("Requesting missing packet", zap.String("remote_addr", origin), zap.Uint32("message_id", id), zap.Uint16("index", gap))
+		if err := send(utils.NewNackPacket(id, gap, window, packet.Checksum)); err != nil {
+			logger.Warn("Error sending NACK", zap.Error(err))
+		}
+	}
+
+	return payload, complete
+}
+
+// verifyLegacyPacket remonta mensagens v1. Elas não têm ID, então cada origem
+// tem no máximo uma mensagem em remontagem, e nenhum ACK é enviado.
+func verifyLegacyPacket(packet utils.Packet, ps *utils.PacketStore, mux *sync.Mutex, remoteAddr *net.UDPAddr, logger *zap.Logger) ([]byte, bool) {
 	crc := utils.NewCRC()
 	if !crc.ValidatePacket(packet) {
 		logger.Info("Packet CRC not valid", zap.String("remote_addr", remoteAddr.String()))
 		return []byte{}, false
 	}
-	payload := packet.Payload
-
-	if packet.Length > 0 {
-		mux.Lock()
-		ps.AddPacket(remoteAddr.String(), packet)
-		if ps.IsComplete(remoteAddr.String()) {
-			logger.Info("Packet complete", zap.String("remote_addr", remoteAddr.String()))
-			packets := ps.Packets[remoteAddr.String()]
-			payload = utils.GetCompletePayload(packets)
-			logger.Info("Complete payload received", zap.ByteString("payload", payload))
-			delete(ps.Packets, remoteAddr.String())
-			mux.Unlock()
-		} else {
-			mux.Unlock()
-			return []byte{}, false
-		}
-	}
+	utils.GetMetrics().AddReceived()
+	origin := remoteAddr.String()
 
+	mux.Lock()
+	defer mux.Unlock()
+	added, _ := ps.AddPacket(origin, packet)
+	if !added || !ps.IsComplete(origin, packet.MessageID) {
+		return []byte{}, false
+	}
+	payload := ps.AssemblePayload(origin, packet.MessageID)
+	logger.Info("Complete legacy payload received", zap.String("remote_addr", origin), zap.ByteString("payload", payload))
+	ps.MarkCompleted(origin, packet.MessageID)
 	return payload, true
 }
 
-func processData(data []byte, logger *zap.Logger) ([]byte, error) {
+func processData(data []byte, logger *zap.Logger) (utils.HTTPResponse, error) {
 	logger.Info("Processing data", zap.ByteString("data", data))
 
 	request, err := utils.ParseHTTPRequest(data)
 	if err != nil {
 		response := utils.HTTPResponse{
 			StatusCode: 400,
-			Message:    "Invalid request format: " + err.Error(),
+			Body:       "Invalid request format: " + err.Error(),
 		}
 		logger.Warn("Invalid request", zap.Error(err))
-		return response.Bytes(), err
+		return response, err
 	}
 
 	logger.Info("Parsed request",
@@ -145,7 +425,9 @@ func processData(data []byte, logger *zap.Logger) ([]byte, error) {
 		Use functions from server/utils.go as needed.
 		==================================================
 	*/
-	response := ProcessDictCommand(request, dict, &dictMutex)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	response := ProcessDictCommand(ctx, request, dict, dictLocks)
 
 	/*
 		==================================================
@@ -153,5 +435,8 @@ func processData(data []byte, logger *zap.Logger) ([]byte, error) {
 		==================================================
 	*/
 
-	return response.Bytes(), nil
+	if id := request.Header.Get(utils.HeaderRequestID); id != "" {
+		response.SetHeader(utils.HeaderRequestID, id)
+	}
+	return response, nil
 }
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"udp/utils"
+
+	"go.uber.org/zap"
+)
+
+type SessionState string
+
+const (
+	SessionSynReceived SessionState = "SYN_RECEIVED"
+	SessionEstablished SessionState = "ESTABLISHED"
+)
+
+// Session guarda o estado negociado com um cliente no handshake.
+type Session struct {
+	ID           uint32             `json:"id"`
+	RemoteAddr   string             `json:"remote_addr"`
+	State        SessionState       `json:"state"`
+	MaxFragment  uint16             `json:"max_fragment"`
+	Window       uint16             `json:"window"`
+	Checksum     utils.ChecksumType `json:"-"`
+	CreatedAt    time.Time          `json:"created_at"`
+	LastActivity time.Time          `json:"last_activity"`
+
+	// Compartilhado por todas as respostas enviadas na sessão
+	congestion *utils.CongestionControl
+}
+
+func (s Session) Params() utils.SessionParams {
+	return utils.SessionParams{
+		SessionID:   s.ID,
+		MaxFragment: s.MaxFragment,
+		Window:      s.Window,
+		Checksum:    s.Checksum,
+	}
+}
+
+// SessionTable indexa as sessões pelo endereço do cliente.
+type SessionTable struct {
+	mux      sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewSessionTable() *SessionTable {
+	return &SessionTable{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Open cria uma sessão para o endereço, substituindo uma anterior, com o
+// controle de congestionamento zerado. Um SYN repetido (SYN+ACK perdido)
+// devolve a sessão ainda não confirmada.
+func (t *SessionTable) Open(remoteAddr string, params utils.SessionParams, arq utils.ARQConfig) (Session, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	now := time.Now()
+	if s, exists := t.sessions[remoteAddr]; exists && s.State == SessionSynReceived {
+		s.LastActivity = now
+		return *s, false
+	}
+	s := &Session{
+		ID:           rand.Uint32(),
+		RemoteAddr:   remoteAddr,
+		State:        SessionSynReceived,
+		MaxFragment:  params.MaxFragment,
+		Window:       params.Window,
+		Checksum:     params.Checksum,
+		CreatedAt:    now,
+		LastActivity: now,
+		congestion:   utils.NewCongestionControl(arq),
+	}
+	t.sessions[remoteAddr] = s
+	return *s, true
+}
+
+// Touch registra atividade na sessão e a confirma, já que o cliente só envia
+// dados depois de receber o SYN+ACK. Retorna false se não houver sessão.
+func (t *SessionTable) Touch(remoteAddr string) (Session, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s, exists := t.sessions[remoteAddr]
+	if !exists {
+		return Session{}, false
+	}
+	s.LastActivity = time.Now()
+	s.State = SessionEstablished
+	return *s, true
+}
+
+// Establish trata o ACK final do handshake.
+func (t *SessionTable) Establish(remoteAddr string, id uint32) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s, exists := t.sessions[remoteAddr]
+	if !exists || s.ID != id || s.State != SessionSynReceived {
+		return false
+	}
+	s.State = SessionEstablished
+	s.LastActivity = time.Now()
+	return true
+}
+
+func (t *SessionTable) Close(remoteAddr string, id uint32) (Session, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s, exists := t.sessions[remoteAddr]
+	if !exists || s.ID != id {
+		return Session{}, false
+	}
+	delete(t.sessions, remoteAddr)
+	return *s, true
+}
+
+// Reap remove as sessões sem atividade há mais de idle.
+func (t *SessionTable) Reap(idle time.Duration) []Session {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	var reaped []Session
+	now := time.Now()
+	for addr, s := range t.sessions {
+		if now.Sub(s.LastActivity) > idle {
+			reaped = append(reaped, *s)
+			delete(t.sessions, addr)
+		}
+	}
+	return reaped
+}
+
+// List retorna uma cópia das sessões ativas, das mais antigas às mais novas.
+func (t *SessionTable) List() []Session {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	list := make([]Session, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+func reapSessions(idle time.Duration, logger *zap.Logger) {
+	if idle <= 0 {
+		return
+	}
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, s := range sessions.Reap(idle) {
+			logger.Info("Idle session reaped",
+				zap.Uint32("session_id", s.ID),
+				zap.String("remote_addr", s.RemoteAddr),
+				zap.Duration("idle", time.Since(s.LastActivity)))
+		}
+	}
+}
+
+type sessionView struct {
+	Session
+	Checksum string `json:"checksum"`
+	IdleFor  string `json:"idle_for"`
+	Cwnd     int    `json:"cwnd"`
+	SRTT     string `json:"srtt"`
+	RTO      string `json:"rto"`
+}
+
+// serveAdmin expõe as sessões ativas em GET /sessions, em JSON.
+func serveAdmin(address string, logger *zap.Logger) {
+	if address == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		list := sessions.List()
+		views := make([]sessionView, len(list))
+		for i, s := range list {
+			congestion := s.congestion.State()
+			views[i] = sessionView{
+				Session:  s,
+				Checksum: s.Checksum.String(),
+				IdleFor:  time.Since(s.LastActivity).Round(time.Millisecond).String(),
+				Cwnd:     congestion.Cwnd,
+				SRTT:     congestion.SRTT.String(),
+				RTO:      congestion.RTO.String(),
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(views)
+	})
+	logger.Info("Admin view listening", zap.String("address", address))
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logger.Warn("Error serving admin view", zap.Error(err))
+	}
+}
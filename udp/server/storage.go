@@ -0,0 +1,370 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+// Persistência do dicionário em disco. Cada alteração é acrescentada ao
+// write-ahead log (wal.log), uma linha JSON por operação, e sincronizada com
+// fsync antes de ser aplicada na memória; as alterações que chegam durante um
+// fsync são confirmadas juntas pelo próximo (ver commit.go). A cada
+// snapshotEvery operações o
+// conteúdo completo é gravado em snapshot.json e o log recomeça vazio. Na
+// inicialização o snapshot é carregado e o log é reaplicado sobre ele. Os
+// registros e o snapshot guardam a versão de cada termo.
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.json"
+)
+
+// Operações registradas no log entre dois snapshots
+const DefaultSnapshotEvery = 1000
+
+// syncFile é o fsync das gravações do log e do armazenamento chave-valor. Os
+// testes o substituem para simular falhas do disco.
+var syncFile = (*os.File).Sync
+
+type walOp string
+
+const (
+	walInsert walOp = "insert"
+	walUpdate walOp = "update"
+	walDelete walOp = "delete"
+	walBatch  walOp = "batch" // Alterações de Store.Apply, numa única linha
+)
+
+type walRecord struct {
+	Op         walOp       `json:"op"`
+	Term       string      `json:"term,omitempty"`
+	Definition string      `json:"definition,omitempty"`
+	Version    uint64      `json:"version,omitempty"` // Ausente nos logs anteriores às versões
+	Batch      []walRecord `json:"batch,omitempty"`   // Só em walBatch, sem versão própria
+}
+
+type snapshotEntry struct {
+	Term       string `json:"term"`
+	Definition string `json:"definition"`
+	Version    uint64 `json:"version,omitempty"`
+}
+
+// snapshotFile é o conteúdo de snapshot.json. Revision guarda a maior versão
+// já atribuída, que pode ser de um termo removido. Os snapshots anteriores às
+// versões eram só a lista de termos e ainda são aceitos.
+type snapshotFile struct {
+	Revision uint64          `json:"revision"`
+	Terms    []snapshotEntry `json:"terms"`
+}
+
+// Storage roda com o writeMu do dicionário, exceto sync, que o groupCommit
+// chama sem o lock.
+type Storage struct {
+	dir           string
+	wal           *os.File
+	walSize       int64        // Fim do último registro confirmado pelo fsync
+	written       int64        // Fim do último registro escrito, confirmado ou não
+	pending       []walPending // Registros escritos e ainda não confirmados, em ordem
+	group         *groupCommit
+	dict          *Dictionary
+	records       int // Operações no log desde o último snapshot
+	snapshotEvery int
+	failed        error // Impede novas gravações se o log não pôde ser restaurado
+}
+
+type walPending struct {
+	record walRecord
+	data   []byte
+}
+
+// OpenDictionary carrega o dicionário salvo em dir, criando o diretório se
+// necessário, e passa a registrar nele as alterações.
+func OpenDictionary(dir string, snapshotEvery int) (*Dictionary, error) {
+	if snapshotEvery <= 0 {
+		snapshotEvery = DefaultSnapshotEvery
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating data dir: %w", err)
+	}
+
+	d := NewDictionary()
+	snapshot, err := loadSnapshot(filepath.Join(dir, snapshotFileName))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range snapshot.Terms {
+		d.apply(walRecord{Op: walInsert, Term: e.Term, Definition: e.Definition, Version: e.Version})
+	}
+	d.revision = max(d.revision, snapshot.Revision)
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening write-ahead log: %w", err)
+	}
+	records, size, err := replayWAL(wal, d)
+	if err != nil {
+		wal.Close()
+		return nil, err
+	}
+	if _, err := wal.Seek(size, io.SeekStart); err != nil {
+		wal.Close()
+		return nil, err
+	}
+
+	d.storage = &Storage{
+		dir:           dir,
+		wal:           wal,
+		walSize:       size,
+		written:       size,
+		group:         newGroupCommit(&d.writeMu),
+		dict:          d,
+		records:       records,
+		snapshotEvery: snapshotEvery,
+	}
+	logger.Info("Dictionary loaded from disk",
+		zap.String("data_dir", dir),
+		zap.Int("snapshot_terms", len(snapshot.Terms)),
+		zap.Int("wal_records", records),
+		zap.Int("terms", len(d.keys)),
+		zap.Uint64("revision", d.revision))
+	return d, nil
+}
+
+func loadSnapshot(path string) (snapshotFile, error) {
+	var snapshot snapshotFile
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return snapshot, fmt.Errorf("reading snapshot: %w", err)
+	}
+	target := any(&snapshot)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		target = &snapshot.Terms
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return snapshot, fmt.Errorf("decoding snapshot %s: %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// replayWAL aplica os registros do log e retorna quantos foram lidos e o
+// tamanho da parte válida. Uma última linha incompleta (queda durante a
+// escrita) é descartada; um registro inválido antes dela é erro.
+func replayWAL(wal *os.File, d *Dictionary) (int, int64, error) {
+	reader := bufio.NewReader(wal)
+	var records int
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				logger.Warn("Discarding incomplete write-ahead log record", zap.Int64("offset", valid), zap.Int("bytes", len(line)))
+				if err := wal.Truncate(valid); err != nil {
+					return 0, 0, fmt.Errorf("truncating write-ahead log: %w", err)
+				}
+			}
+			return records, valid, nil
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("reading write-ahead log: %w", err)
+		}
+		var record walRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &record); err != nil {
+			return 0, 0, fmt.Errorf("write-ahead log record at offset %d: %w", valid, err)
+		}
+		d.apply(record)
+		records++
+		valid += int64(len(line))
+	}
+}
+
+// append escreve o registro no fim do log e o deixa pendente até o fsync. Se
+// a escrita falhar, o que foi escrito dela é removido do log; se nem isso for
+// possível, o log fica num estado desconhecido e as próximas gravações são
+// recusadas.
+func (s *Storage) append(record walRecord) error {
+	if s.failed != nil {
+		return s.failed
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := s.wal.Write(data); err != nil {
+		s.discard(s.written)
+		return fmt.Errorf("writing write-ahead log: %w", err)
+	}
+	s.written += int64(len(data))
+	s.pending = append(s.pending, walPending{record: record, data: data})
+	return nil
+}
+
+func (s *Storage) sync() error {
+	if err := syncFile(s.wal); err != nil {
+		return fmt.Errorf("syncing write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// commit aplica os registros confirmados na memória, na ordem do log, e grava
+// um snapshot quando chega a hora.
+func (s *Storage) commit(n int) error {
+	d := s.dict
+	d.mu.Lock()
+	for _, p := range s.pending[:n] {
+		d.apply(p.record)
+		s.walSize += int64(len(p.data))
+	}
+	d.mu.Unlock()
+	s.pending = s.pending[n:]
+	s.records += n
+	if s.records >= s.snapshotEvery {
+		return s.compact(d)
+	}
+	return nil
+}
+
+// abort descarta os registros pendentes depois de uma falha no fsync. Os bytes
+// já estão no arquivo e seriam reaplicados na próxima leitura.
+func (s *Storage) abort() {
+	s.drop()
+	s.discard(s.walSize)
+}
+
+// drop esquece os registros pendentes, que não serão aplicados, e devolve
+// suas versões.
+func (s *Storage) drop() {
+	if len(s.pending) == 0 {
+		return
+	}
+	s.dict.revision = s.pending[0].record.Version - 1
+	s.pending = nil
+}
+
+// discard volta o log ao fim do último registro que deve ser mantido.
+func (s *Storage) discard(size int64) {
+	err := s.wal.Truncate(size)
+	if err == nil {
+		_, err = s.wal.Seek(size, io.SeekStart)
+	}
+	if err == nil {
+		err = s.wal.Sync()
+	}
+	if err != nil {
+		s.failed = fmt.Errorf("write-ahead log could not be restored after a failed write, refusing further writes: %w", err)
+		logger.Error("Error restoring write-ahead log", zap.String("dir", s.dir), zap.Error(err))
+	}
+	s.written = size
+}
+
+// snapshot grava o dicionário inteiro e esvazia o log. O arquivo é escrito
+// ao lado e renomeado, para que uma queda no meio mantenha o snapshot
+// anterior; reaplicar o log sobre um snapshot que já o contém não muda nada.
+// Roda com d.mu, então só contém os registros já confirmados.
+func (s *Storage) snapshot(d *Dictionary) error {
+	entries := make([]snapshotEntry, len(d.keys))
+	for i, term := range d.keys {
+		entry := d.terms[term]
+		entries[i] = snapshotEntry{Term: term, Definition: entry.definition, Version: entry.version}
+	}
+	data, err := json.Marshal(snapshotFile{Revision: d.revision, Terms: entries})
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, snapshotFileName)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating snapshot: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("syncing snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replacing snapshot: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	if err := s.wal.Truncate(0); err != nil {
+		return fmt.Errorf("truncating write-ahead log: %w", err)
+	}
+	logger.Info("Dictionary snapshot written",
+		zap.String("path", path),
+		zap.Int("terms", len(entries)),
+		zap.Int("compacted_records", s.records))
+	s.walSize = 0
+	s.written = 0
+	s.records = 0
+	return nil
+}
+
+// restart volta a escrever no log, depois do snapshot, os registros pendentes,
+// que não estão na memória nem no snapshot. O próximo fsync os confirma.
+func (s *Storage) restart() error {
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	for _, p := range s.pending {
+		if _, err := s.wal.Write(p.data); err != nil {
+			return err
+		}
+		s.written += int64(len(p.data))
+	}
+	return nil
+}
+
+// compact grava um snapshot; se falhar, o log continua com todas as
+// operações e a compactação é tentada de novo na próxima alteração. Se os
+// registros pendentes não puderem voltar ao log, eles são descartados e as
+// próximas gravações, recusadas.
+func (s *Storage) compact(d *Dictionary) error {
+	d.mu.RLock()
+	err := s.snapshot(d)
+	d.mu.RUnlock()
+	if err != nil {
+		logger.Warn("Error writing dictionary snapshot", zap.String("data_dir", s.dir), zap.Error(err))
+		return nil
+	}
+	if err := s.restart(); err != nil {
+		s.failed = fmt.Errorf("write-ahead log could not be rewritten after a snapshot, refusing further writes: %w", err)
+		logger.Error("Error rewriting write-ahead log", zap.String("dir", s.dir), zap.Error(err))
+		s.drop()
+		return s.failed
+	}
+	return nil
+}
+
+// syncDir garante que a renomeação do snapshot sobreviva a uma queda.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return fmt.Errorf("syncing data dir: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"udp/utils"
+)
+
+// Exportação e importação do dicionário inteiro. A exportação percorre os
+// termos com Store.Range e os escreve à medida que avança; a importação lê as
+// linhas uma a uma, confere cada uma e grava todas as válidas de uma só vez
+// com Store.Apply, com os locks de todos os termos importados. Assim, depois
+// de uma queda, ou a importação inteira aparece ou nada aparece.
+//
+// Linhas inválidas (sem termo ou sem definição, com colunas a mais ou a
+// menos, ou com JSON inválido no JSONL) não interrompem a importação: vão para
+// o relatório, com o número da linha, e as outras são importadas. Só um erro
+// que impede continuar a leitura, como JSON ou aspas do CSV malformados, a
+// interrompe sem importar nada.
+
+// Formatos de exportação e importação
+const (
+	FormatJSON  = "json"  // Um array de objetos {"termo": ..., "definicao": ...}
+	FormatCSV   = "csv"   // Colunas termo e definicao, com cabeçalho
+	FormatJSONL = "jsonl" // Um objeto {"termo": ..., "definicao": ...} por linha
+)
+
+// O que a importação faz com um termo que já existe no dicionário, ou que
+// aparece mais de uma vez no arquivo
+const (
+	ConflictSkip      = "skip"      // Mantém a definição atual (padrão)
+	ConflictOverwrite = "overwrite" // Substitui pela do arquivo; a última vence
+	ConflictFail      = "fail"      // Não importa nada
+)
+
+var (
+	// errMalformedImport é a causa de um erro que impede continuar a leitura
+	errMalformedImport = errors.New("malformed import")
+	// errImportConflict é retornado por ImportTerms com ConflictFail quando
+	// algum termo já existe ou alguma linha é inválida
+	errImportConflict = errors.New("import aborted")
+)
+
+// Entry é um termo exportado ou importado.
+type Entry struct {
+	Term       string `json:"termo"`
+	Definition string `json:"definicao"`
+}
+
+// ImportReport é o resultado de uma importação. Inserted e Overwritten contam
+// termos, e não linhas: com ConflictOverwrite, um termo repetido no arquivo
+// conta uma vez só, como inserido ou substituído conforme existisse ou não
+// antes da importação. Com ConflictFail e algum erro, nada é gravado e as
+// contagens ficam zeradas.
+type ImportReport struct {
+	Inserted    int        `json:"inseridos"`
+	Overwritten int        `json:"substituidos"`
+	Skipped     int        `json:"ignorados"`
+	Errors      []RowError `json:"erros,omitempty"`
+	Version     uint64     `json:"versao,omitempty"` // Versão dos termos gravados
+}
+
+// RowError é uma linha que não foi importada. No JSON, Row é a posição do
+// objeto no array, a partir de 1.
+type RowError struct {
+	Row   int    `json:"linha"`
+	Term  string `json:"termo,omitempty"`
+	Error string `json:"erro"`
+}
+
+// ParseFormat confere o formato, aceitando também maiúsculas e "ndjson".
+func ParseFormat(format string) (string, error) {
+	switch format = strings.ToLower(format); format {
+	case FormatJSON, FormatCSV, FormatJSONL:
+		return format, nil
+	case "ndjson":
+		return FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("unknown format %q (use %s, %s or %s)", format, FormatJSON, FormatCSV, FormatJSONL)
+	}
+}
+
+// FormatFromFileName deduz o formato da extensão do arquivo.
+func FormatFromFileName(name string) (string, error) {
+	return ParseFormat(strings.TrimPrefix(filepath.Ext(name), "."))
+}
+
+// ParseConflict confere a política de conflito; vazia é ConflictSkip.
+func ParseConflict(policy string) (string, error) {
+	switch policy = strings.ToLower(policy); policy {
+	case "":
+		return ConflictSkip, nil
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q (use %s, %s or %s)", policy, ConflictSkip, ConflictOverwrite, ConflictFail)
+	}
+}
+
+// ExportTerms escreve em w todos os termos do dicionário, na ordem de
+// inserção, e retorna quantos escreveu. O formato já deve ter passado por
+// ParseFormat.
+func ExportTerms(w io.Writer, dict Store, format string) (int, error) {
+	count := 0
+	var writeErr error
+	if format == FormatCSV {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"termo", "definicao"})
+		err := dict.Range(func(term, definition string) bool {
+			if writeErr = writer.Write([]string{term, definition}); writeErr != nil {
+				return false
+			}
+			count++
+			return true
+		})
+		writer.Flush()
+		return count, errors.Join(err, writeErr, writer.Error())
+	}
+
+	var line bytes.Buffer
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false)
+	if format == FormatJSON {
+		_, writeErr = io.WriteString(w, "[")
+	}
+	err := dict.Range(func(term, definition string) bool {
+		if writeErr != nil {
+			return false
+		}
+		line.Reset()
+		if format == FormatJSON {
+			if count > 0 {
+				line.WriteString(",")
+			}
+			line.WriteString("\n  ")
+		}
+		encoder.Encode(Entry{Term: term, Definition: definition})
+		if format == FormatJSON {
+			line.Truncate(line.Len() - 1)
+		}
+		_, writeErr = w.Write(line.Bytes())
+		count++
+		return writeErr == nil
+	})
+	if format == FormatJSON && writeErr == nil {
+		_, writeErr = io.WriteString(w, "\n]\n")
+	}
+	return count, errors.Join(err, writeErr)
+}
+
+// exportContentType é o Content-Type da exportação no formato.
+func exportContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatJSONL:
+		return "application/x-ndjson"
+	default:
+		return "application/json"
+	}
+}
+
+// importRow é uma linha válida da importação.
+type importRow struct {
+	Entry
+	row int
+}
+
+// readEntries lê todas as linhas de r no formato. As inválidas vão para
+// report.Errors.
+func readEntries(r io.Reader, format string, report *ImportReport) ([]importRow, error) {
+	var rows []importRow
+	add := func(row int, entry Entry) {
+		entry.Term = strings.TrimSpace(entry.Term)
+		switch {
+		case entry.Term == "":
+			report.Errors = append(report.Errors, RowError{Row: row, Error: "missing term"})
+		case strings.TrimSpace(entry.Definition) == "":
+			report.Errors = append(report.Errors, RowError{Row: row, Term: entry.Term, Error: "missing definition"})
+		default:
+			rows = append(rows, importRow{Entry: entry, row: row})
+		}
+	}
+
+	switch format {
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		for first := true; ; first = false {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return rows, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", errMalformedImport, err)
+			}
+			row, _ := reader.FieldPos(0)
+			if first {
+				// O cabeçalho é opcional, e planilhas costumam começar com um BOM
+				record[0] = strings.TrimPrefix(record[0], "\ufeff")
+				if isCSVHeader(record) {
+					continue
+				}
+			}
+			if len(record) != 2 {
+				report.Errors = append(report.Errors, RowError{
+					Row:   row,
+					Term:  strings.TrimSpace(record[0]),
+					Error: fmt.Sprintf("expected 2 columns (term, definition), got %d", len(record)),
+				})
+				continue
+			}
+			add(row, Entry{Term: record[0], Definition: record[1]})
+		}
+
+	case FormatJSONL:
+		reader := bufio.NewReader(r)
+		for row := 1; ; row++ {
+			line, err := reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if len(bytes.TrimSpace(line)) > 0 {
+				var entry Entry
+				if decodeErr := json.Unmarshal(line, &entry); decodeErr != nil {
+					report.Errors = append(report.Errors, RowError{Row: row, Error: "invalid JSON: " + decodeErr.Error()})
+				} else {
+					add(row, entry)
+				}
+			}
+			if err == io.EOF {
+				return rows, nil
+			}
+		}
+
+	default:
+		decoder := json.NewDecoder(r)
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return nil, fmt.Errorf("%w: expected a JSON array of terms", errMalformedImport)
+		}
+		for row := 1; decoder.More(); row++ {
+			var entry Entry
+			err := decoder.Decode(&entry)
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				// O valor foi lido inteiro; dá para seguir para o próximo
+				report.Errors = append(report.Errors, RowError{Row: row, Error: fmt.Sprintf("expected an object with termo and definicao, got %s", typeErr.Value)})
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: term %d: %w", errMalformedImport, row, err)
+			}
+			add(row, entry)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, fmt.Errorf("%w: %w", errMalformedImport, err)
+		}
+		return rows, nil
+	}
+}
+
+// isCSVHeader diz se a linha é o cabeçalho escrito por ExportTerms, em
+// português ou inglês, sem considerar maiúsculas e acentos.
+func isCSVHeader(record []string) bool {
+	if len(record) != 2 {
+		return false
+	}
+	term, definition := string(foldText(strings.TrimSpace(record[0]))), string(foldText(strings.TrimSpace(record[1])))
+	return (term == "termo" || term == "term") && (definition == "definicao" || definition == "definition")
+}
+
+// ImportTerms lê os termos de r e grava os válidos de uma só vez, seguindo a
+// política de conflito. Espera pelos locks de todos os termos até o fim de
+// ctx. Retorna errImportConflict, com os motivos em report.Errors, se a
+// política é ConflictFail e houve algum erro, e um erro que envolve
+// errMalformedImport se não foi possível ler r até o fim.
+func ImportTerms(ctx context.Context, r io.Reader, dict Store, locks *TermLocks, format, conflict string) (ImportReport, error) {
+	var report ImportReport
+	rows, err := readEntries(r, format, &report)
+	if err != nil {
+		return report, err
+	}
+
+	terms := make([]string, len(rows))
+	for i, row := range rows {
+		terms[i] = row.Term
+	}
+	held := locks.ForTerms(terms)
+	for i, lock := range held {
+		if err := lock.Lock(ctx); err != nil {
+			for _, l := range held[:i] {
+				l.Unlock()
+			}
+			return report, err
+		}
+	}
+	defer func() {
+		for _, lock := range held {
+			lock.Unlock()
+		}
+	}()
+
+	// Com os locks, nenhum termo muda entre a conferência e o Apply
+	var changes []Change
+	seen := make(map[string]int) // Termo -> posição em changes
+	firstRow := make(map[string]int)
+	for _, row := range rows {
+		i, repeated := seen[row.Term]
+		exists := repeated
+		if !repeated {
+			_, _, found, err := dict.LookUp(row.Term)
+			if err != nil {
+				return report, err
+			}
+			exists = found
+		}
+		if !exists {
+			seen[row.Term] = len(changes)
+			firstRow[row.Term] = row.row
+			changes = append(changes, Change{Term: row.Term, Definition: row.Definition})
+			report.Inserted++
+			continue
+		}
+
+		switch conflict {
+		case ConflictOverwrite:
+			if repeated {
+				// A última linha vence, mas o termo já foi contado na primeira,
+				// como inserido ou substituído
+				changes[i].Definition = row.Definition
+				continue
+			}
+			seen[row.Term] = len(changes)
+			firstRow[row.Term] = row.row
+			changes = append(changes, Change{Term: row.Term, Definition: row.Definition})
+			report.Overwritten++
+		case ConflictFail:
+			message := "term already exists"
+			if repeated {
+				message = fmt.Sprintf("term repeats row %d", firstRow[row.Term])
+			}
+			report.Errors = append(report.Errors, RowError{Row: row.row, Term: row.Term, Error: message})
+		default:
+			report.Skipped++
+		}
+	}
+
+	slices.SortStableFunc(report.Errors, func(a, b RowError) int { return cmp.Compare(a.Row, b.Row) })
+	if conflict == ConflictFail && len(report.Errors) > 0 {
+		return ImportReport{Errors: report.Errors}, errImportConflict
+	}
+	if len(changes) == 0 {
+		return report, nil
+	}
+	if report.Version, err = dict.Apply(changes); err != nil {
+		return ImportReport{Errors: report.Errors}, err
+	}
+	return report, nil
+}
+
+// exportResponse responde EXPORT [formato] com o dicionário inteiro no corpo e
+// o número de termos no cabeçalho Total. Sem formato, exporta em JSON.
+func exportResponse(dict Store, format string) utils.HTTPResponse {
+	format, err := ParseFormat(cmp.Or(format, FormatJSON))
+	if err != nil {
+		return utils.HTTPResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       err.Error(),
+		}
+	}
+	var body strings.Builder
+	count, err := ExportTerms(&body, dict, format)
+	if err != nil {
+		return utils.HTTPResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       "Error reading dictionary: " + err.Error(),
+		}
+	}
+	response := utils.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Body:       body.String(),
+	}
+	response.SetHeader(utils.HeaderContentType, exportContentType(format))
+	response.SetHeader(utils.HeaderTotal, strconv.Itoa(count))
+	return response
+}
+
+// importResponse executa IMPORT [formato], com o arquivo no corpo e a política
+// de conflito no cabeçalho Conflict, e responde com o relatório: uma linha com
+// as contagens e uma para cada linha que não foi importada.
+func importResponse(ctx context.Context, request *utils.HTTPRequest, dict Store, locks *TermLocks, startTime time.Time) utils.HTTPResponse {
+	format, err := ParseFormat(cmp.Or(request.Path, FormatJSON))
+	if err != nil {
+		return utils.HTTPResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       err.Error(),
+		}
+	}
+	conflict, err := ParseConflict(request.Header.Get(utils.HeaderConflict))
+	if err != nil {
+		return utils.HTTPResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       err.Error(),
+		}
+	}
+	report, err := ImportTerms(ctx, strings.NewReader(request.Body), dict, locks, format, conflict)
+
+	var response utils.HTTPResponse
+	switch {
+	case err == nil:
+		response.StatusCode = http.StatusOK
+		response.Body = fmt.Sprintf("Import finished: %d inserted, %d overwritten, %d skipped, %d errors",
+			report.Inserted, report.Overwritten, report.Skipped, len(report.Errors))
+		if report.Version != 0 {
+			response.SetHeader(utils.HeaderVersion, fmt.Sprint(report.Version))
+		}
+	case errors.Is(err, errImportConflict):
+		response.StatusCode = http.StatusConflict
+		response.Body = fmt.Sprintf("Import aborted: %d errors, no terms imported", len(report.Errors))
+	case errors.Is(err, errMalformedImport):
+		return utils.HTTPResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       "Import aborted: " + err.Error(),
+		}
+	case ctx.Err() != nil:
+		// Como em lockFailure, mas a espera foi por vários termos
+		response.StatusCode = lockWaitStatus(ctx)
+		response.Body = fmt.Sprintf("Import aborted after %s waiting for the imported terms", time.Since(startTime).Round(time.Millisecond))
+		if response.StatusCode == http.StatusServiceUnavailable {
+			response.SetHeader("Retry-After", "1")
+		}
+		return response
+	default:
+		return utils.HTTPResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       "Error saving dictionary: " + err.Error(),
+		}
+	}
+	for _, rowErr := range report.Errors {
+		if rowErr.Term != "" {
+			response.Body += fmt.Sprintf("\nrow %d ('%s'): %s", rowErr.Row, rowErr.Term, rowErr.Error)
+		} else {
+			response.Body += fmt.Sprintf("\nrow %d: %s", rowErr.Row, rowErr.Error)
+		}
+	}
+	return response
+}
@@ -0,0 +1,330 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Camada de confiabilidade (selective repeat) sobre o Packet: cada fragmento
+// é confirmado individualmente (ACK), lacunas são sinalizadas com NACK e
+// fragmentos sem confirmação são retransmitidos com backoff exponencial.
+// O emissor mantém no máximo uma janela de fragmentos sem confirmação, limitada
+// pela janela anunciada pelo receptor no campo Window de cada pacote e pela
+// janela de congestionamento (ver congestion.go).
+
+// Janela assumida para o par até que ele anuncie a sua
+const initialPeerWindow = 4
+
+var ErrMaxRetries = errors.New("maximum retransmissions reached")
+
+type ARQConfig struct {
+	Timeout      time.Duration // Timeout inicial de retransmissão, antes da primeira medição de RTT
+	MinTimeout   time.Duration // Menor timeout calculado a partir do RTT
+	MaxTimeout   time.Duration // Limite do backoff exponencial
+	MaxRetries   int           // Retransmissões permitidas por fragmento
+	Window       uint16        // Janela de recepção local e limite de fragmentos em trânsito
+	LossRate     float64       // Probabilidade de descartar pacotes enviados (simulação de perda)
+	Checksum     ChecksumType  // Algoritmo de checksum dos pacotes enviados
+	FragmentSize int           // Maior payload por fragmento, negociado na sessão
+}
+
+func DefaultARQConfig() ARQConfig {
+	return ARQConfig{
+		Timeout:      200 * time.Millisecond,
+		MinTimeout:   100 * time.Millisecond,
+		MaxTimeout:   3 * time.Second,
+		MaxRetries:   10,
+		Window:       32,
+		Checksum:     DefaultChecksum,
+		FragmentSize: DefaultFragmentSize,
+	}
+}
+
+func NewAckPacket(messageID uint32, index uint16, window uint16, checksum ChecksumType) Packet {
+	p := Packet{
+		Version:   ProtocolV2,
+		Flags:     FlagACK,
+		Checksum:  checksum,
+		MessageID: messageID,
+		Index:     index,
+		Window:    window,
+	}
+	p.CRC = CalculateCRC(p)
+	return p
+}
+
+func NewNackPacket(messageID uint32, index uint16, window uint16, checksum ChecksumType) Packet {
+	p := Packet{
+		Version:   ProtocolV2,
+		Flags:     FlagNACK,
+		Checksum:  checksum,
+		MessageID: messageID,
+		Index:     index,
+		Window:    window,
+	}
+	p.CRC = CalculateCRC(p)
+	return p
+}
+
+type fragmentState struct {
+	sent     bool
+	acked    bool
+	retries  int
+	timeout  time.Duration
+	sentAt   time.Time
+	deadline time.Time
+}
+
+// Sender transmite os fragmentos de uma mensagem e aguarda a confirmação de
+// cada um. ACKs e NACKs recebidos pelo loop de leitura devem ser entregues
+// via HandlePacket.
+type Sender struct {
+	packets    []Packet
+	write      func(Packet) error
+	config     ARQConfig
+	peerWindow uint16
+	cc         *CongestionControl
+	events     chan Packet
+	stop       chan struct{}
+	once       sync.Once
+}
+
+func NewSender(packets []Packet, write func(Packet) error, config ARQConfig) *Sender {
+	stamped := make([]Packet, len(packets))
+	for i, p := range packets {
+		p.Window = config.Window
+		if !p.IsLegacy() {
+			p.Checksum = config.Checksum
+		}
+		p.CRC = CalculateCRC(p)
+		stamped[i] = p
+	}
+	return &Sender{
+		packets:    stamped,
+		write:      write,
+		config:     config,
+		peerWindow: initialPeerWindow,
+		cc:         NewCongestionControl(config),
+		events:     make(chan Packet, 2*len(packets)+1),
+		stop:       make(chan struct{}),
+	}
+}
+
+// SetPeerWindow informa a janela já anunciada pelo par (por exemplo, nos
+// fragmentos da requisição) antes de Run ser chamado.
+func (s *Sender) SetPeerWindow(window uint16) {
+	if window > 0 {
+		s.peerWindow = window
+	}
+}
+
+// SetCongestionControl permite compartilhar o estado de congestionamento
+// entre mensagens enviadas ao mesmo par. Sem ele, cada mensagem começa no slow
+// start e com o timeout configurado.
+func (s *Sender) SetCongestionControl(cc *CongestionControl) {
+	s.cc = cc
+}
+
+// window retorna quantos fragmentos podem estar em trânsito ao mesmo tempo.
+func (s *Sender) window() int {
+	w := int(s.peerWindow)
+	if s.config.Window > 0 && int(s.config.Window) < w {
+		w = int(s.config.Window)
+	}
+	if cwnd := s.cc.Window(); cwnd < w {
+		w = cwnd
+	}
+	if w < 1 {
+		w = 1
+	}
+	return w
+}
+
+func (s *Sender) HandlePacket(packet Packet) {
+	select {
+	case s.events <- packet:
+	default:
+		// Fila cheia: o fragmento será recuperado pelo timer de retransmissão
+	}
+}
+
+// Stop encerra Run sem erro, por exemplo quando a resposta do par já chegou
+// e portanto a mensagem enviada foi recebida por completo.
+func (s *Sender) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *Sender) Run() error {
+	logger := GetLogger()
+	state := make([]fragmentState, len(s.packets))
+	pending := len(s.packets)
+	base := 0     // Menor fragmento sem confirmação
+	next := 0     // Próximo fragmento ainda não enviado
+	recover := -1 // Perdas abaixo deste índice já reduziram a janela
+
+	defer func() {
+		logger.Info("Sender finished",
+			zap.Int("packets", len(s.packets)),
+			zap.Int("acked", len(s.packets)-pending),
+			zap.Int("cwnd", s.cc.Window()),
+			zap.Duration("rto", s.cc.RTO()))
+	}()
+
+	// Reage a uma perda no máximo uma vez por janela enviada
+	congestion := func(i int, timeout bool) {
+		GetMetrics().AddLost()
+		if i < recover {
+			return
+		}
+		recover = next
+		if timeout {
+			s.cc.OnTimeout()
+		} else {
+			s.cc.OnLoss()
+		}
+		logger.Info("Congestion window reduced",
+			zap.Int("packet_index", i),
+			zap.Bool("timeout", timeout),
+			zap.Int("cwnd", s.cc.Window()))
+	}
+
+	fastRetransmit := func(i int) error {
+		if state[i].retries > 0 && time.Since(state[i].sentAt) < s.cc.RTO() {
+			// Já retransmitido recentemente; o timer cuida de uma nova perda
+			return nil
+		}
+		congestion(i, false)
+		return s.retransmit(i, &state[i], false)
+	}
+
+	fill := func() error {
+		for next < len(s.packets) && next < base+s.window() {
+			state[next].timeout = s.cc.RTO()
+			if err := s.transmit(next, &state[next]); err != nil {
+				return err
+			}
+			next++
+		}
+		return nil
+	}
+
+	if err := fill(); err != nil {
+		return err
+	}
+
+	for pending > 0 {
+		timer := time.NewTimer(time.Until(nextDeadline(state)))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return nil
+
+		case packet := <-s.events:
+			timer.Stop()
+			if packet.Window > 0 {
+				s.peerWindow = packet.Window
+			}
+			i := int(packet.Index)
+			if i >= len(state) || !state[i].sent || state[i].acked {
+				continue
+			}
+			switch {
+			case packet.Has(FlagACK):
+				state[i].acked = true
+				pending--
+				if state[i].retries == 0 {
+					s.cc.OnRTTSample(time.Since(state[i].sentAt))
+				}
+				s.cc.OnAck()
+				logger.Debug("Packet acknowledged",
+					zap.Int("packet_index", i),
+					zap.Int("cwnd", s.cc.Window()),
+					zap.Uint16("peer_window", s.peerWindow))
+				// Fragmentos que ficaram nackThreshold posições para trás são
+				// considerados perdidos mesmo que o NACK do receptor se perca
+				for k := base; k+nackThreshold <= i; k++ {
+					if state[k].sent && !state[k].acked {
+						if err := fastRetransmit(k); err != nil {
+							return err
+						}
+					}
+				}
+				for base < len(state) && state[base].acked {
+					base++
+				}
+				if err := fill(); err != nil {
+					return err
+				}
+			case packet.Has(FlagNACK):
+				logger.Info("NACK received", zap.Int("packet_index", i))
+				if err := fastRetransmit(i); err != nil {
+					return err
+				}
+			}
+
+		case <-timer.C:
+			now := time.Now()
+			for i := range state {
+				if !state[i].sent || state[i].acked || state[i].deadline.After(now) {
+					continue
+				}
+				congestion(i, true)
+				if err := s.retransmit(i, &state[i], true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Sender) transmit(i int, st *fragmentState) error {
+	if err := s.write(s.packets[i]); err != nil {
+		return err
+	}
+	GetMetrics().AddSent()
+	st.sent = true
+	st.sentAt = time.Now()
+	st.deadline = st.sentAt.Add(st.timeout)
+	return nil
+}
+
+func (s *Sender) retransmit(i int, st *fragmentState, backoff bool) error {
+	logger := GetLogger()
+	if st.retries >= s.config.MaxRetries {
+		logger.Warn("Giving up on packet", zap.Int("packet_index", i), zap.Int("retries", st.retries))
+		return fmt.Errorf("packet %d: %w", i, ErrMaxRetries)
+	}
+	st.retries++
+	GetMetrics().AddRetransmission()
+	if backoff {
+		st.timeout *= 2
+		if st.timeout > s.config.MaxTimeout {
+			st.timeout = s.config.MaxTimeout
+		}
+	}
+	logger.Info("Retransmitting packet",
+		zap.Int("packet_index", i),
+		zap.Int("retry", st.retries),
+		zap.Duration("timeout", st.timeout))
+	return s.transmit(i, st)
+}
+
+func nextDeadline(state []fragmentState) time.Time {
+	var next time.Time
+	for _, st := range state {
+		if !st.sent || st.acked {
+			continue
+		}
+		if next.IsZero() || st.deadline.Before(next) {
+			next = st.deadline
+		}
+	}
+	return next
+}
@@ -0,0 +1,293 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// lossyAddr identifica uma ponta de lossyConn.
+type lossyAddr string
+
+func (a lossyAddr) Network() string { return "lossy" }
+func (a lossyAddr) String() string  { return string(a) }
+
+type datagram struct {
+	data []byte
+	from net.Addr
+}
+
+// lossyConn é um net.PacketConn em memória ligado a um par, que descarta os
+// datagramas enviados quando drop retorna true.
+type lossyConn struct {
+	addr   lossyAddr
+	peer   *lossyConn
+	inbox  chan datagram
+	drop   func(data []byte) bool
+	closed chan struct{}
+	once   sync.Once
+}
+
+// newLossyPair cria duas pontas ligadas; cada datagrama enviado por qualquer
+// uma delas é perdido com probabilidade rate, sorteada a partir de seed para
+// que os testes sejam reproduzíveis.
+func newLossyPair(rate float64, seed uint64) (*lossyConn, *lossyConn) {
+	var mu sync.Mutex
+	rng := rand.New(rand.NewPCG(seed, seed))
+	drop := func([]byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return rng.Float64() < rate
+	}
+	a := &lossyConn{addr: "a", inbox: make(chan datagram, 1024), drop: drop, closed: make(chan struct{})}
+	b := &lossyConn{addr: "b", inbox: make(chan datagram, 1024), drop: drop, closed: make(chan struct{})}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func (c *lossyConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case d := <-c.inbox:
+		return copy(p, d.data), d.from, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *lossyConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	if c.drop != nil && c.drop(p) {
+		return len(p), nil
+	}
+	select {
+	case c.peer.inbox <- datagram{data: bytes.Clone(p), from: c.addr}:
+	case <-c.peer.closed:
+	default:
+		// Fila do par cheia: perdido, como num socket real
+	}
+	return len(p), nil
+}
+
+func (c *lossyConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *lossyConn) LocalAddr() net.Addr                 { return c.addr }
+func (c *lossyConn) SetDeadline(time.Time) error         { return nil }
+func (c *lossyConn) SetReadDeadline(time.Time) error     { return nil }
+func (c *lossyConn) SetWriteDeadline(time.Time) error    { return nil }
+func (c *lossyConn) setDrop(drop func(data []byte) bool) { c.drop = drop }
+
+// receive faz o papel do servidor: remonta as mensagens recebidas em conn,
+// confirma cada fragmento, pede os ausentes com NACK e entrega cada mensagem
+// completa em delivered.
+func receive(conn net.PacketConn, window uint16, delivered chan<- []byte) {
+	ps := NewPacketStore(DefaultStoreConfig())
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		packet, err := ParsePacket(bytes.Clone(buf[:n]))
+		if err != nil || !NewCRC().ValidatePacket(packet) {
+			continue
+		}
+		origin, id := from.String(), packet.MessageID
+		send := func(p Packet) { conn.WriteTo(p.Bytes(), from) }
+		if ps.IsCompleted(origin, id) {
+			send(NewAckPacket(id, packet.Index, window, packet.Checksum))
+			continue
+		}
+		added, gaps := ps.AddPacket(origin, packet)
+		send(NewAckPacket(id, packet.Index, window, packet.Checksum))
+		for _, gap := range gaps {
+			send(NewNackPacket(id, gap, window, packet.Checksum))
+		}
+		if added && ps.IsComplete(origin, id) {
+			delivered <- ps.AssemblePayload(origin, id)
+			ps.MarkCompleted(origin, id)
+		}
+	}
+}
+
+// arqClient envia mensagens por conn, uma de cada vez, entregando ao Sender
+// da mensagem atual os ACKs e NACKs lidos da conexão.
+type arqClient struct {
+	conn   net.PacketConn
+	mu     sync.Mutex
+	sender *Sender
+}
+
+func newARQClient(conn net.PacketConn) *arqClient {
+	c := &arqClient{conn: conn}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packet, err := ParsePacket(bytes.Clone(buf[:n]))
+			if err != nil {
+				continue
+			}
+			c.mu.Lock()
+			if c.sender != nil {
+				c.sender.HandlePacket(packet)
+			}
+			c.mu.Unlock()
+		}
+	}()
+	return c
+}
+
+func (c *arqClient) send(messageID uint32, payload []byte, config ARQConfig) error {
+	packets, err := NewPacket(messageID, payload, config.FragmentSize)
+	if err != nil {
+		return err
+	}
+	sender := NewSender(packets, func(p Packet) error {
+		_, err := c.conn.WriteTo(p.Bytes(), lossyAddr("peer"))
+		return err
+	}, config)
+	c.mu.Lock()
+	c.sender = sender
+	c.mu.Unlock()
+	return sender.Run()
+}
+
+// testARQConfig encurta os timers para que as perdas simuladas sejam
+// recuperadas rapidamente, mantendo o limite de retransmissões padrão.
+func testARQConfig() ARQConfig {
+	config := DefaultARQConfig()
+	config.Timeout = 20 * time.Millisecond
+	config.MinTimeout = 20 * time.Millisecond
+	config.MaxTimeout = 40 * time.Millisecond
+	config.FragmentSize = 16
+	return config
+}
+
+func TestSenderRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		loss     float64
+		messages int
+	}{
+		{"no loss", 0, 5},
+		{"10% loss", 0.1, 20},
+		// -loss=0.2 perde dados e ACKs; o limite antigo de 3 retransmissões
+		// abandonava parte das mensagens
+		{"20% loss", 0.2, 30},
+	}
+	// Cada perda gera várias linhas de log, que só deixariam o teste lento
+	defer func(l *zap.Logger) { Logger = l }(Logger)
+	Logger = zap.NewNop()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := newLossyPair(tt.loss, 1)
+			defer client.Close()
+			defer server.Close()
+			delivered := make(chan []byte, tt.messages)
+			go receive(server, 32, delivered)
+
+			arq := newARQClient(client)
+			config := testARQConfig()
+			for id := 1; id <= tt.messages; id++ {
+				payload := bytes.Repeat([]byte{byte(id)}, 8*config.FragmentSize+3)
+				if err := arq.send(uint32(id), payload, config); err != nil {
+					t.Fatalf("message %d: %v", id, err)
+				}
+				select {
+				case got := <-delivered:
+					if !bytes.Equal(got, payload) {
+						t.Fatalf("message %d delivered as %q", id, got)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("message %d acknowledged but never delivered", id)
+				}
+			}
+		})
+	}
+}
+
+func TestSenderGivesUp(t *testing.T) {
+	client, server := newLossyPair(1, 1)
+	defer client.Close()
+	defer server.Close()
+	go receive(server, 32, make(chan []byte, 1))
+
+	config := testARQConfig()
+	config.MaxTimeout = 20 * time.Millisecond
+	config.MaxRetries = 2
+	writes := 0
+	client.setDrop(func([]byte) bool {
+		writes++
+		return true
+	})
+	err := newARQClient(client).send(1, []byte("perdido"), config)
+	if !errors.Is(err, ErrMaxRetries) {
+		t.Fatalf("Run error = %v, want ErrMaxRetries", err)
+	}
+	if want := 1 + config.MaxRetries; writes != want {
+		t.Errorf("fragment written %d times, want %d", writes, want)
+	}
+}
+
+// Um NACK retransmite o fragmento na hora, sem esperar o timer.
+func TestSenderRetransmitsOnNack(t *testing.T) {
+	config := DefaultARQConfig()
+	config.Timeout = time.Minute
+	config.MaxTimeout = time.Minute
+	config.FragmentSize = 4
+	packets, err := NewPacket(1, []byte("abcdefgh"), config.FragmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan uint16, 16)
+	sender := NewSender(packets, func(p Packet) error {
+		written <- p.Index
+		return nil
+	}, config)
+	result := make(chan error, 1)
+	go func() { result <- sender.Run() }()
+
+	next := func() uint16 {
+		select {
+		case index := <-written:
+			return index
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no fragment written")
+			return 0
+		}
+	}
+	// O slow start começa com um fragmento em trânsito
+	if index := next(); index != 0 {
+		t.Fatalf("first fragment written is %d, want 0", index)
+	}
+	sender.HandlePacket(NewNackPacket(1, 0, 32, config.Checksum))
+	if index := next(); index != 0 {
+		t.Fatalf("retransmitted fragment %d after a NACK for 0", index)
+	}
+	sender.HandlePacket(NewAckPacket(1, 0, 32, config.Checksum))
+	if index := next(); index != 1 {
+		t.Fatalf("fragment %d written after the ACK for 0, want 1", index)
+	}
+	// Um NACK para um fragmento já confirmado é ignorado
+	sender.HandlePacket(NewNackPacket(1, 0, 32, config.Checksum))
+	sender.HandlePacket(NewAckPacket(1, 1, 32, config.Checksum))
+	if err := <-result; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	select {
+	case index := <-written:
+		t.Errorf("fragment %d retransmitted after being acknowledged", index)
+	default:
+	}
+}
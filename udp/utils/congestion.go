@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// Controle de congestionamento inspirado no TCP: slow start, AIMD e cálculo do
+// timeout de retransmissão a partir do RTT medido (RFC 6298). A janela de
+// congestionamento é contada em fragmentos. O estado é do par, e não da
+// mensagem: cada sessão mantém o seu e o entrega a todos os Senders dela (ver
+// Sender.SetCongestionControl), para que o RTO medido e a janela aberta numa
+// mensagem valham para as seguintes.
+
+const (
+	initialSsthresh  = 64
+	minCwnd          = 1
+	minSsthresh      = 2
+	rttAlpha         = 0.125
+	rttBeta          = 0.25
+	clockGranularity = time.Millisecond
+)
+
+type CongestionControl struct {
+	mux      sync.Mutex
+	cwnd     float64
+	ssthresh float64
+	srtt     time.Duration
+	rttvar   time.Duration
+	rto      time.Duration
+	minRTO   time.Duration
+	maxRTO   time.Duration
+	sampled  bool
+	report   bool // Publica o estado nas métricas do processo
+}
+
+// CongestionState é uma cópia do estado de um CongestionControl.
+type CongestionState struct {
+	Cwnd     int
+	Ssthresh int
+	SRTT     time.Duration
+	RTO      time.Duration
+}
+
+func NewCongestionControl(config ARQConfig) *CongestionControl {
+	return &CongestionControl{
+		cwnd:     minCwnd,
+		ssthresh: initialSsthresh,
+		rto:      config.Timeout,
+		minRTO:   config.MinTimeout,
+		maxRTO:   config.MaxTimeout,
+	}
+}
+
+// ReportMetrics faz o estado aparecer nas métricas do processo (ver
+// Metrics.Log). Só deve ser chamado para uma das sessões do processo, já que
+// as métricas guardam um único estado de congestionamento.
+func (cc *CongestionControl) ReportMetrics() {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+	cc.report = true
+	cc.publish()
+}
+
+func (cc *CongestionControl) State() CongestionState {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+	return CongestionState{
+		Cwnd:     int(cc.cwnd),
+		Ssthresh: int(cc.ssthresh),
+		SRTT:     cc.srtt,
+		RTO:      cc.rto,
+	}
+}
+
+// Window retorna a janela de congestionamento atual em fragmentos.
+func (cc *CongestionControl) Window() int {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+	return int(cc.cwnd)
+}
+
+func (cc *CongestionControl) RTO() time.Duration {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+	return cc.rto
+}
+
+// OnAck aumenta a janela: um fragmento por ACK no slow start e 1/cwnd por
+// ACK (aproximadamente um fragmento por RTT) em congestion avoidance.
+func (cc *CongestionControl) OnAck() {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+	if cc.cwnd < cc.ssthresh {
+		cc.cwnd++
+	} else {
+		cc.cwnd += 1 / cc.cwnd
+	}
+	cc.publish()
+}
+
+// OnLoss aplica a redução multiplicativa quando uma perda é sinalizada por NACK.
+func (cc *CongestionControl) OnLoss() {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+	cc.ssthresh = max(cc.cwnd/2, minSsthresh)
+	cc.cwnd = cc.ssthresh
+	cc.publish()
+}
+
+// OnTimeout reinicia o slow start e dobra o RTO até o limite, como o TCP faz
+// ao expirar o timer (RFC 6298, seção 5.5). O RTO volta a ser calculado na
+// próxima medição de RTT.
+func (cc *CongestionControl) OnTimeout() {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+	cc.ssthresh = max(cc.cwnd/2, minSsthresh)
+	cc.cwnd = minCwnd
+	cc.rto *= 2
+	if cc.maxRTO > 0 && cc.rto > cc.maxRTO {
+		cc.rto = cc.maxRTO
+	}
+	cc.publish()
+}
+
+// OnRTTSample atualiza SRTT, RTTVAR e RTO. Amostras de fragmentos
+// retransmitidos não devem ser usadas (algoritmo de Karn).
+func (cc *CongestionControl) OnRTTSample(rtt time.Duration) {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+	if !cc.sampled {
+		cc.srtt = rtt
+		cc.rttvar = rtt / 2
+		cc.sampled = true
+	} else {
+		diff := cc.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		cc.rttvar = time.Duration((1-rttBeta)*float64(cc.rttvar) + rttBeta*float64(diff))
+		cc.srtt = time.Duration((1-rttAlpha)*float64(cc.srtt) + rttAlpha*float64(rtt))
+	}
+	cc.rto = cc.srtt + max(clockGranularity, 4*cc.rttvar)
+	if cc.rto < cc.minRTO {
+		cc.rto = cc.minRTO
+	}
+	if cc.maxRTO > 0 && cc.rto > cc.maxRTO {
+		cc.rto = cc.maxRTO
+	}
+	GetMetrics().ObserveRTT(rtt)
+	cc.publish()
+}
+
+func (cc *CongestionControl) publish() {
+	if !cc.report {
+		return
+	}
+	GetMetrics().SetCongestion(int(cc.cwnd), int(cc.ssthresh), cc.srtt, cc.rto)
+}
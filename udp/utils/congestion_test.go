@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// sendWithImmediateAcks envia a mensagem por um Sender cujo par confirma cada
+// fragmento assim que ele é escrito. Os RTTs medidos ficam abaixo de
+// MinTimeout, então o RTO resultante não depende do relógio.
+func sendWithImmediateAcks(t *testing.T, messageID uint32, config ARQConfig, cc *CongestionControl) {
+	t.Helper()
+	var sender *Sender
+	write := func(p Packet) error {
+		sender.HandlePacket(NewAckPacket(p.MessageID, p.Index, 0, p.Checksum))
+		return nil
+	}
+	payload := bytes.Repeat([]byte("x"), 8*config.FragmentSize)
+	packets, err := NewPacket(messageID, payload, config.FragmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender = NewSender(packets, write, config)
+	sender.SetCongestionControl(cc)
+	if err := sender.Run(); err != nil {
+		t.Fatalf("message %d: %v", messageID, err)
+	}
+}
+
+func TestCongestionControlCarriesOverMessages(t *testing.T) {
+	config := DefaultARQConfig()
+	config.Timeout = 2 * time.Second
+	config.MinTimeout = 500 * time.Millisecond
+	config.FragmentSize = 64
+
+	cc := NewCongestionControl(config)
+	sendWithImmediateAcks(t, 1, config, cc)
+	first := cc.State()
+	if first.RTO != config.MinTimeout {
+		t.Fatalf("RTO after the first message = %s, want the measured %s", first.RTO, config.MinTimeout)
+	}
+	if first.Cwnd != minCwnd+8 {
+		t.Fatalf("cwnd after the first message = %d, want %d", first.Cwnd, minCwnd+8)
+	}
+
+	// A segunda mensagem parte do estado da primeira, sem voltar ao slow start
+	// nem ao timeout inicial
+	sendWithImmediateAcks(t, 2, config, cc)
+	second := cc.State()
+	if second.RTO != first.RTO {
+		t.Errorf("RTO after the second message = %s, want %s", second.RTO, first.RTO)
+	}
+	if second.Cwnd != first.Cwnd+8 {
+		t.Errorf("cwnd after the second message = %d, want %d", second.Cwnd, first.Cwnd+8)
+	}
+}
+
+// RFC 6298: a primeira medição define SRTT = R e RTTVAR = R/2, as seguintes
+// suavizam com alfa = 1/8 e beta = 1/4, e RTO = SRTT + 4·RTTVAR, limitado.
+func TestCongestionControlRTTSamples(t *testing.T) {
+	config := DefaultARQConfig()
+	config.MinTimeout = 50 * time.Millisecond
+	config.MaxTimeout = 3 * time.Second
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		srtt    time.Duration
+		rto     time.Duration
+	}{
+		{"first sample", []time.Duration{20 * ms}, 20 * ms, 60 * ms},
+		{"steady RTT", []time.Duration{20 * ms, 20 * ms}, 20 * ms, 50 * ms},
+		{"RTT grows", []time.Duration{20 * ms, 100 * ms}, 30 * ms, 140 * ms},
+		{"clamped to MinTimeout", []time.Duration{ms}, ms, 50 * ms},
+		{"clamped to MaxTimeout", []time.Duration{2 * time.Second}, 2 * time.Second, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := NewCongestionControl(config)
+			for _, rtt := range tt.samples {
+				cc.OnRTTSample(rtt)
+			}
+			state := cc.State()
+			if state.SRTT != tt.srtt || state.RTO != tt.rto {
+				t.Errorf("SRTT = %s, RTO = %s, want %s and %s", state.SRTT, state.RTO, tt.srtt, tt.rto)
+			}
+		})
+	}
+}
+
+func TestCongestionControlLoss(t *testing.T) {
+	config := DefaultARQConfig()
+	tests := []struct {
+		name     string
+		timeout  bool
+		cwnd     int
+		ssthresh int
+	}{
+		{"NACK halves the window", false, 8, 8},
+		{"timeout restarts slow start", true, minCwnd, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := NewCongestionControl(config)
+			for cc.Window() < 16 {
+				cc.OnAck()
+			}
+			if tt.timeout {
+				cc.OnTimeout()
+			} else {
+				cc.OnLoss()
+			}
+			if state := cc.State(); state.Cwnd != tt.cwnd || state.Ssthresh != tt.ssthresh {
+				t.Errorf("cwnd = %d, ssthresh = %d, want %d and %d", state.Cwnd, state.Ssthresh, tt.cwnd, tt.ssthresh)
+			}
+		})
+	}
+}
+
+// Cada timeout dobra o RTO até MaxTimeout (RFC 6298, seção 5.5), e a próxima
+// medição de RTT volta a calculá-lo.
+func TestCongestionControlTimeoutBackoff(t *testing.T) {
+	config := DefaultARQConfig()
+	config.Timeout = 200 * time.Millisecond
+	config.MinTimeout = 100 * time.Millisecond
+	config.MaxTimeout = time.Second
+	cc := NewCongestionControl(config)
+	for _, want := range []time.Duration{400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		cc.OnTimeout()
+		if rto := cc.RTO(); rto != want {
+			t.Fatalf("RTO after a timeout = %s, want %s", rto, want)
+		}
+	}
+	cc.OnRTTSample(20 * time.Millisecond)
+	if rto := cc.RTO(); rto != config.MinTimeout {
+		t.Errorf("RTO after a new sample = %s, want %s", rto, config.MinTimeout)
+	}
+}
+
+func TestCongestionControlReportMetrics(t *testing.T) {
+	config := DefaultARQConfig()
+	GetMetrics().SetCongestion(0, 0, 0, 0)
+
+	silent := NewCongestionControl(config)
+	silent.OnAck()
+	if cwnd := GetMetrics().Cwnd; cwnd != 0 {
+		t.Fatalf("metrics cwnd = %d after an unreported ACK, want 0", cwnd)
+	}
+
+	reported := NewCongestionControl(config)
+	reported.ReportMetrics()
+	reported.OnAck()
+	if cwnd := GetMetrics().Cwnd; cwnd != 2 {
+		t.Errorf("metrics cwnd = %d, want 2", cwnd)
+	}
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+var checksumTypes = []ChecksumType{ChecksumCRC16, ChecksumCRC32, ChecksumCRC32C}
+
+// Valores de referência (check) de cada algoritmo para a entrada "123456789"
+func TestChecksumKnownAnswers(t *testing.T) {
+	want := map[ChecksumType]uint32{
+		ChecksumCRC16:  0x4C06,
+		ChecksumCRC32:  0xCBF43926,
+		ChecksumCRC32C: 0xE3069283,
+	}
+	for _, checksum := range checksumTypes {
+		if got := checksum.Sum([]byte("123456789")); got != want[checksum] {
+			t.Errorf("%s(\"123456789\") = %#x, want %#x", checksum, got, want[checksum])
+		}
+	}
+}
+
+func newTestPacket(checksum ChecksumType) Packet {
+	p := Packet{
+		Version:   ProtocolV2,
+		Checksum:  checksum,
+		MessageID: 0xCAFE,
+		Index:     1,
+		Total:     3,
+		Window:    32,
+		Payload:   []byte("LOOKUP golang"),
+	}
+	p.CRC = CalculateCRC(p)
+	return p
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	for _, checksum := range checksumTypes {
+		t.Run(checksum.String(), func(t *testing.T) {
+			sent := newTestPacket(checksum)
+			received, err := ParsePacket(sent.Bytes())
+			if err != nil {
+				t.Fatalf("ParsePacket: %v", err)
+			}
+			if !NewCRC().ValidatePacket(received) {
+				t.Fatalf("packet rejected after a round trip")
+			}
+			if received.Checksum != checksum || received.MessageID != sent.MessageID ||
+				received.Index != sent.Index || received.Total != sent.Total ||
+				received.Window != sent.Window || received.CRC != sent.CRC ||
+				!bytes.Equal(received.Payload, sent.Payload) {
+				t.Errorf("received %+v, want %+v", received, sent)
+			}
+		})
+	}
+}
+
+func TestPacketCorruptionRejected(t *testing.T) {
+	for _, checksum := range checksumTypes {
+		t.Run(checksum.String(), func(t *testing.T) {
+			data := newTestPacket(checksum).Bytes()
+			// Um bit trocado em cada byte do payload, um de cada vez
+			for i := headerSize; i < len(data)-checksum.Size(); i++ {
+				corrupted := bytes.Clone(data)
+				corrupted[i] ^= 0x01
+				packet, err := ParsePacket(corrupted)
+				if err != nil {
+					t.Fatalf("byte %d: ParsePacket: %v", i, err)
+				}
+				if NewCRC().ValidatePacket(packet) {
+					t.Errorf("byte %d: corrupted packet accepted", i)
+				}
+			}
+		})
+	}
+}
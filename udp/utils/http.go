@@ -4,20 +4,95 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Formato das mensagens, inspirado no HTTP/1.1:
+//
+//	METHOD /termo\r\n            <StatusCode> <StatusText>\r\n
+//	Nome: valor\r\n              Nome: valor\r\n
+//	\r\n                         \r\n
+//	<corpo>                      <corpo>
+//
+// O corpo tem exatamente Content-Length bytes e é transmitido como está, então
+// pode conter qualquer sequência, inclusive "\r\n" ou "Body: ". O termo vai na
+// linha de requisição com percent-encoding.
+
+const (
+	HeaderContentLength = "Content-Length"
+	HeaderContentType   = "Content-Type"
+	HeaderRequestID     = "Request-Id"
+	HeaderConnection    = "Connection"
+	HeaderTimeout       = "Timeout"
+	HeaderVersion       = "Version"
+	HeaderIfMatch       = "If-Match"
+	HeaderSort          = "Sort"
+	HeaderCursor        = "Cursor"
+	HeaderTotal         = "Total"
+	HeaderNextCursor    = "Next-Cursor"
+	HeaderSuggestions   = "Suggestions"
+	HeaderConflict      = "Conflict"
+)
+
+const ContentTypeText = "text/plain; charset=utf-8"
+
+// Formato antigo do corpo, uma única linha "Body: ...". Ainda é aceito nas
+// requisições sem Content-Length, enviadas por clientes antigos.
+const legacyBodyHeader = "Body"
+
+type Header map[string]string
+
+func (h Header) Get(key string) string {
+	return h[textproto.CanonicalMIMEHeaderKey(key)]
+}
+
+func (h Header) Set(key, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = value
+}
+
+func (h Header) Del(key string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// write escreve os cabeçalhos em ordem alfabética, seguidos do Content-Length
+// do corpo e da linha vazia. Quebras de linha nos valores viram espaços para
+// não criar cabeçalhos novos.
+func (h Header) write(b *strings.Builder, body string, withLength bool) {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		if key != HeaderContentLength {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	clean := strings.NewReplacer("\r", " ", "\n", " ")
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s: %s\r\n", key, clean.Replace(h[key]))
+	}
+	if withLength || body != "" {
+		fmt.Fprintf(b, "%s: %d\r\n", HeaderContentLength, len(body))
+	}
+	b.WriteString("\r\n")
+}
+
 type HTTPRequest struct {
 	Method string // LIST, LOOKUP, INSERT, UPDATE, etc.
 	Path   string // O termo ou recurso
+	Header Header // Cabeçalhos; Content-Length é calculado a partir do corpo
 	Body   string // Corpo da requisição (para INSERT/UPDATE)
 }
 
 func (r HTTPRequest) String() string {
-	if r.Body != "" {
-		return fmt.Sprintf("%s /%s\r\nBody: %s\r\n\r\n", r.Method, r.Path, r.Body)
-	}
-	return fmt.Sprintf("%s /%s\r\n\r\n", r.Method, r.Path)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s /%s\r\n", r.Method, url.PathEscape(r.Path))
+	r.Header.write(&b, r.Body, false)
+	b.WriteString(r.Body)
+	return b.String()
 }
 
 func (r HTTPRequest) Bytes() []byte {
@@ -26,48 +101,292 @@ func (r HTTPRequest) Bytes() []byte {
 
 type HTTPResponse struct {
 	StatusCode int
-	Message    string
+	Header     Header // Cabeçalhos; Content-Length é calculado a partir do corpo
+	Body       string
+}
+
+// SetHeader define um cabeçalho, criando o mapa se necessário.
+func (r *HTTPResponse) SetHeader(key, value string) {
+	if r.Header == nil {
+		r.Header = Header{}
+	}
+	r.Header.Set(key, value)
 }
 
 func (r HTTPResponse) String() string {
-	return fmt.Sprintf("%d %s: %s", r.StatusCode, http.StatusText(r.StatusCode), r.Message)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %s\r\n", r.StatusCode, http.StatusText(r.StatusCode))
+	if r.Body != "" && r.Header.Get(HeaderContentType) == "" {
+		fmt.Fprintf(&b, "%s: %s\r\n", HeaderContentType, ContentTypeText)
+	}
+	r.Header.write(&b, r.Body, true)
+	b.WriteString(r.Body)
+	return b.String()
 }
 
 func (r HTTPResponse) Bytes() []byte {
 	return []byte(r.String())
 }
 
-func ParseHTTPRequest(data []byte) (*HTTPRequest, error) {
-	if len(data) == 0 {
-		return nil, fmt.Errorf("invalid request format")
+// LegacyString é o formato antigo, "<StatusCode> <StatusText>: <Body>", usado
+// com clientes que não entendem cabeçalhos.
+func (r HTTPResponse) LegacyString() string {
+	return fmt.Sprintf("%d %s: %s", r.StatusCode, http.StatusText(r.StatusCode), r.Body)
+}
+
+// ParseTimeout lê o cabeçalho Timeout, o prazo que o cliente dá ao servidor
+// para atender a requisição: uma duração como "500ms" ou "2s", ou um número
+// inteiro de milissegundos.
+func ParseTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if ms, atoiErr := strconv.Atoi(value); atoiErr == nil {
+		timeout, err = time.Duration(ms)*time.Millisecond, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", HeaderTimeout, value)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %q", HeaderTimeout, value)
+	}
+	return timeout, nil
+}
+
+// FormatSuggestions junta os termos sugeridos num 404 para o cabeçalho
+// Suggestions, separados por vírgula e com percent-encoding, de modo que os
+// termos podem ter vírgulas e espaços.
+func FormatSuggestions(terms []string) string {
+	escaped := make([]string, len(terms))
+	for i, term := range terms {
+		escaped[i] = url.PathEscape(term)
 	}
+	return strings.Join(escaped, ", ")
+}
+
+// ParseSuggestions lê o cabeçalho Suggestions.
+func ParseSuggestions(value string) []string {
+	var terms []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if term, err := url.PathUnescape(field); err == nil {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// FormatETag escreve a versão de um termo como uma ETag forte, "3".
+func FormatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// ParseVersion lê a versão esperada no cabeçalho If-Match: uma ETag como a de
+// FormatETag ou só o número.
+func ParseVersion(value string) (uint64, error) {
+	number := value
+	if len(number) >= 2 && number[0] == '"' && number[len(number)-1] == '"' {
+		number = number[1 : len(number)-1]
+	}
+	version, err := strconv.ParseUint(number, 10, 64)
+	if err != nil || version == 0 {
+		return 0, fmt.Errorf("invalid %s %q", HeaderIfMatch, value)
+	}
+	return version, nil
+}
+
+// ParseError indica onde a mensagem é inválida.
+type ParseError struct {
+	Line   int // Linha, a partir de 1
+	Offset int // Posição em bytes desde o início da mensagem
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, offset %d: %s", e.Line, e.Offset, e.Msg)
+}
 
-	lines := bytes.Split(data, []byte("\r\n"))
-	if len(lines) < 1 {
-		return nil, fmt.Errorf("invalid request format")
+func ParseHTTPRequest(data []byte) (*HTTPRequest, error) {
+	p := &messageParser{data: data}
+	line, offset, err := p.startLine()
+	if err != nil {
+		return nil, err
 	}
 
-	parts := bytes.Fields(lines[0])
-	if len(parts) < 2 {
-		return nil, fmt.Errorf("invalid request line format")
+	method, path, found := strings.Cut(line, " ")
+	if !found || method == "" {
+		return nil, p.errorAt(offset, "request line must be \"METHOD /path\"")
+	}
+	for i, c := range method {
+		if c < 'A' || c > 'Z' {
+			return nil, p.errorAt(offset+i, fmt.Sprintf("invalid character %q in method", c))
+		}
+	}
+	pathOffset := offset + len(method) + 1
+	if !strings.HasPrefix(path, "/") {
+		return nil, p.errorAt(pathOffset, "path must start with '/'")
+	}
+	if i := strings.IndexByte(path, ' '); i >= 0 {
+		return nil, p.errorAt(pathOffset+i, "unexpected space in path")
+	}
+	term, err := url.PathUnescape(path[1:])
+	if err != nil {
+		return nil, p.errorAt(pathOffset, "invalid path escaping: "+err.Error())
 	}
 
-	method := strings.ToUpper(string(parts[0]))
-	path := strings.TrimPrefix(string(parts[1]), "/")
+	header, body, err := p.headersAndBody()
+	if err != nil {
+		return nil, err
+	}
+	if _, hasLength := header[HeaderContentLength]; !hasLength {
+		if legacy, ok := header[legacyBodyHeader]; ok {
+			body = legacy
+			delete(header, legacyBodyHeader)
+		}
+	}
 
-	request := &HTTPRequest{
+	return &HTTPRequest{
 		Method: method,
-		Path:   path,
+		Path:   term,
+		Header: header,
+		Body:   body,
+	}, nil
+}
+
+func ParseHTTPResponse(data []byte) (*HTTPResponse, error) {
+	p := &messageParser{data: data}
+	line, offset, err := p.startLine()
+	if err != nil {
+		return nil, err
+	}
+
+	code, _, found := strings.Cut(line, " ")
+	if !found {
+		return nil, p.errorAt(offset, "status line must be \"<StatusCode> <StatusText>\"")
+	}
+	if len(code) != 3 {
+		return nil, p.errorAt(offset, fmt.Sprintf("invalid status code %q", code))
+	}
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || statusCode < 100 {
+		return nil, p.errorAt(offset, fmt.Sprintf("invalid status code %q", code))
+	}
+
+	header, body, err := p.headersAndBody()
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPResponse{
+		StatusCode: statusCode,
+		Header:     header,
+		Body:       body,
+	}, nil
+}
+
+type messageParser struct {
+	data []byte
+	pos  int // Início da próxima linha
+	line int // Número da última linha lida
+}
+
+func (p *messageParser) errorAt(offset int, msg string) *ParseError {
+	return &ParseError{Line: p.line, Offset: offset, Msg: msg}
+}
+
+// nextLine retorna a próxima linha, sem o "\r\n", e a posição onde ela começa.
+func (p *messageParser) nextLine() (string, int, bool) {
+	end := bytes.Index(p.data[p.pos:], []byte("\r\n"))
+	if end < 0 {
+		return "", p.pos, false
+	}
+	start := p.pos
+	p.pos += end + 2
+	p.line++
+	return string(p.data[start : start+end]), start, true
+}
+
+func (p *messageParser) startLine() (string, int, error) {
+	if len(p.data) == 0 {
+		return "", 0, &ParseError{Line: 1, Offset: 0, Msg: "empty message"}
+	}
+	line, offset, ok := p.nextLine()
+	if !ok {
+		return "", 0, &ParseError{Line: 1, Offset: len(p.data), Msg: "start line not terminated by CRLF"}
 	}
+	if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+		return "", 0, p.errorAt(offset+i, "bare CR or LF in start line")
+	}
+	return line, offset, nil
+}
 
-	for _, line := range lines[1:] {
-		if bytes.HasPrefix(line, []byte("Body: ")) {
-			request.Body = string(bytes.TrimPrefix(line, []byte("Body: ")))
+// headersAndBody lê os cabeçalhos até a linha vazia e confere o tamanho do
+// corpo que resta com o Content-Length.
+func (p *messageParser) headersAndBody() (Header, string, error) {
+	header := Header{}
+	lengthOffset, lengthLine := -1, 0
+	for {
+		line, offset, ok := p.nextLine()
+		if !ok {
+			p.line++
+			return nil, "", p.errorAt(len(p.data), "headers not terminated by an empty line")
+		}
+		if line == "" {
 			break
 		}
+		if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+			return nil, "", p.errorAt(offset+i, "bare CR or LF in header")
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, "", p.errorAt(offset, "header line must be \"Name: value\"")
+		}
+		if name == "" {
+			return nil, "", p.errorAt(offset, "empty header name")
+		}
+		for i := 0; i < len(name); i++ {
+			if !isTokenChar(name[i]) {
+				return nil, "", p.errorAt(offset+i, fmt.Sprintf("invalid character %q in header name", name[i]))
+			}
+		}
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		if _, dup := header[key]; dup {
+			return nil, "", p.errorAt(offset, fmt.Sprintf("duplicate header %q", key))
+		}
+		if key == HeaderContentLength {
+			lengthOffset, lengthLine = offset+len(name)+1, p.line
+		}
+		header[key] = strings.TrimPrefix(value, " ")
 	}
 
-	return request, nil
+	body := p.data[p.pos:]
+	p.line++
+	if lengthOffset < 0 {
+		if len(body) > 0 {
+			return nil, "", p.errorAt(p.pos, fmt.Sprintf("%d bytes of body without %s", len(body), HeaderContentLength))
+		}
+		return header, "", nil
+	}
+	length, err := strconv.Atoi(header[HeaderContentLength])
+	if err != nil || length < 0 {
+		return nil, "", &ParseError{
+			Line:   lengthLine,
+			Offset: lengthOffset,
+			Msg:    fmt.Sprintf("invalid %s %q", HeaderContentLength, header[HeaderContentLength]),
+		}
+	}
+	if length != len(body) {
+		return nil, "", p.errorAt(p.pos, fmt.Sprintf("body has %d bytes, %s is %d", len(body), HeaderContentLength, length))
+	}
+	return header, string(body), nil
+}
+
+// isTokenChar segue a definição de token da RFC 9110.
+func isTokenChar(c byte) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
 }
 
 func GetEmoji(statusCode int) string {
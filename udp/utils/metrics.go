@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Métricas de transporte do processo, expostas nos logs.
+
+type Metrics struct {
+	mux             sync.Mutex
+	PacketsSent     int64
+	PacketsReceived int64
+	PacketsLost     int64 // Perdas detectadas (timeout ou NACK)
+	Retransmissions int64
+	Dropped         int64 // Descartados pela simulação de perda
+	Evicted         int64 // Mensagens incompletas descartadas na remontagem
+	rttTotal        time.Duration
+	rttSamples      int64
+	Cwnd            int
+	Ssthresh        int
+	SRTT            time.Duration
+	RTO             time.Duration
+}
+
+var metrics = &Metrics{}
+
+func GetMetrics() *Metrics {
+	return metrics
+}
+
+func (m *Metrics) AddSent() {
+	m.mux.Lock()
+	m.PacketsSent++
+	m.mux.Unlock()
+}
+
+func (m *Metrics) AddReceived() {
+	m.mux.Lock()
+	m.PacketsReceived++
+	m.mux.Unlock()
+}
+
+func (m *Metrics) AddLost() {
+	m.mux.Lock()
+	m.PacketsLost++
+	m.mux.Unlock()
+}
+
+func (m *Metrics) AddRetransmission() {
+	m.mux.Lock()
+	m.Retransmissions++
+	m.mux.Unlock()
+}
+
+func (m *Metrics) AddDropped() {
+	m.mux.Lock()
+	m.Dropped++
+	m.mux.Unlock()
+}
+
+func (m *Metrics) AddEvicted() {
+	m.mux.Lock()
+	m.Evicted++
+	m.mux.Unlock()
+}
+
+func (m *Metrics) ObserveRTT(rtt time.Duration) {
+	m.mux.Lock()
+	m.rttTotal += rtt
+	m.rttSamples++
+	m.mux.Unlock()
+}
+
+func (m *Metrics) SetCongestion(cwnd, ssthresh int, srtt, rto time.Duration) {
+	m.mux.Lock()
+	m.Cwnd = cwnd
+	m.Ssthresh = ssthresh
+	m.SRTT = srtt
+	m.RTO = rto
+	m.mux.Unlock()
+}
+
+// Log registra um resumo das métricas coletadas até o momento.
+func (m *Metrics) Log(logger *zap.Logger) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	var avgLatency time.Duration
+	if m.rttSamples > 0 {
+		avgLatency = m.rttTotal / time.Duration(m.rttSamples)
+	}
+	var lossRate float64
+	if m.PacketsSent > 0 {
+		lossRate = float64(m.PacketsLost) / float64(m.PacketsSent) * 100
+	}
+	logger.Info("Transport metrics",
+		zap.Int64("packets_sent", m.PacketsSent),
+		zap.Int64("packets_received", m.PacketsReceived),
+		zap.Int64("packets_lost", m.PacketsLost),
+		zap.Int64("retransmissions", m.Retransmissions),
+		zap.Int64("simulated_drops", m.Dropped),
+		zap.Int64("evicted_messages", m.Evicted),
+		zap.Duration("avg_latency", avgLatency),
+		zap.Float64("loss_rate_percent", lossRate),
+		zap.Int("cwnd", m.Cwnd),
+		zap.Int("ssthresh", m.Ssthresh),
+		zap.Duration("srtt", m.SRTT),
+		zap.Duration("rto", m.RTO),
+	)
+}
+
+// SimulateLoss envolve a função de envio descartando pacotes com a
+// probabilidade informada, para observar o comportamento do protocolo em
+// enlaces com perda.
+func SimulateLoss(rate float64, write func(Packet) error) func(Packet) error {
+	if rate <= 0 {
+		return write
+	}
+	return func(p Packet) error {
+		if rand.Float64() < rate {
+			GetMetrics().AddDropped()
+			return nil
+		}
+		return write(p)
+	}
+}
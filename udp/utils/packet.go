@@ -1,142 +1,574 @@
 package utils
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 )
 
+// Versões do formato do pacote. A v1 é o formato original (Control, Length,
+// Payload, CRC), ainda aceito para que clientes antigos continuem funcionando.
+const (
+	ProtocolV1 uint8 = 1
+	ProtocolV2 uint8 = 2
+)
+
+// Identifica pacotes v2; datagramas que não começam com ele são tratados como v1
+const protocolMagic uint16 = 0x5544 // "UD"
+
+// Flags do header v2. Pacotes de dados não têm ACK nem NACK.
+const (
+	FlagSYN        uint8 = 1 << iota // Abertura de sessão
+	FlagACK                          // Confirmação do fragmento Index
+	FlagFIN                          // Encerramento de sessão
+	FlagNACK                         // Pedido de retransmissão do fragmento Index
+	FlagRST                          // Sessão desconhecida ou abortada
+	FlagCompressed                   // Payload comprimido (reservado)
+	FlagEncrypted                    // Payload cifrado (reservado)
+	FlagProbe                        // Sondagem do tamanho de datagrama (ver pmtu.go)
+
+	knownFlags = FlagSYN | FlagACK | FlagFIN | FlagNACK | FlagRST | FlagCompressed | FlagEncrypted | FlagProbe
+)
+
+const (
+	headerSize   = 17
+	headerSizeV1 = 4
+	crcSizeV1    = 2
+	maxCRCSize   = 4
+
+	// Maior payload que cabe em um datagrama UDP sobre IPv4
+	MaxPayload = 65507 - headerSize - maxCRCSize
+	// Tamanho de fragmento usado quando nada foi negociado, e o único aceito na v1
+	DefaultFragmentSize = 1024
+)
+
+// Erros de validação retornados por ParsePacket, acessíveis com errors.Is
+var (
+	ErrPacketTooShort     = errors.New("packet too short")
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrInvalidFlags       = errors.New("invalid flags")
+	ErrUnsupportedFlags   = errors.New("unsupported flags")
+	ErrLengthMismatch     = errors.New("payload length does not match datagram size")
+	ErrPayloadTooLarge    = errors.New("payload too large")
+	ErrInvalidFragment    = errors.New("invalid fragment index")
+	ErrUnknownChecksum    = errors.New("unknown checksum algorithm")
+)
+
+// ErrMessageTooLarge é retornado por NewPacket quando a mensagem não cabe em
+// math.MaxUint16 fragmentos.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// PacketError indica qual campo do header invalidou o pacote.
+type PacketError struct {
+	Field string
+	Err   error
+}
+
+func (e *PacketError) Error() string {
+	return fmt.Sprintf("invalid packet %s: %v", e.Field, e.Err)
+}
+
+func (e *PacketError) Unwrap() error {
+	return e.Err
+}
+
+// Quantos fragmentos posteriores precisam chegar antes que um fragmento
+// ausente seja considerado perdido (tolerância a reordenação, como os três
+// ACKs duplicados do TCP).
+const nackThreshold = 3
+
+// Tempo durante o qual uma mensagem completa continua registrada para que
+// fragmentos retransmitidos sejam reconhecidos sem gerar um novo processamento.
+const completedRetention = 30 * time.Second
+
 type Packet struct {
-	Control uint16
-	Length  uint16
-	Payload []byte
-	CRC     uint16
+	Version   uint8 // ProtocolV1 ou ProtocolV2 (zero é tratado como v2)
+	Flags     uint8
+	Checksum  ChecksumType // Algoritmo do campo CRC (ignorado na v1)
+	MessageID uint32       // Identifica a mensagem à qual o fragmento pertence
+	Index     uint16       // Índice do fragmento (0-based)
+	Total     uint16       // Quantidade total de fragmentos da mensagem
+	Window    uint16       // Janela de recepção anunciada por quem enviou o pacote
+	Payload   []byte
+	CRC       uint32
+}
+
+func (p Packet) Has(flag uint8) bool {
+	return p.Flags&flag != 0
+}
+
+func (p Packet) IsData() bool {
+	return p.Flags&(FlagACK|FlagNACK|FlagSYN|FlagFIN|FlagRST|FlagProbe) == 0
+}
+
+func (p Packet) IsLegacy() bool {
+	return p.Version == ProtocolV1
 }
 
 func (p Packet) Bytes() []byte {
-	data := make([]byte, 2+2+len(p.Payload)+2)
-	// Control
-	data[0] = byte(p.Control >> 8)
-	data[1] = byte(p.Control & 0xFF)
-	// Length
-	data[2] = byte(p.Length >> 8)
-	data[3] = byte(p.Length & 0xFF)
-	// Payload
-	copy(data[4:], p.Payload)
-	// CRC
-	data[len(data)-2] = byte(p.CRC >> 8)
-	data[len(data)-1] = byte(p.CRC & 0xFF)
+	if p.IsLegacy() {
+		return p.legacyBytes()
+	}
+	data := make([]byte, headerSize+len(p.Payload)+p.Checksum.Size())
+	binary.BigEndian.PutUint16(data[0:2], protocolMagic)
+	data[2] = ProtocolV2
+	data[3] = p.Flags
+	data[4] = uint8(p.Checksum)
+	binary.BigEndian.PutUint32(data[5:9], p.MessageID)
+	binary.BigEndian.PutUint16(data[9:11], p.Index)
+	binary.BigEndian.PutUint16(data[11:13], p.Total)
+	binary.BigEndian.PutUint16(data[13:15], p.Window)
+	binary.BigEndian.PutUint16(data[15:17], uint16(len(p.Payload)))
+	copy(data[headerSize:], p.Payload)
+	if p.Checksum.Size() == 2 {
+		binary.BigEndian.PutUint16(data[len(data)-2:], uint16(p.CRC))
+	} else {
+		binary.BigEndian.PutUint32(data[len(data)-4:], p.CRC)
+	}
+	return data
+}
+
+// legacyBytes serializa no formato v1: Control (índice), Length (total),
+// Payload e CRC.
+func (p Packet) legacyBytes() []byte {
+	data := make([]byte, headerSizeV1+len(p.Payload)+crcSizeV1)
+	binary.BigEndian.PutUint16(data[0:2], p.Index)
+	binary.BigEndian.PutUint16(data[2:4], p.Total)
+	copy(data[headerSizeV1:], p.Payload)
+	binary.BigEndian.PutUint16(data[len(data)-crcSizeV1:], uint16(p.CRC))
 	return data
 }
 
+// isV2 detecta o formato pelo magic. Um pacote v1 só seria confundido se o
+// seu índice fosse 0x5544, o que exigiria uma mensagem de mais de 21 MB.
+func isV2(data []byte) bool {
+	return len(data) >= 2 && binary.BigEndian.Uint16(data[0:2]) == protocolMagic
+}
+
 func ParsePacket(data []byte) (Packet, error) {
-	if len(data) < 4 {
-		return Packet{}, fmt.Errorf("invalid packet format")
-	}
-	control := uint16(data[0])<<8 | uint16(data[1])
-	length := uint16(data[2])<<8 | uint16(data[3])
-	crcStart := len(data) - 2
-	payload := data[4:crcStart]
-	crc := uint16(data[crcStart])<<8 | uint16(data[crcStart+1])
-	return Packet{
-		Control: control,
-		Length:  length,
-		Payload: payload,
-		CRC:     crc,
-	}, nil
-}
-
-func CalculateCRC(packet Packet) uint16 {
-	var crc uint16 = 0xFFFF
-	crcCalculator := NewCRC()
+	if !isV2(data) {
+		return parseLegacyPacket(data)
+	}
+	if len(data) < headerSize {
+		return Packet{}, &PacketError{"header", ErrPacketTooShort}
+	}
+	if data[2] != ProtocolV2 {
+		return Packet{}, &PacketError{"version", fmt.Errorf("%w: %d", ErrUnsupportedVersion, data[2])}
+	}
+	flags := data[3]
+	if flags&^knownFlags != 0 || flags&(FlagACK|FlagNACK) == FlagACK|FlagNACK {
+		return Packet{}, &PacketError{"flags", fmt.Errorf("%w: %#02x", ErrInvalidFlags, flags)}
+	}
+	if flags&(FlagCompressed|FlagEncrypted) != 0 {
+		return Packet{}, &PacketError{"flags", fmt.Errorf("%w: %#02x", ErrUnsupportedFlags, flags)}
+	}
+	checksum := ChecksumType(data[4])
+	if !checksum.Valid() {
+		return Packet{}, &PacketError{"checksum", fmt.Errorf("%w: %d", ErrUnknownChecksum, data[4])}
+	}
+	if len(data) < headerSize+checksum.Size() {
+		return Packet{}, &PacketError{"header", ErrPacketTooShort}
+	}
+	length := int(binary.BigEndian.Uint16(data[15:17]))
+	if actual := len(data) - headerSize - checksum.Size(); length != actual {
+		return Packet{}, &PacketError{"length", fmt.Errorf("%w: header says %d, datagram has %d", ErrLengthMismatch, length, actual)}
+	}
+	if length > MaxPayload {
+		return Packet{}, &PacketError{"length", fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, length)}
+	}
+	packet := Packet{
+		Version:   ProtocolV2,
+		Flags:     flags,
+		Checksum:  checksum,
+		MessageID: binary.BigEndian.Uint32(data[5:9]),
+		Index:     binary.BigEndian.Uint16(data[9:11]),
+		Total:     binary.BigEndian.Uint16(data[11:13]),
+		Window:    binary.BigEndian.Uint16(data[13:15]),
+		Payload:   data[headerSize : headerSize+length],
+	}
+	if checksum.Size() == 2 {
+		packet.CRC = uint32(binary.BigEndian.Uint16(data[headerSize+length:]))
+	} else {
+		packet.CRC = binary.BigEndian.Uint32(data[headerSize+length:])
+	}
+	if packet.IsData() && (packet.Total == 0 || packet.Index >= packet.Total) {
+		return Packet{}, &PacketError{"index", fmt.Errorf("%w: %d of %d", ErrInvalidFragment, packet.Index, packet.Total)}
+	}
+	return packet, nil
+}
+
+func parseLegacyPacket(data []byte) (Packet, error) {
+	if len(data) < headerSizeV1+crcSizeV1 {
+		return Packet{}, &PacketError{"header", ErrPacketTooShort}
+	}
+	crcStart := len(data) - crcSizeV1
+	if crcStart-headerSizeV1 > DefaultFragmentSize {
+		return Packet{}, &PacketError{"length", fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, crcStart-headerSizeV1)}
+	}
+	packet := Packet{
+		Version: ProtocolV1,
+		Index:   binary.BigEndian.Uint16(data[0:2]),
+		Total:   binary.BigEndian.Uint16(data[2:4]),
+		Payload: data[headerSizeV1:crcStart],
+		CRC:     uint32(binary.BigEndian.Uint16(data[crcStart:])),
+	}
+	if packet.Total == 0 || packet.Index >= packet.Total {
+		return Packet{}, &PacketError{"index", fmt.Errorf("%w: %d of %d", ErrInvalidFragment, packet.Index, packet.Total)}
+	}
+	return packet, nil
+}
+
+// CalculateCRC calcula o checksum do pacote com o algoritmo indicado em
+// Checksum. Na v2 o cálculo cobre header e payload; na v1 mantém o cálculo
+// original, que também inclui o campo CRC zerado.
+func CalculateCRC(packet Packet) uint32 {
 	packet.CRC = 0
-	crc = crcCalculator.Compute(crc, packet.Bytes())
-	return uint16(crc)
+	data := packet.Bytes()
+	if packet.IsLegacy() {
+		return ChecksumCRC16.Sum(data)
+	}
+	return packet.Checksum.Sum(data[:len(data)-packet.Checksum.Size()])
+}
+
+var messageIDCounter = rand.Uint32()
+
+// NextMessageID gera identificadores de mensagem para requisições. O valor
+// inicial aleatório evita colisões com mensagens de execuções anteriores.
+func NextMessageID() uint32 {
+	return atomic.AddUint32(&messageIDCounter, 1)
 }
 
-func NewPacket(payload []byte) []Packet {
+// NewPacket fragmenta a mensagem em pacotes v2 de até fragmentSize bytes de
+// payload (DefaultFragmentSize se não informado), com o checksum padrão; o
+// Sender aplica o algoritmo configurado antes de enviar. Uma mensagem vazia
+// ocupa um fragmento vazio. Retorna ErrMessageTooLarge se a mensagem precisar
+// de mais fragmentos do que cabem em Total.
+func NewPacket(messageID uint32, payload []byte, fragmentSize int) ([]Packet, error) {
 	logger := GetLogger()
-	logger.Info("Creating packets", zap.Int("payload_length", len(payload)))
-	partsQt := len(payload) / 1024
-	packets := make([]Packet, partsQt+1)
-	for i := 0; i <= partsQt; i++ {
-		start := i * 1024
-		end := start + 1024
-		if end > len(payload) {
-			end = len(payload)
-		}
-		partPayload := payload[start:end]
+	if fragmentSize <= 0 || fragmentSize > MaxPayload {
+		fragmentSize = DefaultFragmentSize
+	}
+	logger.Info("Creating packets", zap.Uint32("message_id", messageID), zap.Int("payload_length", len(payload)), zap.Int("fragment_size", fragmentSize))
+	total := max((len(payload)+fragmentSize-1)/fragmentSize, 1)
+	if total > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %d bytes need %d fragments of %d bytes, at most %d allowed",
+			ErrMessageTooLarge, len(payload), total, fragmentSize, math.MaxUint16)
+	}
+	packets := make([]Packet, total)
+	for i := range packets {
+		start := i * fragmentSize
+		end := min(start+fragmentSize, len(payload))
 		p := Packet{
-			Control: uint16(i),
-			Length:  uint16(partsQt + 1),
-			Payload: partPayload,
+			Version:   ProtocolV2,
+			Checksum:  DefaultChecksum,
+			MessageID: messageID,
+			Index:     uint16(i),
+			Total:     uint16(total),
+			Payload:   payload[start:end],
 		}
 		p.CRC = CalculateCRC(p)
 		packets[i] = p
 	}
 	logger.Info("Packets created", zap.Int("packets_count", len(packets)))
-	return packets
+	return packets, nil
 }
 
-func GetCompletePayload(packets []Packet) []byte {
-	var payload []byte
-	logger := GetLogger()
-	for i, packet := range packets {
-		logger.Info(
-			"Reconstructing payload",
-			zap.Int("packet_index", i),
-			zap.ByteString("Current payload: ", payload),
-		)
-		payload = append(payload, packet.Payload...)
+// NewLegacyPacket fragmenta a mensagem no formato v1, sem ID nem
+// confirmações, para responder a clientes antigos.
+func NewLegacyPacket(payload []byte) ([]Packet, error) {
+	packets, err := NewPacket(0, payload, DefaultFragmentSize)
+	if err != nil {
+		return nil, err
+	}
+	for i := range packets {
+		packets[i].Version = ProtocolV1
+		packets[i].CRC = CalculateCRC(packets[i])
+	}
+	return packets, nil
+}
+
+// messageKey identifica uma mensagem em remontagem: o mesmo ID pode ser usado
+// por origens diferentes.
+type messageKey struct {
+	origin string
+	id     uint32
+}
+
+// partialMessage guarda os fragmentos recebidos de uma mensagem, posicionados
+// pelo índice.
+type partialMessage struct {
+	total     uint16
+	fragments [][]byte
+	received  []bool
+	nacked    []bool
+	count     int
+	highest   int
+	bytes     int
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// StoreConfig limita os recursos usados por mensagens em remontagem, para que
+// remetentes que abandonam mensagens incompletas não esgotem a memória.
+type StoreConfig struct {
+	ReassemblyTimeout   time.Duration // Tempo sem novos fragmentos até descartar a mensagem incompleta
+	MaxBufferedBytes    int           // Total de bytes de payload guardados entre todas as mensagens
+	MaxPartialPerOrigin int           // Mensagens incompletas simultâneas por origem
+}
+
+func DefaultStoreConfig() StoreConfig {
+	return StoreConfig{
+		ReassemblyTimeout:   10 * time.Second,
+		MaxBufferedBytes:    8 << 20,
+		MaxPartialPerOrigin: 8,
 	}
-	return payload
 }
 
 type PacketStore struct {
-	Origins []string
-	Packets map[string][]Packet
+	config    StoreConfig
+	messages  map[messageKey]*partialMessage
+	completed map[messageKey]time.Time
+	origins   map[string]int // Mensagens incompletas por origem
+	buffered  int
 }
 
-func NewPacketStore() *PacketStore {
+func NewPacketStore(config StoreConfig) *PacketStore {
 	return &PacketStore{
-		Origins: []string{},
-		Packets: make(map[string][]Packet),
+		config:    config,
+		messages:  make(map[messageKey]*partialMessage),
+		completed: make(map[messageKey]time.Time),
+		origins:   make(map[string]int),
 	}
 }
 
-func (ps *PacketStore) AddPacket(origin string, packet Packet) {
+// AddPacket guarda o fragmento e retorna false quando ele já havia sido recebido
+// ou é inconsistente com os demais fragmentos da mensagem. Também retorna os
+// índices ausentes que ficaram pelo menos nackThreshold fragmentos para trás,
+// para que o receptor envie NACKs (uma vez por índice).
+func (ps *PacketStore) AddPacket(origin string, packet Packet) (bool, []uint16) {
 	logger := GetLogger()
-	for _, p := range ps.Packets[origin] {
+	now := time.Now()
+	ps.expire(now)
+	key := messageKey{origin, packet.MessageID}
+	msg, exists := ps.messages[key]
+	if !exists {
+		if packet.Total == 0 || packet.Index >= packet.Total {
+			return false, nil
+		}
+		// Uma origem com muitas mensagens incompletas perde a mais antiga
+		if limit := ps.config.MaxPartialPerOrigin; limit > 0 {
+			for ps.origins[origin] >= limit {
+				ps.evict(ps.oldest(origin), "origin limit", now)
+			}
+		}
+		msg = &partialMessage{
+			total:     packet.Total,
+			fragments: make([][]byte, packet.Total),
+			received:  make([]bool, packet.Total),
+			nacked:    make([]bool, packet.Total),
+			highest:   -1,
+			firstSeen: now,
+			lastSeen:  now,
+		}
+		ps.messages[key] = msg
+		ps.origins[origin]++
+	}
+	if packet.Total != msg.total || packet.Index >= msg.total {
 		logger.Info(
-			"Packets already in storage",
-			zap.Uint16("control", p.Control),
-			zap.Uint16("length", p.Length),
-			zap.ByteString("payload", p.Payload),
-			zap.Uint16("crc", p.CRC),
+			"Inconsistent packet discarded",
+			zap.String("origin", origin),
+			zap.Uint32("message_id", packet.MessageID),
+			zap.Uint16("index", packet.Index),
+			zap.Uint16("total", packet.Total),
+			zap.Uint16("expected_total", msg.total),
 		)
+		return false, nil
 	}
-	packetStorage := ps.Packets[origin]
-	packetStorage = append(packetStorage, packet)
-	for _, p := range packetStorage {
+	if msg.received[packet.Index] {
 		logger.Info(
-			"Current packets in storage",
-			zap.Uint16("control", p.Control),
-			zap.Uint16("length", p.Length),
-			zap.ByteString("payload", p.Payload),
-			zap.Uint16("crc", p.CRC),
+			"Duplicate packet discarded",
+			zap.String("origin", origin),
+			zap.Uint32("message_id", packet.MessageID),
+			zap.Uint16("index", packet.Index),
 		)
+		return false, nil
+	}
+
+	if limit := ps.config.MaxBufferedBytes; limit > 0 {
+		if len(packet.Payload) > limit {
+			if msg.count == 0 {
+				ps.remove(key)
+			}
+			return false, nil
+		}
+		// Libera espaço descartando as mensagens paradas há mais tempo
+		for ps.buffered+len(packet.Payload) > limit {
+			oldest := ps.oldest("")
+			if oldest == key {
+				logger.Warn(
+					"Reassembly buffer full, packet discarded",
+					zap.String("origin", origin),
+					zap.Uint32("message_id", packet.MessageID),
+					zap.Int("buffered_bytes", ps.buffered),
+				)
+				if msg.count == 0 {
+					ps.remove(key)
+				}
+				return false, nil
+			}
+			ps.evict(oldest, "memory limit", now)
+		}
 	}
-	ps.Packets[origin] = packetStorage
+
+	msg.fragments[packet.Index] = append([]byte(nil), packet.Payload...)
+	msg.received[packet.Index] = true
+	msg.count++
+	msg.bytes += len(packet.Payload)
+	msg.lastSeen = now
+	ps.buffered += len(packet.Payload)
+	if int(packet.Index) > msg.highest {
+		msg.highest = int(packet.Index)
+	}
+
+	var gaps []uint16
+	for i := 0; i <= msg.highest-nackThreshold; i++ {
+		if !msg.received[i] && !msg.nacked[i] {
+			msg.nacked[i] = true
+			gaps = append(gaps, uint16(i))
+		}
+	}
+	logger.Info(
+		"Current packets in storage",
+		zap.String("origin", origin),
+		zap.Uint32("message_id", packet.MessageID),
+		zap.Int("stored", msg.count),
+		zap.Uint16("total", msg.total),
+	)
+	return true, gaps
 }
 
-func (ps *PacketStore) IsComplete(origin string) bool {
-	if len(ps.Packets) == 0 {
-		return false
+// Base retorna o menor índice de fragmento ainda não recebido da mensagem,
+// início da janela de recepção.
+func (ps *PacketStore) Base(origin string, messageID uint32) uint16 {
+	msg, exists := ps.messages[messageKey{origin, messageID}]
+	if !exists {
+		return 0
+	}
+	var base uint16
+	for base < msg.total && msg.received[base] {
+		base++
 	}
-	return uint16(len(ps.Packets[origin])) == ps.Packets[origin][0].Length
+	return base
 }
 
-func (ps *PacketStore) AssemblePayload(origin string) []byte {
-	payload := GetCompletePayload(ps.Packets[origin])
+func (ps *PacketStore) IsComplete(origin string, messageID uint32) bool {
+	msg, exists := ps.messages[messageKey{origin, messageID}]
+	return exists && msg.count == int(msg.total)
+}
+
+// AssemblePayload concatena os fragmentos na ordem dos índices.
+func (ps *PacketStore) AssemblePayload(origin string, messageID uint32) []byte {
+	msg, exists := ps.messages[messageKey{origin, messageID}]
+	if !exists {
+		return nil
+	}
+	var payload []byte
+	for _, fragment := range msg.fragments {
+		payload = append(payload, fragment...)
+	}
 	return payload
 }
+
+// MarkCompleted descarta os fragmentos da mensagem e lembra que ela já foi entregue.
+func (ps *PacketStore) MarkCompleted(origin string, messageID uint32) {
+	now := time.Now()
+	for key, at := range ps.completed {
+		if now.Sub(at) > completedRetention {
+			delete(ps.completed, key)
+		}
+	}
+	key := messageKey{origin, messageID}
+	ps.remove(key)
+	ps.completed[key] = now
+}
+
+func (ps *PacketStore) IsCompleted(origin string, messageID uint32) bool {
+	at, exists := ps.completed[messageKey{origin, messageID}]
+	return exists && time.Since(at) <= completedRetention
+}
+
+// Expire descarta as mensagens incompletas sem novos fragmentos há mais de
+// ReassemblyTimeout e retorna quantas foram descartadas. Também é chamado a
+// cada fragmento recebido.
+func (ps *PacketStore) Expire() int {
+	return ps.expire(time.Now())
+}
+
+func (ps *PacketStore) expire(now time.Time) int {
+	if ps.config.ReassemblyTimeout <= 0 {
+		return 0
+	}
+	expired := 0
+	for key, msg := range ps.messages {
+		if now.Sub(msg.lastSeen) > ps.config.ReassemblyTimeout {
+			ps.evict(key, "timeout", now)
+			expired++
+		}
+	}
+	return expired
+}
+
+// oldest retorna a mensagem incompleta com o fragmento mais antigo, entre
+// todas as origens quando origin é vazio.
+func (ps *PacketStore) oldest(origin string) messageKey {
+	var oldest messageKey
+	var oldestSeen time.Time
+	for key, msg := range ps.messages {
+		if origin != "" && key.origin != origin {
+			continue
+		}
+		if oldestSeen.IsZero() || msg.lastSeen.Before(oldestSeen) {
+			oldest = key
+			oldestSeen = msg.lastSeen
+		}
+	}
+	return oldest
+}
+
+func (ps *PacketStore) evict(key messageKey, reason string, now time.Time) {
+	msg, exists := ps.messages[key]
+	if !exists {
+		return
+	}
+	GetLogger().Warn(
+		"Incomplete message evicted",
+		zap.String("origin", key.origin),
+		zap.Uint32("message_id", key.id),
+		zap.String("reason", reason),
+		zap.Int("received", msg.count),
+		zap.Uint16("total", msg.total),
+		zap.Int("bytes", msg.bytes),
+		zap.Duration("age", now.Sub(msg.firstSeen)),
+		zap.Duration("idle", now.Sub(msg.lastSeen)),
+	)
+	GetMetrics().AddEvicted()
+	ps.remove(key)
+}
+
+func (ps *PacketStore) remove(key messageKey) {
+	msg, exists := ps.messages[key]
+	if !exists {
+		return
+	}
+	ps.buffered -= msg.bytes
+	delete(ps.messages, key)
+	if ps.origins[key.origin]--; ps.origins[key.origin] <= 0 {
+		delete(ps.origins, key.origin)
+	}
+}
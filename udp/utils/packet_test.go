@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestNewPacket(t *testing.T) {
+	tests := []struct {
+		name         string
+		payload      int
+		fragmentSize int
+		wantTotal    int
+		wantLast     int // Tamanho do payload do último fragmento
+	}{
+		{"empty", 0, 8, 1, 0},
+		{"shorter than a fragment", 5, 8, 1, 5},
+		{"exactly one fragment", 8, 8, 1, 8},
+		{"exact multiple", 3 * 8, 8, 3, 8},
+		{"one byte over a multiple", 3*8 + 1, 8, 4, 1},
+		{"largest Total", math.MaxUint16, 1, math.MaxUint16, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := make([]byte, tt.payload)
+			for i := range payload {
+				payload[i] = byte(i)
+			}
+			packets, err := NewPacket(7, payload, tt.fragmentSize)
+			if err != nil {
+				t.Fatalf("NewPacket: %v", err)
+			}
+			if len(packets) != tt.wantTotal {
+				t.Fatalf("got %d fragments, want %d", len(packets), tt.wantTotal)
+			}
+			var joined []byte
+			for i, p := range packets {
+				if int(p.Index) != i || int(p.Total) != tt.wantTotal || p.MessageID != 7 {
+					t.Fatalf("fragment %d has Index %d, Total %d, MessageID %d", i, p.Index, p.Total, p.MessageID)
+				}
+				if !NewCRC().ValidatePacket(p) {
+					t.Fatalf("fragment %d has an invalid CRC", i)
+				}
+				joined = append(joined, p.Payload...)
+			}
+			if last := len(packets[len(packets)-1].Payload); last != tt.wantLast {
+				t.Errorf("last fragment has %d bytes, want %d", last, tt.wantLast)
+			}
+			if !bytes.Equal(joined, payload) {
+				t.Errorf("fragments do not add up to the payload")
+			}
+		})
+	}
+}
+
+func TestNewPacketTooManyFragments(t *testing.T) {
+	_, err := NewPacket(7, make([]byte, math.MaxUint16+1), 1)
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("NewPacket error = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestParsePacketRejects(t *testing.T) {
+	valid := func() []byte {
+		p := Packet{Version: ProtocolV2, Checksum: ChecksumCRC32C, MessageID: 1, Index: 0, Total: 2, Payload: []byte("abc")}
+		p.CRC = CalculateCRC(p)
+		return p.Bytes()
+	}
+	tests := []struct {
+		name  string
+		data  func() []byte
+		field string
+		err   error
+	}{
+		// Sem o magic o datagrama é lido como v1, e os bytes do header v2 não
+		// formam um Index/Total válido
+		{"bad magic", func() []byte { d := valid(); d[0] = 0x54; return d }, "index", ErrInvalidFragment},
+		{"short header", func() []byte { return valid()[:headerSize-1] }, "header", ErrPacketTooShort},
+		{"short v1 datagram", func() []byte { return []byte{0, 0, 0} }, "header", ErrPacketTooShort},
+		{"unknown version", func() []byte { d := valid(); d[2] = 9; return d }, "version", ErrUnsupportedVersion},
+		{"unknown flag bits", func() []byte { d := valid(); d[3] = 0xFF; return d }, "flags", ErrInvalidFlags},
+		{"ACK and NACK together", func() []byte { d := valid(); d[3] = FlagACK | FlagNACK; return d }, "flags", ErrInvalidFlags},
+		{"reserved flag", func() []byte { d := valid(); d[3] = FlagCompressed; return d }, "flags", ErrUnsupportedFlags},
+		{"unknown checksum", func() []byte { d := valid(); d[4] = 7; return d }, "checksum", ErrUnknownChecksum},
+		{"length mismatch", func() []byte { d := valid(); return append(d, 0) }, "length", ErrLengthMismatch},
+		{"zero Total", func() []byte { d := valid(); d[11], d[12] = 0, 0; return d }, "index", ErrInvalidFragment},
+		{"Index past Total", func() []byte { d := valid(); d[9], d[10] = 0, 2; return d }, "index", ErrInvalidFragment},
+		{"v1 Index past Total", func() []byte { return []byte{0, 3, 0, 2, 'x', 0, 0} }, "index", ErrInvalidFragment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePacket(tt.data())
+			var packetErr *PacketError
+			if !errors.As(err, &packetErr) || !errors.Is(err, tt.err) {
+				t.Fatalf("ParsePacket error = %v, want %v", err, tt.err)
+			}
+			if packetErr.Field != tt.field {
+				t.Errorf("PacketError.Field = %q, want %q", packetErr.Field, tt.field)
+			}
+		})
+	}
+}
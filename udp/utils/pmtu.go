@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Descoberta do maior datagrama que atravessa o caminho até o servidor (path
+// MTU). O cliente envia pacotes de sondagem (flag PROBE) de tamanhos
+// diferentes, com o bit DF ligado quando o sistema permite, e o servidor
+// confirma cada um que recebe. O resultado é proposto como tamanho máximo de
+// fragmento no SYN.
+
+const (
+	// Payload que cabe no menor datagrama que todo host IPv4 aceita (576 bytes)
+	minProbeFragment = 512
+	probeAttempts    = 2
+	ipv4Overhead     = 20 + 8 // IPv4 + UDP
+	ipv6Overhead     = 40 + 8 // IPv6 + UDP
+)
+
+var ErrDontFragmentUnsupported = errors.New("don't fragment not supported on this platform")
+
+// DatagramSize retorna o tamanho do maior datagrama com fragmentos de até
+// fragmentSize bytes, usado para dimensionar os buffers de leitura.
+func DatagramSize(fragmentSize int) int {
+	if fragmentSize <= 0 {
+		fragmentSize = DefaultFragmentSize
+	}
+	return headerSize + fragmentSize + maxCRCSize
+}
+
+// FragmentSizeForMTU retorna a maior payload que cabe em um pacote IP de mtu bytes.
+func FragmentSizeForMTU(mtu int, ipv6 bool) int {
+	overhead := ipv4Overhead
+	if ipv6 {
+		overhead = ipv6Overhead
+	}
+	return min(mtu-overhead-headerSize-maxCRCSize, MaxPayload)
+}
+
+func NewProbePacket(id uint32, size int, checksum ChecksumType) Packet {
+	p := Packet{
+		Version:   ProtocolV2,
+		Flags:     FlagProbe,
+		Checksum:  checksum,
+		MessageID: id,
+		Payload:   make([]byte, size),
+	}
+	p.CRC = CalculateCRC(p)
+	return p
+}
+
+// NewProbeAckPacket confirma uma sondagem informando o tamanho recebido.
+func NewProbeAckPacket(probe Packet) Packet {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(len(probe.Payload)))
+	p := Packet{
+		Version:   ProtocolV2,
+		Flags:     FlagProbe | FlagACK,
+		Checksum:  probe.Checksum,
+		MessageID: probe.MessageID,
+		Payload:   payload,
+	}
+	p.CRC = CalculateCRC(p)
+	return p
+}
+
+// ProbeFragmentSize procura, por busca binária entre minProbeFragment e o
+// limite imposto pelo MTU da interface local, o maior fragmento que chega
+// ao servidor.
+func ProbeFragmentSize(conn *net.UDPConn, config ARQConfig) int {
+	logger := GetLogger()
+	if err := setDontFragment(conn); err != nil {
+		logger.Warn("Probing without DF; IP fragmentation may hide the path MTU", zap.Error(err))
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	high := FragmentSizeForMTU(interfaceMTU(local.IP), local.IP.To4() == nil)
+	low := minProbeFragment
+	buffer := make([]byte, DatagramSize(minProbeFragment))
+	for low < high {
+		size := (low + high + 1) / 2
+		if probe(conn, size, buffer, config) {
+			low = size
+		} else {
+			high = size - 1
+		}
+		logger.Debug("Probe result", zap.Int("size", size), zap.Int("low", low), zap.Int("high", high))
+	}
+	logger.Info("Fragment size discovered", zap.Int("fragment_size", low), zap.Int("datagram_size", DatagramSize(low)))
+	return low
+}
+
+func probe(conn *net.UDPConn, size int, buffer []byte, config ARQConfig) bool {
+	id := NextMessageID()
+	packet := NewProbePacket(id, size, config.Checksum)
+	for attempt := 0; attempt < probeAttempts; attempt++ {
+		// Com DF, datagramas maiores que o MTU conhecido falham já no envio (EMSGSIZE)
+		if _, err := conn.Write(packet.Bytes()); err != nil {
+			return false
+		}
+		deadline := time.Now().Add(config.Timeout)
+		for {
+			conn.SetReadDeadline(deadline)
+			n, err := conn.Read(buffer)
+			if err != nil {
+				break
+			}
+			reply, err := ParsePacket(buffer[:n])
+			if err != nil || !NewCRC().ValidatePacket(reply) {
+				continue
+			}
+			if reply.Has(FlagProbe) && reply.Has(FlagACK) && reply.MessageID == id &&
+				len(reply.Payload) == 2 && int(binary.BigEndian.Uint16(reply.Payload)) == size {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// interfaceMTU retorna o MTU da interface que possui o endereço local, ou o
+// MTU típico de Ethernet se ela não for encontrada.
+func interfaceMTU(ip net.IP) int {
+	const ethernetMTU = 1500
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return ethernetMTU
+	}
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.MTU
+			}
+		}
+	}
+	return ethernetMTU
+}
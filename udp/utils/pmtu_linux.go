@@ -0,0 +1,29 @@
+//go:build linux
+
+package utils
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment liga o bit DF (IP_PMTUDISC_DO): o kernel deixa de fragmentar
+// os datagramas e recusa com EMSGSIZE os maiores que o path MTU conhecido.
+func setDontFragment(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+			return
+		}
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package utils
+
+import "net"
+
+func setDontFragment(conn *net.UDPConn) error {
+	return ErrDontFragmentUnsupported
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Handshake de sessão, inspirado no TCP: o cliente envia SYN com os parâmetros
+// que propõe, o servidor responde SYN+ACK com o ID da sessão e os parâmetros
+// aceitos, e o cliente confirma com ACK. FIN encerra a sessão e RST avisa que
+// ela não existe mais.
+//
+// SYN e SYN+ACK carregam na payload o tamanho máximo de fragmento (uint16);
+// a janela e o checksum vão nos campos Window e Checksum do header. O ID da
+// sessão vai no campo MessageID de SYN+ACK, do ACK final, de FIN e de RST.
+
+var ErrSessionReset = errors.New("session reset by peer")
+
+type SessionParams struct {
+	SessionID   uint32
+	MaxFragment uint16 // Maior payload por fragmento
+	Window      uint16
+	Checksum    ChecksumType
+}
+
+func (p SessionParams) handshakePacket(flags uint8) Packet {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, p.MaxFragment)
+	packet := Packet{
+		Version:   ProtocolV2,
+		Flags:     flags,
+		Checksum:  p.Checksum,
+		MessageID: p.SessionID,
+		Window:    p.Window,
+		Payload:   payload,
+	}
+	packet.CRC = CalculateCRC(packet)
+	return packet
+}
+
+func NewSynPacket(params SessionParams) Packet {
+	return params.handshakePacket(FlagSYN)
+}
+
+func NewSynAckPacket(params SessionParams) Packet {
+	return params.handshakePacket(FlagSYN | FlagACK)
+}
+
+// ParseSessionParams lê os parâmetros de um SYN ou SYN+ACK.
+func ParseSessionParams(packet Packet) (SessionParams, error) {
+	if !packet.Has(FlagSYN) {
+		return SessionParams{}, fmt.Errorf("not a handshake packet (flags %#02x)", packet.Flags)
+	}
+	if len(packet.Payload) < 2 {
+		return SessionParams{}, &PacketError{"payload", ErrPacketTooShort}
+	}
+	return SessionParams{
+		SessionID:   packet.MessageID,
+		MaxFragment: binary.BigEndian.Uint16(packet.Payload),
+		Window:      packet.Window,
+		Checksum:    packet.Checksum,
+	}, nil
+}
+
+func newSessionControlPacket(flags uint8, sessionID uint32, checksum ChecksumType) Packet {
+	packet := Packet{
+		Version:   ProtocolV2,
+		Flags:     flags,
+		Checksum:  checksum,
+		MessageID: sessionID,
+	}
+	packet.CRC = CalculateCRC(packet)
+	return packet
+}
+
+func NewFinPacket(sessionID uint32, checksum ChecksumType) Packet {
+	return newSessionControlPacket(FlagFIN, sessionID, checksum)
+}
+
+func NewFinAckPacket(sessionID uint32, checksum ChecksumType) Packet {
+	return newSessionControlPacket(FlagFIN|FlagACK, sessionID, checksum)
+}
+
+func NewRstPacket(id uint32, checksum ChecksumType) Packet {
+	return newSessionControlPacket(FlagRST, id, checksum)
+}
@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fragment monta o fragmento index de total da mensagem id, com o payload
+// indicado.
+func fragment(id uint32, index, total uint16, payload string) Packet {
+	return Packet{Version: ProtocolV2, MessageID: id, Index: index, Total: total, Payload: []byte(payload)}
+}
+
+func TestPacketStoreReassembly(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []uint16
+	}{
+		{"in order", []uint16{0, 1, 2, 3}},
+		{"reversed", []uint16{3, 2, 1, 0}},
+		{"shuffled", []uint16{2, 0, 3, 1}},
+	}
+	parts := []string{"aa", "bb", "cc", "d"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := NewPacketStore(DefaultStoreConfig())
+			for i, index := range tt.order {
+				if ps.IsComplete("a", 1) {
+					t.Fatalf("message complete after %d of 4 fragments", i)
+				}
+				if added, _ := ps.AddPacket("a", fragment(1, index, 4, parts[index])); !added {
+					t.Fatalf("fragment %d rejected", index)
+				}
+			}
+			if !ps.IsComplete("a", 1) {
+				t.Fatalf("message incomplete after every fragment")
+			}
+			if got := ps.AssemblePayload("a", 1); !bytes.Equal(got, []byte("aabbccd")) {
+				t.Errorf("AssemblePayload = %q, want %q", got, "aabbccd")
+			}
+			ps.MarkCompleted("a", 1)
+			if !ps.IsCompleted("a", 1) || ps.buffered != 0 {
+				t.Errorf("completed message still buffered (%d bytes)", ps.buffered)
+			}
+		})
+	}
+}
+
+func TestPacketStoreRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  Packet
+		second Packet
+	}{
+		{"duplicate fragment", fragment(1, 0, 2, "aa"), fragment(1, 0, 2, "zz")},
+		{"different Total", fragment(1, 0, 2, "aa"), fragment(1, 1, 3, "bb")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := NewPacketStore(DefaultStoreConfig())
+			if added, _ := ps.AddPacket("a", tt.first); !added {
+				t.Fatalf("first fragment rejected")
+			}
+			if added, _ := ps.AddPacket("a", tt.second); added {
+				t.Fatalf("second fragment accepted")
+			}
+			if ps.buffered != len(tt.first.Payload) {
+				t.Errorf("buffered = %d bytes, want %d", ps.buffered, len(tt.first.Payload))
+			}
+		})
+	}
+}
+
+// O mesmo ID vindo de origens diferentes identifica mensagens diferentes.
+func TestPacketStoreSameIDFromDifferentOrigins(t *testing.T) {
+	ps := NewPacketStore(DefaultStoreConfig())
+	ps.AddPacket("a", fragment(1, 0, 2, "a0"))
+	ps.AddPacket("b", fragment(1, 0, 2, "b0"))
+	if added, _ := ps.AddPacket("b", fragment(1, 1, 2, "b1")); !added {
+		t.Fatalf("fragment from the second origin rejected")
+	}
+	if !ps.IsComplete("b", 1) || ps.IsComplete("a", 1) {
+		t.Fatalf("IsComplete mixed up the origins")
+	}
+	if got := ps.AssemblePayload("b", 1); !bytes.Equal(got, []byte("b0b1")) {
+		t.Errorf("AssemblePayload = %q, want %q", got, "b0b1")
+	}
+}
+
+// Duas mensagens da mesma origem em trânsito ao mesmo tempo não se misturam,
+// e fragmentos repetidos não completam uma mensagem antes da hora.
+func TestPacketStoreInterleavedMessages(t *testing.T) {
+	ps := NewPacketStore(DefaultStoreConfig())
+	arrivals := []Packet{
+		fragment(1, 0, 3, "1a"),
+		fragment(2, 1, 2, "2b"),
+		fragment(1, 0, 3, "1a"),
+		fragment(1, 2, 3, "1c"),
+		fragment(1, 2, 3, "1c"),
+		fragment(2, 0, 2, "2a"),
+	}
+	for _, p := range arrivals {
+		ps.AddPacket("a", p)
+	}
+	if ps.IsComplete("a", 1) {
+		t.Fatalf("message 1 complete with 2 distinct fragments of 3")
+	}
+	if got := ps.AssemblePayload("a", 2); !bytes.Equal(got, []byte("2a2b")) {
+		t.Errorf("message 2 = %q, want %q", got, "2a2b")
+	}
+	ps.MarkCompleted("a", 2)
+	if !ps.IsCompleted("a", 2) || ps.IsCompleted("a", 1) {
+		t.Errorf("IsCompleted mixed up the message IDs")
+	}
+	ps.AddPacket("a", fragment(1, 1, 3, "1b"))
+	if got := ps.AssemblePayload("a", 1); !bytes.Equal(got, []byte("1a1b1c")) {
+		t.Errorf("message 1 = %q, want %q", got, "1a1b1c")
+	}
+}
+
+func TestPacketStoreGaps(t *testing.T) {
+	ps := NewPacketStore(DefaultStoreConfig())
+	var gaps []uint16
+	for _, index := range []uint16{0, 2, 3, 4, 5} {
+		_, g := ps.AddPacket("a", fragment(1, index, 8, "x"))
+		gaps = append(gaps, g...)
+	}
+	// O índice 1 é reportado uma única vez, quando fica nackThreshold para trás
+	if !slices.Equal(gaps, []uint16{1}) {
+		t.Errorf("gaps = %v, want [1]", gaps)
+	}
+	if base := ps.Base("a", 1); base != 1 {
+		t.Errorf("Base = %d, want 1", base)
+	}
+}
+
+func TestPacketStoreReassemblyTimeout(t *testing.T) {
+	ps := NewPacketStore(StoreConfig{ReassemblyTimeout: time.Second})
+	ps.AddPacket("a", fragment(1, 0, 2, "aa"))
+	if expired := ps.expire(time.Now().Add(time.Second / 2)); expired != 0 {
+		t.Fatalf("%d messages expired before the timeout", expired)
+	}
+	if expired := ps.expire(time.Now().Add(2 * time.Second)); expired != 1 {
+		t.Fatalf("%d messages expired after the timeout, want 1", expired)
+	}
+	if ps.buffered != 0 || ps.origins["a"] != 0 {
+		t.Errorf("expired message still accounted: %d bytes, %d per origin", ps.buffered, ps.origins["a"])
+	}
+	// Um fragmento tardio recomeça a mensagem do zero
+	ps.AddPacket("a", fragment(1, 1, 2, "bb"))
+	if ps.IsComplete("a", 1) {
+		t.Errorf("message complete with a fragment from before the timeout")
+	}
+}
+
+func TestPacketStoreEviction(t *testing.T) {
+	tests := []struct {
+		name   string
+		config StoreConfig
+		// Mensagens que recebem um fragmento, em ordem; a primeira é a mais antiga
+		add     []messageKey
+		evicted []messageKey
+	}{
+		{
+			name:    "memory limit evicts the oldest message",
+			config:  StoreConfig{MaxBufferedBytes: 4},
+			add:     []messageKey{{"a", 1}, {"b", 2}, {"c", 3}},
+			evicted: []messageKey{{"a", 1}},
+		},
+		{
+			name:    "origin limit evicts that origin's oldest message",
+			config:  StoreConfig{MaxPartialPerOrigin: 2},
+			add:     []messageKey{{"a", 1}, {"b", 1}, {"a", 2}, {"a", 3}},
+			evicted: []messageKey{{"a", 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := NewPacketStore(tt.config)
+			base := time.Now().Add(-time.Minute)
+			for i, key := range tt.add {
+				if added, _ := ps.AddPacket(key.origin, fragment(key.id, 0, 2, "xx")); !added {
+					t.Fatalf("fragment of %v rejected", key)
+				}
+				// Horários distintos deixam a ordem de despejo determinística
+				ps.messages[key].lastSeen = base.Add(time.Duration(i) * time.Second)
+			}
+			for _, key := range tt.add {
+				_, buffered := ps.messages[key]
+				if want := !slices.Contains(tt.evicted, key); buffered != want {
+					t.Errorf("message %v buffered = %t, want %t", key, buffered, want)
+				}
+			}
+			if want := 2 * (len(tt.add) - len(tt.evicted)); ps.buffered != want {
+				t.Errorf("buffered = %d bytes, want %d", ps.buffered, want)
+			}
+		})
+	}
+}
+
+// Um fragmento maior que todo o buffer não despeja as demais mensagens.
+func TestPacketStoreFragmentOverMemoryLimit(t *testing.T) {
+	ps := NewPacketStore(StoreConfig{MaxBufferedBytes: 4})
+	ps.AddPacket("a", fragment(1, 0, 2, "aa"))
+	if added, _ := ps.AddPacket("b", fragment(2, 0, 2, "12345")); added {
+		t.Fatalf("fragment larger than the buffer accepted")
+	}
+	if _, buffered := ps.messages[messageKey{"a", 1}]; !buffered {
+		t.Errorf("message evicted for a fragment that could never fit")
+	}
+	if _, buffered := ps.messages[messageKey{"b", 2}]; buffered || ps.origins["b"] != 0 {
+		t.Errorf("rejected fragment left an empty message behind")
+	}
+}